@@ -0,0 +1,228 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+// Page size limits for /address/{addr}/transactions
+const (
+	defaultHistoryPageSize = 25
+	maxHistoryPageSize     = 100
+)
+
+// rewardPosition marks a block reward in the address index. It sorts before
+// the block's transactions.
+const rewardPosition = -1
+
+// ErrInvalidCursor is returned for a malformed history cursor
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// historyRef points at a transaction (Position >= 0) or a block reward in the
+// canonical chain
+type historyRef struct {
+	Block    int64
+	Position int
+}
+
+func (r historyRef) before(o historyRef) bool {
+	return r.Block < o.Block || (r.Block == o.Block && r.Position < o.Position)
+}
+
+// cursor encodes a ref for the next page request
+func (r historyRef) cursor() string {
+	return fmt.Sprintf("%d:%d", r.Block, r.Position)
+}
+
+func parseHistoryCursor(s string) (historyRef, error) {
+	var ref historyRef
+	if _, err := fmt.Sscanf(s, "%d:%d", &ref.Block, &ref.Position); err != nil || ref.Block < 0 || ref.Position < rewardPosition {
+		return historyRef{}, ErrInvalidCursor
+	}
+	return ref, nil
+}
+
+// AddressActivity is one entry of an address's history, newest first
+type AddressActivity struct {
+	Type        string `json:"type"` // sent, received, self or reward
+	BlockNumber int64  `json:"blockNumber"`
+	BlockHash   string `json:"blockHash"`
+	Timestamp   int64  `json:"timestamp"`
+	Hash        string `json:"hash,omitempty"`
+	Index       *int   `json:"transactionIndex,omitempty"`
+	From        string `json:"from,omitempty"`
+	To          string `json:"to,omitempty"`
+	Value       string `json:"value"` // amount transferred, or the block reward
+	Fee         string `json:"fee"`   // fee paid, or fees collected by the block producer
+}
+
+// AddressSummary is the current state of an address and counts of its history
+type AddressSummary struct {
+	Address        string `json:"address"`
+	Balance        string `json:"balance"`
+	Nonce          int64  `json:"nonce"`
+	Staked         string `json:"staked"`
+	Unbonding      string `json:"unbonding"` // unstaked, not yet withdrawn
+	Delegated      string `json:"delegated"` // bonded to validators
+	Sent           int    `json:"sentCount"`
+	Received       int    `json:"receivedCount"`
+	BlocksProduced int    `json:"blocksProduced"`
+	Pending        int    `json:"pendingCount"`
+}
+
+// indexAddresses records a canonical block's transactions and reward in the
+// history of the addresses involved. Callers must hold bc.mu.
+func (bc *Blockchain) indexAddresses(block *Block) {
+	if producer := blockProducer(block); producer != "" {
+		bc.addHistory(producer, historyRef{block.Index, rewardPosition})
+	}
+	for i, tx := range block.Transactions {
+		bc.addHistory(tx.From, historyRef{block.Index, i})
+		if normalizeAddress(tx.To) != normalizeAddress(tx.From) {
+			bc.addHistory(tx.To, historyRef{block.Index, i})
+		}
+	}
+}
+
+func (bc *Blockchain) addHistory(address string, ref historyRef) {
+	address = normalizeAddress(address)
+	bc.history[address] = append(bc.history[address], ref)
+}
+
+// unindexAddresses drops the history entries of a block taken off the head of
+// the canonical chain. Callers must hold bc.mu.
+func (bc *Blockchain) unindexAddresses(block *Block) {
+	addresses := []string{blockProducer(block)}
+	for _, tx := range block.Transactions {
+		addresses = append(addresses, tx.From, tx.To)
+	}
+	for _, address := range addresses {
+		address = normalizeAddress(address)
+		refs := bc.history[address]
+		for len(refs) > 0 && refs[len(refs)-1].Block >= block.Index {
+			refs = refs[:len(refs)-1]
+		}
+		if len(refs) == 0 {
+			delete(bc.history, address)
+		} else {
+			bc.history[address] = refs
+		}
+	}
+}
+
+// AddressSummary returns the balance, nonce, stake and history counts of an address
+func (bc *Blockchain) AddressSummary(address string) AddressSummary {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	acct := bc.State.GetAccount(address)
+	summary := AddressSummary{
+		Address:   address,
+		Balance:   acct.Balance.String(),
+		Nonce:     acct.Nonce,
+		Staked:    "0",
+		Unbonding: unbondingTotal(&acct).String(),
+	}
+	if acct.Validator != nil {
+		summary.Staked = acct.Validator.Stake.String()
+	}
+	delegated := big.NewInt(0)
+	for _, del := range acct.Delegations {
+		delegated.Add(delegated, del.Amount)
+	}
+	summary.Delegated = delegated.String()
+
+	address = normalizeAddress(address)
+	for _, ref := range bc.history[address] {
+		if ref.Position == rewardPosition {
+			summary.BlocksProduced++
+			continue
+		}
+		tx := bc.Blocks[ref.Block].Transactions[ref.Position]
+		if normalizeAddress(tx.From) == address {
+			summary.Sent++
+		}
+		if normalizeAddress(tx.To) == address {
+			summary.Received++
+		}
+	}
+	for _, tx := range bc.pool.Transactions(bc.State) {
+		if normalizeAddress(tx.From) == address || normalizeAddress(tx.To) == address {
+			summary.Pending++
+		}
+	}
+	return summary
+}
+
+// AddressHistory returns up to limit entries of an address's history, newest
+// first, starting after cursor (or at the newest entry if cursor is empty).
+// next is the cursor for the following page, empty on the last page.
+func (bc *Blockchain) AddressHistory(address, cursor string, limit int) (entries []AddressActivity, next string, err error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	address = normalizeAddress(address)
+	refs := bc.history[address]
+	end := len(refs)
+	if cursor != "" {
+		after, err := parseHistoryCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		// Entries strictly older than the cursor
+		end = sort.Search(len(refs), func(i int) bool { return !refs[i].before(after) })
+	}
+
+	entries = []AddressActivity{}
+	for i := end - 1; i >= 0 && len(entries) < limit; i-- {
+		entries = append(entries, bc.addressActivity(address, refs[i]))
+	}
+	if start := end - len(entries); start > 0 {
+		next = refs[start].cursor()
+	}
+	return entries, next, nil
+}
+
+// addressActivity describes ref from the point of view of address. Callers
+// must hold bc.mu.
+func (bc *Blockchain) addressActivity(address string, ref historyRef) AddressActivity {
+	block := &bc.Blocks[ref.Block]
+	entry := AddressActivity{
+		BlockNumber: block.Index,
+		BlockHash:   block.Hash,
+		Timestamp:   block.Timestamp,
+	}
+
+	if ref.Position == rewardPosition {
+		fees := big.NewInt(0)
+		for _, receipt := range bc.blockReceipts(block) {
+			fee, _ := new(big.Int).SetString(receipt.Fee, 10)
+			fees.Add(fees, fee)
+		}
+		entry.Type = "reward"
+		entry.Value = block.Reward
+		entry.Fee = fees.String()
+		return entry
+	}
+
+	tx := block.Transactions[ref.Position]
+	receipt := bc.receipts[tx.Hash]
+	index := ref.Position
+	entry.Hash = tx.Hash
+	entry.Index = &index
+	entry.From = tx.From
+	entry.To = tx.To
+	entry.Value = tx.Value
+	entry.Fee = receipt.Fee
+	switch from, to := normalizeAddress(tx.From), normalizeAddress(tx.To); {
+	case from == address && to == address:
+		entry.Type = "self"
+	case from == address:
+		entry.Type = "sent"
+	default:
+		entry.Type = "received"
+	}
+	return entry
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// historyPage is the body of an /address/{addr}/transactions response
+type historyPage struct {
+	Transactions []AddressActivity `json:"transactions"`
+	NextCursor   string            `json:"nextCursor"`
+}
+
+func TestAddressHistoryCursor(t *testing.T) {
+	bc, _ := minedTransfers(t, 5)
+	blockchain = bc
+	sender := bc.Blocks[1].Transactions[0].From
+	path := "/address/" + sender + "/transactions?limit=2"
+
+	// Newest first, each page continuing where the last one stopped
+	var positions []int
+	cursor := ""
+	for pages := 0; ; pages++ {
+		var page historyPage
+		if code := get(t, handleAddress, path+"&cursor="+cursor, &page); code != http.StatusOK {
+			t.Fatalf("page %d: status %d", pages, code)
+		}
+		for _, entry := range page.Transactions {
+			if entry.Type != "sent" || entry.BlockNumber != 1 {
+				t.Fatalf("entry %+v", entry)
+			}
+			positions = append(positions, *entry.Index)
+		}
+		if page.NextCursor == "" {
+			if len(page.Transactions) != 1 {
+				t.Fatalf("last page holds %d entries, want 1", len(page.Transactions))
+			}
+			break
+		}
+		if len(page.Transactions) != 2 || pages > 3 {
+			t.Fatalf("page %d holds %d entries with cursor %q", pages, len(page.Transactions), page.NextCursor)
+		}
+		cursor = page.NextCursor
+	}
+	for i, position := range positions {
+		if position != 4-i {
+			t.Fatalf("history positions %v, want 4 down to 0", positions)
+		}
+	}
+}
+
+func TestAddressHistoryRewardsAndBadCursors(t *testing.T) {
+	bc := testChain(t, testGenesis(t))
+	miner := "0x1111111111111111111111111111111111111111"
+	mineBlocks(t, bc, miner, 3)
+
+	entries, next, err := bc.AddressHistory(miner, "", 10)
+	if err != nil || next != "" || len(entries) != 3 {
+		t.Fatalf("history = %d entries, next %q, %v", len(entries), next, err)
+	}
+	if entries[0].Type != "reward" || entries[0].BlockNumber != 3 || entries[0].Value != bc.Blocks[3].Reward {
+		t.Fatalf("newest entry %+v, want the reward of block #3", entries[0])
+	}
+	// A cursor past every entry ends the history
+	if entries, next, _ := bc.AddressHistory(miner, "1:-1", 10); len(entries) != 0 || next != "" {
+		t.Fatalf("history before the first entry = %d entries, next %q", len(entries), next)
+	}
+
+	for _, cursor := range []string{"abc", "1", "-1:0", "1:-2"} {
+		if _, _, err := bc.AddressHistory(miner, cursor, 10); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("cursor %q: %v, want %v", cursor, err, ErrInvalidCursor)
+		}
+	}
+	blockchain = bc
+	if code := get(t, handleAddress, "/address/"+miner+"/transactions?cursor=abc", nil); code != http.StatusBadRequest {
+		t.Fatalf("invalid cursor: status %d", code)
+	}
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"log"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// ErrKnownBlock is returned by AddBlock for a block already in the chain
+var ErrKnownBlock = errors.New("block already known")
+
+// ChainListener is notified of blocks and transactions accepted by the chain
+// and of reorgs. Callbacks run while the chain lock is held and must not block.
+type ChainListener interface {
+	OnNewBlock(block Block)
+	OnNewTransaction(tx Transaction)
+	OnReorg(event ReorgEvent)
+}
+
+// AddListener registers l for chain notifications
+func (bc *Blockchain) AddListener(l ChainListener) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.listeners = append(bc.listeners, l)
+}
+
+// nextBlockTimestamp returns the timestamp for a block built on prev,
+// keeping timestamps strictly increasing
+func nextBlockTimestamp(prev Block) int64 {
+	now := time.Now().Unix()
+	if now <= prev.Timestamp {
+		return prev.Timestamp + 1
+	}
+	return now
+}
+
+// Header returns the block without its transactions
+func (b *Block) Header() BlockHeader {
+	return BlockHeader{
+		Index:          b.Index,
+		Timestamp:      b.Timestamp,
+		PreviousHash:   b.PreviousHash,
+		TxRoot:         b.TxRoot,
+		ReceiptsRoot:   b.ReceiptsRoot,
+		StateRoot:      b.StateRoot,
+		ValidatorsRoot: b.ValidatorsRoot,
+		Hash:           b.Hash,
+		Nonce:          b.Nonce,
+		Difficulty:     b.Difficulty,
+		Miner:          b.Miner,
+		Validator:      b.Validator,
+		Type:           b.Type,
+		Reward:         b.Reward,
+		Signature:      b.Signature,
+	}
+}
+
+// ToBlock assembles a full block from a header and its transactions
+func (h BlockHeader) ToBlock(txs []Transaction) Block {
+	if txs == nil {
+		txs = []Transaction{}
+	}
+	return Block{
+		Index:          h.Index,
+		Timestamp:      h.Timestamp,
+		Transactions:   txs,
+		PreviousHash:   h.PreviousHash,
+		TxRoot:         h.TxRoot,
+		ReceiptsRoot:   h.ReceiptsRoot,
+		StateRoot:      h.StateRoot,
+		ValidatorsRoot: h.ValidatorsRoot,
+		Hash:           h.Hash,
+		Nonce:          h.Nonce,
+		Difficulty:     h.Difficulty,
+		Miner:          h.Miner,
+		Validator:      h.Validator,
+		Type:           h.Type,
+		Reward:         h.Reward,
+		Signature:      h.Signature,
+	}
+}
+
+// Height returns the index of the current head block
+func (bc *Blockchain) Height() int64 {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.Blocks[len(bc.Blocks)-1].Index
+}
+
+// CanonicalHeaders returns up to count headers of the canonical chain starting at from
+func (bc *Blockchain) CanonicalHeaders(from int64, count int) []BlockHeader {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	headers := []BlockHeader{}
+	for i := from; i >= 0 && i < int64(len(bc.Blocks)) && len(headers) < count; i++ {
+		headers = append(headers, bc.Blocks[i].Header())
+	}
+	return headers
+}
+
+// CanonicalBlocks returns up to count blocks of the canonical chain starting at from
+func (bc *Blockchain) CanonicalBlocks(from int64, count int) []Block {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	blocks := []Block{}
+	for i := from; i >= 0 && i < int64(len(bc.Blocks)) && len(blocks) < count; i++ {
+		blocks = append(blocks, bc.Blocks[i])
+	}
+	return blocks
+}
+
+// BlockByHash returns a canonical block by hash
+func (bc *Blockchain) BlockByHash(hash string) (Block, bool) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	if !bc.isCanonical(hash) {
+		return Block{}, false
+	}
+	return bc.Blocks[bc.tree[hash].Index], true
+}
+
+// BlockByNumber returns a canonical block by index
+func (bc *Blockchain) BlockByNumber(index int64) (Block, bool) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	if index < 0 || index >= int64(len(bc.Blocks)) {
+		return Block{}, false
+	}
+	return bc.Blocks[index], true
+}
+
+// TxLookup locates a transaction in the canonical chain or the pending pool
+type TxLookup struct {
+	Tx          Transaction
+	BlockHash   string
+	BlockNumber int64
+	Index       int
+	Pending     bool
+}
+
+// TransactionByHash finds a mined transaction through the transaction index,
+// or a pending one in the pool
+func (bc *Blockchain) TransactionByHash(hash string) (TxLookup, bool) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	if receipt, ok := bc.receipts[hash]; ok {
+		block := bc.Blocks[receipt.BlockNumber]
+		return TxLookup{
+			Tx:          block.Transactions[receipt.Index],
+			BlockHash:   block.Hash,
+			BlockNumber: block.Index,
+			Index:       receipt.Index,
+		}, true
+	}
+	if tx, ok := bc.pool.Get(hash); ok {
+		return TxLookup{Tx: tx, Pending: true}, true
+	}
+	return TxLookup{}, false
+}
+
+// Account returns the current balance and nonce of an address
+func (bc *Blockchain) Account(address string) AccountState {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.State.GetAccount(address)
+}
+
+// PendingNonce returns the next nonce for address counting its pending
+// transactions that follow on from the chain state without gaps
+func (bc *Blockchain) PendingNonce(address string) int64 {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.pool.PendingNonce(address, bc.State)
+}
+
+// PendingTransactions returns the pool's executable transactions in block
+// inclusion order and its future transactions
+func (bc *Blockchain) PendingTransactions() (executable, future []Transaction) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.pool.Executable(bc.State), bc.pool.Future(bc.State)
+}
+
+// headHash returns the hash of the current head block
+func (bc *Blockchain) headHash() string {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.Blocks[len(bc.Blocks)-1].Hash
+}
+
+// expectedReward returns the block reward the chain config pays for a block type
+func (bc *Blockchain) expectedReward(blockType string) string {
+	switch blockType {
+	case "POW":
+		return bc.Config.BlockReward
+	case "POS":
+		return bc.Config.StakeReward
+	}
+	return "0"
+}
+
+// buildBlock fills a new block's transactions from the mempool without
+// exceeding the block gas limit or the size limit. Callers must hold bc.mu.
+func (bc *Blockchain) buildBlock(template Block) Block {
+	sizeLimit := bc.Config.MaxBlockSize
+	if bc.BlockSizeLimit > 0 && bc.BlockSizeLimit < sizeLimit {
+		sizeLimit = bc.BlockSizeLimit
+	}
+
+	// Measure the block as it will be once mined: full-length hashes, the
+	// widest nonce and the validator signature
+	sized := template
+	sized.Transactions = []Transaction{}
+	sized.Hash = strings.Repeat("0", sha256.Size*2)
+	sized.TxRoot = sized.Hash
+	sized.ReceiptsRoot = sized.Hash
+	sized.StateRoot = sized.Hash
+	sized.ValidatorsRoot = sized.Hash
+	sized.Nonce = math.MaxInt64
+	if sized.Type == "POS" {
+		sized.Signature = strings.Repeat("0", 128)
+	}
+	headerSize := blockSize(&sized)
+
+	template.Transactions = bc.selectTransactions(&template, bc.Config.BlockGasLimit, sizeLimit-headerSize)
+	template.TxRoot = transactionsRoot(template.Transactions)
+
+	// The receipts, state and validators roots are read with the block
+	// applied, then rolled back
+	snapshot := bc.State.Snapshot()
+	if receipts, err := bc.State.ApplyBlock(&template, &bc.Blocks[len(bc.Blocks)-1]); err == nil {
+		template.ReceiptsRoot = receiptsRoot(receipts)
+		template.StateRoot = bc.State.Root()
+		template.ValidatorsRoot = validatorsRoot(proposerSet(bc.State.Validators()))
+	}
+	bc.State.RevertToSnapshot(snapshot)
+	return template
+}
+
+// selectTransactions returns the executable pending transactions that apply
+// cleanly in block on top of the current state, highest effective gas price first,
+// until gasLimit or sizeLimit bytes are used. Transactions that do not fit
+// stay pending. The state is left untouched. Callers must hold bc.mu.
+func (bc *Blockchain) selectTransactions(block *Block, gasLimit, sizeLimit int64) []Transaction {
+	snapshot := bc.State.Snapshot()
+	defer bc.State.RevertToSnapshot(snapshot)
+
+	selected := []Transaction{}
+	skip := make(map[string]bool)
+	var gasUsed, size int64
+	for _, tx := range bc.pool.Executable(bc.State) {
+		// Once a sender's transaction is left out, its later nonces cannot apply
+		sender := normalizeAddress(tx.From)
+		if skip[sender] {
+			continue
+		}
+		txSize := transactionSize(&tx)
+		if gasUsed+tx.Gas > gasLimit || size+txSize > sizeLimit {
+			skip[sender] = true
+			continue
+		}
+		if VerifyTransaction(&tx) != nil {
+			skip[sender] = true
+			continue
+		}
+		if _, err := bc.State.ApplyTransaction(&tx, block); err != nil {
+			skip[sender] = true
+			continue
+		}
+		selected = append(selected, tx)
+		gasUsed += tx.Gas
+		size += txSize
+	}
+
+	return selected
+}
+
+// applyBlock applies an already validated block on top of the current head,
+// records how to undo it and updates the chain bookkeeping. The resulting
+// receipts and state must match the block's roots. Lite nodes keep no state
+// and only do the bookkeeping. Callers must hold bc.mu.
+func (bc *Blockchain) applyBlock(block Block) error {
+	undo := &blockUndo{Meta: bc.meta()}
+	var receipts []Receipt
+	if !bc.Lite {
+		snapshot := bc.State.Snapshot()
+		var err error
+		if receipts, err = bc.State.ApplyBlock(&block, &bc.Blocks[len(bc.Blocks)-1]); err != nil {
+			return err
+		}
+		if receiptsRoot(receipts) != block.ReceiptsRoot {
+			bc.State.RevertToSnapshot(snapshot)
+			return errors.New("receipts root mismatch")
+		}
+		if bc.State.Root() != block.StateRoot {
+			bc.State.RevertToSnapshot(snapshot)
+			return errors.New("state root mismatch")
+		}
+		if validatorsRoot(proposerSet(bc.State.Validators())) != block.ValidatorsRoot {
+			bc.State.RevertToSnapshot(snapshot)
+			return errors.New("validators root mismatch")
+		}
+		undo.Accounts = bc.State.changesSince(snapshot)
+	}
+	bc.undo[block.Hash] = undo
+
+	bc.Blocks = append(bc.Blocks, block)
+	bc.addTreeNode(&block)
+	if !bc.Lite {
+		bc.indexBlock(&block, receipts)
+	}
+	delete(bc.sideBlocks, block.Hash)
+
+	reward := new(big.Int)
+	reward.SetString(block.Reward, 10)
+	bc.TotalSupply.Add(bc.TotalSupply, reward)
+	if !bc.Lite {
+		bc.TotalSupply.Sub(bc.TotalSupply, bc.State.burned)
+	}
+
+	switch block.Type {
+	case "POW":
+		bc.LastPOWBlock = block.Index
+		// Adjust difficulty every 10 blocks
+		if block.Index%10 == 0 {
+			bc.adjustDifficulty()
+		}
+	case "POS":
+		bc.LastPOSBlock = block.Index
+	}
+	bc.updateValidators()
+
+	// Undo data and side chains are only kept inside the reorg window
+	floor := bc.undoFloor()
+	for hash, undo := range bc.undo {
+		if undo.Meta.Height+1 < floor {
+			delete(bc.undo, hash)
+		}
+	}
+	bc.pruneSideBlocks()
+
+	bc.prunePending(block.Transactions)
+	return nil
+}
+
+// insertBlock appends an already validated block to the head, persists it
+// and notifies listeners. A block the store cannot take stops the node, as
+// the chain in memory would run ahead of what a restart loads. Callers must
+// hold bc.mu.
+func (bc *Blockchain) insertBlock(block Block) error {
+	if err := bc.applyBlock(block); err != nil {
+		return err
+	}
+	if err := bc.persistChain([]Block{block}, nil); err != nil {
+		log.Fatalf("❌ Failed to persist block #%d: %v", block.Index, err)
+	}
+
+	for _, l := range bc.listeners {
+		l.OnNewBlock(block)
+	}
+	return nil
+}
+
+// prunePending removes transactions included in a block, plus any whose nonce
+// has already been used or that have expired, from the pending pool. Callers
+// must hold bc.mu.
+func (bc *Blockchain) prunePending(included []Transaction) {
+	mined := make(map[string]bool, len(included))
+	for _, tx := range included {
+		mined[tx.Hash] = true
+	}
+
+	stale, expired := bc.pool.Prune(bc.State, time.Now())
+	for _, tx := range stale {
+		if !mined[tx.Hash] {
+			log.Printf("🗑️  Dropping stale transaction %s (nonce %d)", tx.Hash, tx.Nonce)
+		}
+	}
+	for _, tx := range expired {
+		log.Printf("🗑️  Dropping expired transaction %s", tx.Hash)
+	}
+}
+
+// AddBlock validates a block received from elsewhere and adds it to the block
+// tree. A block extending the head is appended; a block on another branch is
+// kept and triggers a reorg once its branch outweighs the canonical chain.
+func (bc *Blockchain) AddBlock(block Block) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if _, known := bc.tree[block.Hash]; known {
+		return ErrKnownBlock
+	}
+	if _, known := bc.tree[block.PreviousHash]; !known {
+		return ErrUnknownParent
+	}
+	// A lite node keeps headers only; the roots vouch for the rest
+	if bc.Lite {
+		block.Transactions = []Transaction{}
+	}
+
+	if block.PreviousHash != bc.Blocks[len(bc.Blocks)-1].Hash {
+		return bc.addSideBlock(block)
+	}
+	if err := bc.validateNext(block); err != nil {
+		return err
+	}
+	bc.checkEquivocation(&block)
+	return bc.insertBlock(block)
+}
+
+// validateNext checks a block against the current head and the chain rules in
+// force there. Callers must hold bc.mu.
+func (bc *Blockchain) validateNext(block Block) error {
+	head := bc.Blocks[len(bc.Blocks)-1]
+	if err := bc.checkBlock(&block, &head); err != nil {
+		return err
+	}
+	if block.Type == "POW" && block.Difficulty != bc.CurrentDiff {
+		return errors.New("invalid block difficulty")
+	}
+	return nil
+}
+
+// checkBlock validates a block on top of parent: the whole block, or only
+// its header on a lite node, plus its reward and proposer. Callers must hold bc.mu.
+func (bc *Blockchain) checkBlock(block, parent *Block) error {
+	if err := bc.checkBlockRules(block, parent); err != nil {
+		return err
+	}
+	return bc.checkProposer(block, parent)
+}
+
+// checkBlockRules is checkBlock without the proposer check, which needs the
+// validator set at parent. Callers must hold bc.mu.
+func (bc *Blockchain) checkBlockRules(block, parent *Block) error {
+	if bc.Lite {
+		if err := ValidateHeader(block, parent); err != nil {
+			return err
+		}
+	} else if err := ValidateBlock(block, parent, &bc.Config); err != nil {
+		return err
+	}
+	if block.Reward != bc.expectedReward(block.Type) {
+		return errors.New("invalid block reward")
+	}
+	if block.Type == "POS" && block.Timestamp > time.Now().Unix()+maxFutureBlockTime {
+		return errors.New("POS block timestamp is too far in the future")
+	}
+	return nil
+}
+
+// AddTransaction validates a signed transaction and adds it to the pending pool
+func (bc *Blockchain) AddTransaction(tx Transaction) error {
+	if err := ValidateTransaction(&tx); err != nil {
+		return err
+	}
+	if err := checkChainID(&tx, bc.Config.ChainID); err != nil {
+		return err
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	// A lite node has no state to check against and no pool; it only relays
+	if bc.Lite {
+		for _, l := range bc.listeners {
+			l.OnNewTransaction(tx)
+		}
+		return nil
+	}
+
+	// Future nonces are kept until the gap is filled
+	if err := bc.State.CheckTransaction(&tx); err != nil && !errors.Is(err, ErrNonceTooHigh) {
+		return err
+	}
+	// Staking, delegation and evidence rules depend on the state, so such a
+	// transaction that could be mined now is tried against it
+	if tx.Type != TxTransfer && tx.Nonce == bc.State.GetNonce(tx.From) {
+		snapshot := bc.State.Snapshot()
+		_, err := bc.State.ApplyTransaction(&tx, &Block{Timestamp: time.Now().Unix()})
+		bc.State.RevertToSnapshot(snapshot)
+		if err != nil {
+			return err
+		}
+	}
+
+	replaced, err := bc.pool.Add(tx, bc.State)
+	if err != nil {
+		return err
+	}
+	if replaced != nil {
+		log.Printf("🔁 Transaction %s replaced by %s (nonce %d)", shortID(replaced.Hash), shortID(tx.Hash), tx.Nonce)
+	}
+	if err := bc.persistPending(); err != nil {
+		log.Printf("⚠️  Failed to persist pending transactions: %v", err)
+	}
+
+	for _, l := range bc.listeners {
+		l.OnNewTransaction(tx)
+	}
+	return nil
+}
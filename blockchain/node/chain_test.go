@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+
+	"gydschain/client"
+)
+
+// buildNext builds the next POW block on bc without mining it
+func buildNext(bc *Blockchain) Block {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	head := bc.Blocks[len(bc.Blocks)-1]
+	return bc.buildBlock(Block{
+		Index:        head.Index + 1,
+		Timestamp:    head.Timestamp + 1,
+		PreviousHash: head.Hash,
+		Difficulty:   bc.CurrentDiff,
+		Miner:        "0x1111111111111111111111111111111111111111",
+		Type:         "POW",
+		Reward:       bc.Config.BlockReward,
+	})
+}
+
+func TestBlockBuilderStopsAtGasLimit(t *testing.T) {
+	bc, _ := pendingTransfers(t, 5)
+	bc.Config.BlockGasLimit = 3 * client.TransferGas
+
+	mineBlocks(t, bc, "0x1111111111111111111111111111111111111111", 1)
+	if got := len(bc.Blocks[1].Transactions); got != 3 {
+		t.Fatalf("block holds %d transfers, want 3", got)
+	}
+	for i, tx := range bc.Blocks[1].Transactions {
+		if tx.Nonce != int64(i) {
+			t.Fatalf("transaction %d has nonce %d", i, tx.Nonce)
+		}
+	}
+	if bc.pool.Len() != 2 {
+		t.Fatalf("%d transactions left pending, want 2", bc.pool.Len())
+	}
+
+	mineBlocks(t, bc, "0x1111111111111111111111111111111111111111", 1)
+	if got := len(bc.Blocks[2].Transactions); got != 2 || bc.pool.Len() != 0 {
+		t.Fatalf("next block holds %d transfers, %d left pending", got, bc.pool.Len())
+	}
+}
+
+func TestBlockBuilderStopsAtSizeLimit(t *testing.T) {
+	bc, _ := pendingTransfers(t, 5)
+	full := buildNext(bc)
+	if len(full.Transactions) != 5 {
+		t.Fatalf("unlimited block holds %d transfers, want 5", len(full.Transactions))
+	}
+
+	// One byte short of the full block leaves room for all but one transfer,
+	// whichever limit is the lower
+	bc.Config.MaxBlockSize = blockSize(&full) - 1
+	if got := len(buildNext(bc).Transactions); got != 4 {
+		t.Fatalf("block under the chain's size limit holds %d transfers, want 4", got)
+	}
+	bc.Config.MaxBlockSize = blockSize(&full) * 2
+	bc.BlockSizeLimit = blockSize(&full) - 1
+	if got := len(buildNext(bc).Transactions); got != 4 {
+		t.Fatalf("block under the node's size limit holds %d transfers, want 4", got)
+	}
+	if bc.pool.Len() != 5 {
+		t.Fatalf("building a block changed the pool to %d transactions", bc.pool.Len())
+	}
+}
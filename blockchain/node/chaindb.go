@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// Store key layout
+const (
+	keyChainMeta      = "meta/chain"
+	keyPendingTxs     = "meta/pending"
+	prefixBlockByHash = "block/"
+	prefixCanonical   = "canon/"
+	prefixAccount     = "account/"
+	prefixUndo        = "undo/"
+	prefixReceipts    = "receipts/"
+	prefixValidators  = "validators/"
+)
+
+// chainMeta is the mutable chain state saved alongside blocks
+type chainMeta struct {
+	Height       int64  `json:"height"`
+	HeadHash     string `json:"headHash"`
+	TotalSupply  string `json:"totalSupply"`
+	CurrentDiff  int64  `json:"currentDifficulty"`
+	LastPOWBlock int64  `json:"lastPOWBlock"`
+	LastPOSBlock int64  `json:"lastPOSBlock"`
+	Lite         bool   `json:"lite,omitempty"`
+	PrunedBelow  int64  `json:"prunedBelow,omitempty"`
+}
+
+func blockKey(hash string) string {
+	return prefixBlockByHash + hash
+}
+
+func canonicalKey(index int64) string {
+	// Zero-padded so keys iterate in height order
+	return fmt.Sprintf("%s%020d", prefixCanonical, index)
+}
+
+func accountKey(address string) string {
+	return prefixAccount + normalizeAddress(address)
+}
+
+func undoKey(hash string) string {
+	return prefixUndo + hash
+}
+
+func receiptsKey(hash string) string {
+	return prefixReceipts + hash
+}
+
+func validatorSetKey(root string) string {
+	return prefixValidators + root
+}
+
+// openBlockchain loads the chain from store, creating and saving genesis on
+// first start. A store created from a different genesis is refused.
+func openBlockchain(store Store, genesis *Genesis, lite bool) (*Blockchain, error) {
+	raw, err := store.Get(keyChainMeta)
+	if errors.Is(err, ErrNotFound) {
+		bc := initBlockchain(genesis)
+		bc.Lite = lite
+		bc.store = store
+		if err := bc.persistChain(bc.Blocks, nil); err != nil {
+			return nil, fmt.Errorf("failed to persist genesis: %w", err)
+		}
+		return bc, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var meta chainMeta
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		return nil, fmt.Errorf("corrupt chain metadata: %w", err)
+	}
+	// Lite data dirs hold headers only, and a full one would lose its bodies
+	if meta.Lite != lite {
+		return nil, fmt.Errorf("data dir was created by a %s node; use a separate data dir", nodeMode(meta.Lite))
+	}
+
+	bc := initBlockchain(genesis)
+	bc.Lite = lite
+	bc.undoPruned = -1
+	stored, err := store.Get(canonicalKey(0))
+	if err != nil {
+		return nil, fmt.Errorf("missing genesis block: %w", err)
+	}
+	if string(stored) != bc.Blocks[0].Hash {
+		return nil, fmt.Errorf("data dir was created from a different genesis (stored %s, genesis file %s)", stored, bc.Blocks[0].Hash)
+	}
+	bc.Blocks = make([]Block, 0, meta.Height+1)
+	bc.tree = make(map[string]*blockNode)
+	bc.CurrentDiff = meta.CurrentDiff
+	bc.LastPOWBlock = meta.LastPOWBlock
+	bc.LastPOSBlock = meta.LastPOSBlock
+	bc.prunedBelow = meta.PrunedBelow
+	bc.store = store
+	if _, ok := bc.TotalSupply.SetString(meta.TotalSupply, 10); !ok {
+		return nil, errors.New("corrupt chain metadata: invalid total supply")
+	}
+
+	// Rebuild the canonical chain
+	for i := int64(0); i <= meta.Height; i++ {
+		hash, err := store.Get(canonicalKey(i))
+		if err != nil {
+			return nil, fmt.Errorf("missing canonical block #%d: %w", i, err)
+		}
+		block, err := bc.loadBlock(string(hash))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load block #%d: %w", i, err)
+		}
+		bc.Blocks = append(bc.Blocks, *block)
+		bc.addTreeNode(block)
+		// Pruned blocks have no receipts and are not indexed
+		if !lite && i >= meta.PrunedBelow {
+			var receipts []Receipt
+			if err := getJSON(store, receiptsKey(block.Hash), &receipts); err != nil {
+				return nil, fmt.Errorf("failed to load receipts of block #%d: %w", i, err)
+			}
+			bc.indexBlock(block, receipts)
+		}
+	}
+	if bc.Blocks[len(bc.Blocks)-1].Hash != meta.HeadHash {
+		return nil, errors.New("stored head does not match canonical chain")
+	}
+
+	err = store.Iterate(prefixAccount, func(key string, value []byte) error {
+		var acct AccountState
+		if err := json.Unmarshal(value, &acct); err != nil {
+			return fmt.Errorf("corrupt account %s: %w", key, err)
+		}
+		bc.State.accounts[key[len(prefixAccount):]] = &acct
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	bc.updateValidators()
+
+	if raw, err := store.Get(keyPendingTxs); err == nil {
+		var pending []storedTx
+		if err := json.Unmarshal(raw, &pending); err != nil {
+			log.Printf("⚠️  Discarding unreadable pending transactions: %v", err)
+		}
+		bc.pool.restore(pending, bc.State)
+	}
+
+	return bc, nil
+}
+
+func (bc *Blockchain) loadBlock(hash string) (*Block, error) {
+	raw, err := bc.store.Get(blockKey(hash))
+	if err != nil {
+		return nil, err
+	}
+	var block Block
+	if err := json.Unmarshal(raw, &block); err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+
+func (bc *Blockchain) meta() chainMeta {
+	head := bc.Blocks[len(bc.Blocks)-1]
+	return chainMeta{
+		Height:       head.Index,
+		HeadHash:     head.Hash,
+		TotalSupply:  bc.TotalSupply.String(),
+		CurrentDiff:  bc.CurrentDiff,
+		LastPOWBlock: bc.LastPOWBlock,
+		LastPOSBlock: bc.LastPOSBlock,
+		Lite:         bc.Lite,
+		PrunedBelow:  bc.prunedBelow,
+	}
+}
+
+func nodeMode(lite bool) string {
+	if lite {
+		return "lite"
+	}
+	return "full"
+}
+
+// persistChain saves blocks newly added to the canonical chain together with
+// their undo data, chain metadata, modified accounts and pending transactions.
+// Blocks removed by a reorg stay stored by hash but lose their undo data.
+// Callers must hold bc.mu.
+func (bc *Blockchain) persistChain(added, removed []Block) error {
+	if bc.store == nil {
+		bc.State.Commit()
+		return nil
+	}
+
+	batch := &Batch{}
+	for _, block := range removed {
+		batch.Delete(undoKey(block.Hash))
+	}
+	if len(added) > 0 {
+		// Removed blocks above the new head no longer have a canonical entry
+		for i := added[len(added)-1].Index + 1; i <= int64(len(removed))+added[0].Index-1; i++ {
+			batch.Delete(canonicalKey(i))
+		}
+	}
+	for _, block := range added {
+		if err := putJSON(batch, blockKey(block.Hash), block); err != nil {
+			return err
+		}
+		batch.Put(canonicalKey(block.Index), []byte(block.Hash))
+		if !bc.Lite {
+			if err := putJSON(batch, receiptsKey(block.Hash), bc.blockReceipts(&block)); err != nil {
+				return err
+			}
+		}
+		if undo, ok := bc.undo[block.Hash]; ok {
+			if err := putJSON(batch, undoKey(block.Hash), undo); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Validator sets are kept unless pruned, lite peers may ask for any of them
+	for root, set := range bc.validatorSets {
+		if err := putJSON(batch, validatorSetKey(root), set); err != nil {
+			return err
+		}
+	}
+
+	// Undo data is only needed inside the reorg window
+	floor := bc.pruneStoredUndo(batch)
+
+	if err := putJSON(batch, keyChainMeta, bc.meta()); err != nil {
+		return err
+	}
+	if err := putJSON(batch, keyPendingTxs, bc.pool.stored(bc.State)); err != nil {
+		return err
+	}
+	for _, address := range bc.State.Commit() {
+		if !bc.State.Exists(address) {
+			batch.Delete(accountKey(address))
+			continue
+		}
+		if err := putJSON(batch, accountKey(address), bc.State.GetAccount(address)); err != nil {
+			return err
+		}
+	}
+
+	if err := bc.store.Write(batch); err != nil {
+		return err
+	}
+	if floor > bc.undoPruned {
+		bc.undoPruned = floor
+	}
+	bc.validatorSets = make(map[string][]Validator)
+	return nil
+}
+
+// undoFloor is the lowest height whose undo data is kept, the oldest block a
+// reorg may take off the chain. Callers must hold bc.mu.
+func (bc *Blockchain) undoFloor() int64 {
+	return int64(len(bc.Blocks)) - bc.MaxReorgDepth - 1
+}
+
+// pruneStoredUndo adds to batch the deletion of stored undo data below the
+// reorg window and returns the window's floor. The first call after loading
+// sweeps every stored entry, so data left by a larger window goes too; later
+// calls delete the heights the window has moved past since. Callers must
+// hold bc.mu.
+func (bc *Blockchain) pruneStoredUndo(batch *Batch) int64 {
+	floor := bc.undoFloor()
+	if floor < 0 {
+		floor = 0
+	}
+	if bc.undoPruned < 0 {
+		bc.store.Iterate(prefixUndo, func(key string, _ []byte) error {
+			if node, ok := bc.tree[key[len(prefixUndo):]]; !ok || node.Index < floor {
+				batch.Delete(key)
+			}
+			return nil
+		})
+		return floor
+	}
+	for height := bc.undoPruned; height < floor; height++ {
+		batch.Delete(undoKey(bc.Blocks[height].Hash))
+	}
+	return floor
+}
+
+// loadUndo returns the undo data for a canonical block. Callers must hold bc.mu.
+func (bc *Blockchain) loadUndo(hash string) (*blockUndo, error) {
+	if undo, ok := bc.undo[hash]; ok {
+		return undo, nil
+	}
+	if bc.store == nil {
+		return nil, ErrNotFound
+	}
+	raw, err := bc.store.Get(undoKey(hash))
+	if err != nil {
+		return nil, err
+	}
+	var undo blockUndo
+	if err := json.Unmarshal(raw, &undo); err != nil {
+		return nil, err
+	}
+	return &undo, nil
+}
+
+// persistPending saves the pending transaction pool. Callers must hold bc.mu.
+func (bc *Blockchain) persistPending() error {
+	if bc.store == nil {
+		return nil
+	}
+	raw, err := json.Marshal(bc.pool.stored(bc.State))
+	if err != nil {
+		return err
+	}
+	return bc.store.Put(keyPendingTxs, raw)
+}
+
+func putJSON(batch *Batch, key string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	batch.Put(key, raw)
+	return nil
+}
+
+func getJSON(store Store, key string, v interface{}) error {
+	raw, err := store.Get(key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
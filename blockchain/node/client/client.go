@@ -0,0 +1,354 @@
+// Package client builds, signs and submits GYDSchain transactions without
+// ever sending private keys to a node.
+package client
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Default gas settings for a plain transfer
+const (
+	TransferGas     = 21000
+	DefaultGasPrice = 1000000000 // 1 Gwei
+)
+
+// Transaction types. Staking transactions are sent to the sender's own
+// address, delegation transactions to the validator's.
+const (
+	TxTransfer   = ""
+	TxStake      = "stake"
+	TxUnstake    = "unstake"
+	TxWithdraw   = "withdraw"
+	TxDelegate   = "delegate"
+	TxUndelegate = "undelegate"
+	TxClaim      = "claim"
+	TxEvidence   = "evidence"
+	TxUnjail     = "unjail"
+	TxExit       = "exit"
+	TxRotateKey  = "rotateKey"
+)
+
+// Transaction mirrors the node's transaction wire format
+type Transaction struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Value     string `json:"value"`
+	Gas       int64  `json:"gas"`
+	GasPrice  string `json:"gasPrice"`
+	Nonce     int64  `json:"nonce"`
+	Hash      string `json:"hash"`
+	Timestamp int64  `json:"timestamp"`
+	Signature string `json:"signature,omitempty"`
+	PublicKey string `json:"publicKey,omitempty"`
+	Type      string `json:"type,omitempty"` // "" for a transfer, or a staking operation
+	Data      string `json:"data,omitempty"` // evidence of an evidence transaction
+	ChainID   int64  `json:"chainId"`        // chain the signature is valid on
+}
+
+// NewTransfer builds an unsigned transfer of value wei with default gas settings
+func NewTransfer(from, to string, value *big.Int, nonce int64) *Transaction {
+	return &Transaction{
+		From:     from,
+		To:       to,
+		Value:    value.String(),
+		Gas:      TransferGas,
+		GasPrice: big.NewInt(DefaultGasPrice).String(),
+		Nonce:    nonce,
+	}
+}
+
+// NewStake builds an unsigned transaction locking value wei of from's balance
+// as validator stake
+func NewStake(from string, value *big.Int, nonce int64) *Transaction {
+	tx := NewTransfer(from, from, value, nonce)
+	tx.Type = TxStake
+	return tx
+}
+
+// NewUnstake builds an unsigned transaction that starts unbonding value wei of
+// from's stake
+func NewUnstake(from string, value *big.Int, nonce int64) *Transaction {
+	tx := NewTransfer(from, from, value, nonce)
+	tx.Type = TxUnstake
+	return tx
+}
+
+// NewWithdraw builds an unsigned transaction returning unbonded stake to
+// from's balance
+func NewWithdraw(from string, nonce int64) *Transaction {
+	tx := NewTransfer(from, from, big.NewInt(0), nonce)
+	tx.Type = TxWithdraw
+	return tx
+}
+
+// NewUnjail builds an unsigned transaction returning from, a jailed
+// validator, to the proposer rotation once its jail period is over
+func NewUnjail(from string, nonce int64) *Transaction {
+	tx := NewTransfer(from, from, big.NewInt(0), nonce)
+	tx.Type = TxUnjail
+	return tx
+}
+
+// NewExit builds an unsigned transaction taking validator from out of the
+// validator set. Its stake is released after the unlock duration.
+func NewExit(from string, nonce int64) *Transaction {
+	tx := NewTransfer(from, from, big.NewInt(0), nonce)
+	tx.Type = TxExit
+	return tx
+}
+
+// NewRotateKey builds an unsigned transaction replacing the key validator
+// from signs blocks with by publicKey, as encoded by EncodePublicKey
+func NewRotateKey(from, publicKey string, nonce int64) *Transaction {
+	tx := NewTransfer(from, from, big.NewInt(0), nonce)
+	tx.Type = TxRotateKey
+	tx.Data = publicKey
+	return tx
+}
+
+// NewDelegate builds an unsigned transaction bonding value wei of from's
+// balance to validator
+func NewDelegate(from, validator string, value *big.Int, nonce int64) *Transaction {
+	tx := NewTransfer(from, validator, value, nonce)
+	tx.Type = TxDelegate
+	return tx
+}
+
+// NewUndelegate builds an unsigned transaction that starts unbonding value wei
+// of from's delegation to validator
+func NewUndelegate(from, validator string, value *big.Int, nonce int64) *Transaction {
+	tx := NewTransfer(from, validator, value, nonce)
+	tx.Type = TxUndelegate
+	return tx
+}
+
+// NewClaim builds an unsigned transaction paying the rewards of from's
+// delegation to validator to its balance
+func NewClaim(from, validator string, nonce int64) *Transaction {
+	tx := NewTransfer(from, validator, big.NewInt(0), nonce)
+	tx.Type = TxClaim
+	return tx
+}
+
+// NewEvidence builds an unsigned transaction reporting a fault of validator.
+// evidence is the JSON of an entry listed by the node's /evidence endpoint.
+func NewEvidence(from, validator, evidence string, nonce int64) *Transaction {
+	tx := NewTransfer(from, validator, big.NewInt(0), nonce)
+	tx.Type = TxEvidence
+	tx.Data = evidence
+	return tx
+}
+
+// ComputeHash returns the hash a transaction is identified and signed by: the
+// SHA-256 of its chain ID, type, addresses, amounts, gas, nonce and data in
+// the node's fixed binary encoding
+func (tx *Transaction) ComputeHash() string {
+	buf := make([]byte, 0, 256)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(tx.ChainID))
+	buf = appendString(buf, tx.Type)
+	buf = appendString(buf, tx.From)
+	buf = appendString(buf, tx.To)
+	buf = appendString(buf, tx.Value)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(tx.Gas))
+	buf = appendString(buf, tx.GasPrice)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(tx.Nonce))
+	buf = appendString(buf, tx.Data)
+	hash := sha256.Sum256(buf)
+	return hex.EncodeToString(hash[:])
+}
+
+// Sign sets the transaction hash, signature and public key. The key must
+// belong to tx.From, and tx.ChainID must be set to the chain's ID.
+func (tx *Transaction) Sign(key *ecdsa.PrivateKey) error {
+	if !strings.EqualFold(Address(&key.PublicKey), tx.From) {
+		return errors.New("private key does not match from address")
+	}
+	if tx.ChainID <= 0 {
+		return errors.New("transaction has no chain ID")
+	}
+
+	tx.Hash = tx.ComputeHash()
+	digest, _ := hex.DecodeString(tx.Hash)
+
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+	if err != nil {
+		return err
+	}
+
+	// The node only accepts low-S signatures
+	n := key.Curve.Params().N
+	if s.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+		s.Sub(n, s)
+	}
+
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	tx.Signature = hex.EncodeToString(sig)
+
+	tx.PublicKey = EncodePublicKey(&key.PublicKey)
+
+	return nil
+}
+
+// EncodeRaw serializes a signed transaction for submission
+func (tx *Transaction) EncodeRaw() (string, error) {
+	if tx.Signature == "" {
+		return "", errors.New("transaction is not signed")
+	}
+	payload, err := json.Marshal(tx)
+	if err != nil {
+		return "", err
+	}
+	return "0x" + hex.EncodeToString(payload), nil
+}
+
+// DecodeRaw parses a transaction produced by EncodeRaw
+func DecodeRaw(raw string) (*Transaction, error) {
+	payload, err := hex.DecodeString(strings.TrimPrefix(raw, "0x"))
+	if err != nil {
+		return nil, errors.New("raw transaction is not valid hex")
+	}
+	var tx Transaction
+	if err := json.Unmarshal(payload, &tx); err != nil {
+		return nil, errors.New("raw transaction is not a valid transaction")
+	}
+	return &tx, nil
+}
+
+// GenerateKey creates a new random private key
+func GenerateKey() (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// KeyFromHex parses a hex private key as returned by /wallet/create
+func KeyFromHex(privateKeyHex string) (*ecdsa.PrivateKey, error) {
+	d, err := hex.DecodeString(strings.TrimPrefix(privateKeyHex, "0x"))
+	if err != nil {
+		return nil, err
+	}
+
+	curve := elliptic.P256()
+	key := new(ecdsa.PrivateKey)
+	key.PublicKey.Curve = curve
+	key.D = new(big.Int).SetBytes(d)
+	if key.D.Sign() == 0 || key.D.Cmp(curve.Params().N) >= 0 {
+		return nil, errors.New("private key out of range")
+	}
+	key.PublicKey.X, key.PublicKey.Y = curve.ScalarBaseMult(key.D.Bytes())
+	return key, nil
+}
+
+// Address derives the chain address of a public key
+func Address(pub *ecdsa.PublicKey) string {
+	hash := sha256.Sum256(append(pub.X.Bytes(), pub.Y.Bytes()...))
+	return "0x" + hex.EncodeToString(hash[:])[:40]
+}
+
+// EncodePublicKey encodes a public key as the node expects it: 64 bytes of
+// hex, X || Y
+func EncodePublicKey(pub *ecdsa.PublicKey) string {
+	buf := make([]byte, 64)
+	pub.X.FillBytes(buf[:32])
+	pub.Y.FillBytes(buf[32:])
+	return hex.EncodeToString(buf)
+}
+
+// Client talks to a node's HTTP API
+type Client struct {
+	URL  string
+	HTTP *http.Client
+}
+
+// New creates a client for the node at url, e.g. "http://localhost:8545"
+func New(url string) *Client {
+	return &Client{
+		URL:  strings.TrimRight(url, "/"),
+		HTTP: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// SendRawTransaction submits a signed, encoded transaction and returns its hash
+func (c *Client) SendRawTransaction(raw string) (string, error) {
+	body, err := json.Marshal(map[string]string{"raw": raw})
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.HTTP.Post(c.URL+"/transaction/raw", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("node rejected transaction: %s", strings.TrimSpace(string(respBody)))
+	}
+
+	var result struct {
+		Hash string `json:"hash"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return "", err
+	}
+	return result.Hash, nil
+}
+
+// ChainID returns the ID of the node's chain, which transactions must be
+// signed for
+func (c *Client) ChainID() (int64, error) {
+	resp, err := c.HTTP.Get(c.URL + "/")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var info struct {
+		ChainID int64 `json:"chainId"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return 0, err
+	}
+	if info.ChainID <= 0 {
+		return 0, errors.New("node did not report a chain ID")
+	}
+	return info.ChainID, nil
+}
+
+// SendTransaction signs, encodes and submits a transaction, for the node's
+// chain unless tx.ChainID is already set
+func (c *Client) SendTransaction(tx *Transaction, key *ecdsa.PrivateKey) (string, error) {
+	if tx.ChainID == 0 {
+		chainID, err := c.ChainID()
+		if err != nil {
+			return "", err
+		}
+		tx.ChainID = chainID
+	}
+	if err := tx.Sign(key); err != nil {
+		return "", err
+	}
+	raw, err := tx.EncodeRaw()
+	if err != nil {
+		return "", err
+	}
+	return c.SendRawTransaction(raw)
+}
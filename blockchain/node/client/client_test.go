@@ -0,0 +1,101 @@
+package client
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSignedTransactionRoundTrip(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := GenerateKey()
+	tx := NewTransfer(Address(&key.PublicKey), "0x2222222222222222222222222222222222222222", big.NewInt(1000), 3)
+
+	if _, err := tx.EncodeRaw(); err == nil {
+		t.Fatal("unsigned transaction encoded")
+	}
+	if err := tx.Sign(other); err == nil {
+		t.Fatal("signed with a key that does not own the sender address")
+	}
+	if err := tx.Sign(key); err == nil {
+		t.Fatal("signed without a chain ID")
+	}
+	tx.ChainID = 1337
+	if err := tx.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	if tx.Hash != tx.ComputeHash() || tx.PublicKey == "" {
+		t.Fatal("signing did not set the hash and public key")
+	}
+
+	raw, err := tx.EncodeRaw()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeRaw(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *decoded != *tx {
+		t.Fatalf("decoded %+v, want %+v", decoded, tx)
+	}
+	if _, err := DecodeRaw("0xzz"); err == nil {
+		t.Fatal("decoded invalid hex")
+	}
+}
+
+func TestHashCommitsToEveryField(t *testing.T) {
+	base := NewEvidence("0x1111111111111111111111111111111111111111", "0x2222222222222222222222222222222222222222", "{}", 3)
+	base.ChainID = 1337
+
+	for name, change := range map[string]func(*Transaction){
+		"chainId":  func(tx *Transaction) { tx.ChainID++ },
+		"type":     func(tx *Transaction) { tx.Type = TxClaim },
+		"from":     func(tx *Transaction) { tx.From = "0x3333333333333333333333333333333333333333" },
+		"to":       func(tx *Transaction) { tx.To = "0x3333333333333333333333333333333333333333" },
+		"value":    func(tx *Transaction) { tx.Value = "1" },
+		"gas":      func(tx *Transaction) { tx.Gas++ },
+		"gasPrice": func(tx *Transaction) { tx.GasPrice = "1" },
+		"nonce":    func(tx *Transaction) { tx.Nonce++ },
+		"data":     func(tx *Transaction) { tx.Data = "[]" },
+		// Moving a digit across a field boundary must not give the same hash
+		"boundary": func(tx *Transaction) { tx.Value, tx.GasPrice = tx.Value+"1", tx.GasPrice[1:] },
+	} {
+		changed := *base
+		change(&changed)
+		if changed.ComputeHash() == base.ComputeHash() {
+			t.Errorf("%s: hash unchanged", name)
+		}
+	}
+}
+
+func TestSendRawTransaction(t *testing.T) {
+	var submitted string
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Raw string `json:"raw"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if r.URL.Path != "/transaction/raw" || req.Raw == "bad" {
+			http.Error(w, "invalid signature", http.StatusBadRequest)
+			return
+		}
+		submitted = req.Raw
+		json.NewEncoder(w).Encode(map[string]string{"status": "pending", "hash": "abc"})
+	}))
+	defer node.Close()
+
+	c := New(node.URL + "/")
+	if hash, err := c.SendRawTransaction("0x01"); err != nil || hash != "abc" || submitted != "0x01" {
+		t.Fatalf("hash %q, err %v, node received %q", hash, err, submitted)
+	}
+	if _, err := c.SendRawTransaction("bad"); err == nil || !strings.Contains(err.Error(), "invalid signature") {
+		t.Fatalf("rejected transaction: %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// BlockHeader mirrors the node's block header
+type BlockHeader struct {
+	Index          int64  `json:"index"`
+	Timestamp      int64  `json:"timestamp"`
+	PreviousHash   string `json:"previousHash"`
+	TxRoot         string `json:"transactionsRoot"`
+	ReceiptsRoot   string `json:"receiptsRoot"`
+	StateRoot      string `json:"stateRoot"`
+	ValidatorsRoot string `json:"validatorsRoot"`
+	Hash           string `json:"hash"`
+	Nonce          int64  `json:"nonce"`
+	Difficulty     int64  `json:"difficulty"`
+	Miner          string `json:"miner"`
+	Validator      string `json:"validator"`
+	Type           string `json:"type"`
+	Reward         string `json:"reward"`
+	Signature      string `json:"signature,omitempty"`
+}
+
+// MerkleStep is one sibling hash on the path from a leaf up to the root
+type MerkleStep struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left"` // the sibling is the left child
+}
+
+// TxProof is a transaction inclusion proof as served by /proof/tx/{hash}
+type TxProof struct {
+	Transaction Transaction  `json:"transaction"`
+	Index       int          `json:"index"`
+	Header      BlockHeader  `json:"header"`
+	Branch      []MerkleStep `json:"branch"`
+}
+
+// ComputeHash returns the block hash the header commits to
+func (h *BlockHeader) ComputeHash() string {
+	buf := binary.BigEndian.AppendUint64(nil, uint64(h.Index))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(h.Timestamp))
+	buf = appendHash(buf, h.PreviousHash)
+	buf = appendHash(buf, h.TxRoot)
+	buf = appendHash(buf, h.ReceiptsRoot)
+	buf = appendHash(buf, h.StateRoot)
+	buf = appendHash(buf, h.ValidatorsRoot)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(h.Nonce))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(h.Difficulty))
+	buf = appendString(buf, h.Miner)
+	buf = appendString(buf, h.Validator)
+	buf = appendString(buf, h.Type)
+	buf = appendString(buf, h.Reward)
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyTxProof checks that proof shows its transaction included in the block
+// of header, a header the caller already trusts. Nothing from the node other
+// than the proof itself is needed.
+func VerifyTxProof(proof *TxProof, header *BlockHeader) error {
+	if header.ComputeHash() != header.Hash {
+		return errors.New("header hash does not match its contents")
+	}
+	if proof.Header.Hash != header.Hash {
+		return errors.New("proof is for a different block")
+	}
+
+	tx := &proof.Transaction
+	if tx.ComputeHash() != tx.Hash {
+		return errors.New("transaction hash does not match its contents")
+	}
+
+	leaf := appendString(nil, tx.From)
+	leaf = appendString(leaf, tx.To)
+	leaf = appendString(leaf, tx.Value)
+	leaf = binary.BigEndian.AppendUint64(leaf, uint64(tx.Gas))
+	leaf = appendString(leaf, tx.GasPrice)
+	leaf = binary.BigEndian.AppendUint64(leaf, uint64(tx.Nonce))
+	leaf = binary.BigEndian.AppendUint64(leaf, uint64(tx.Timestamp))
+	leaf = appendString(leaf, tx.Signature)
+	leaf = appendString(leaf, tx.PublicKey)
+	leaf = appendString(leaf, tx.Type)
+	if tx.Data != "" {
+		leaf = appendString(leaf, tx.Data)
+	}
+
+	sum := sha256.Sum256(append([]byte{0x00}, leaf...))
+	node := sum[:]
+	for _, step := range proof.Branch {
+		sibling, err := hex.DecodeString(step.Hash)
+		if err != nil || len(sibling) != sha256.Size {
+			return errors.New("malformed branch hash")
+		}
+		data := []byte{0x01}
+		if step.Left {
+			data = append(append(data, sibling...), node...)
+		} else {
+			data = append(append(data, node...), sibling...)
+		}
+		sum = sha256.Sum256(data)
+		node = sum[:]
+	}
+	if hex.EncodeToString(node) != header.TxRoot {
+		return errors.New("branch does not lead to the transactions root")
+	}
+	return nil
+}
+
+// GetTxProof fetches the inclusion proof of a mined transaction. Check it
+// with VerifyTxProof against a trusted header before relying on it.
+func (c *Client) GetTxProof(hash string) (*TxProof, error) {
+	resp, err := c.HTTP.Get(c.URL + "/proof/tx/" + hash)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("node returned no proof: %s", strings.TrimSpace(string(body)))
+	}
+
+	var proof TxProof
+	if err := json.Unmarshal(body, &proof); err != nil {
+		return nil, err
+	}
+	return &proof, nil
+}
+
+func appendHash(buf []byte, hash string) []byte {
+	var raw [sha256.Size]byte
+	if decoded, err := hex.DecodeString(hash); err == nil && len(decoded) == sha256.Size {
+		copy(raw[:], decoded)
+	}
+	return append(buf, raw[:]...)
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
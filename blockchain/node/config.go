@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// NodeConfig is the node-config.yml file. Every setting has a default, so the
+// file is optional; environment variables override it.
+type NodeConfig struct {
+	Node struct {
+		Type        string `json:"type"`    // "full" or "lite"
+		Version     string `json:"version"` // reported to peers and in the API
+		DataDir     string `json:"data_dir"`
+		GenesisFile string `json:"genesis_file"`
+	} `json:"node"`
+	Location Location `json:"location"` // reported to peers and in the API unless left empty
+	Network  struct {
+		ListenAddress  string   `json:"listen_address"`
+		Port           int      `json:"port"` // P2P port
+		MaxPeers       int      `json:"max_peers"`
+		BootstrapNodes []string `json:"bootstrap_nodes"`
+	} `json:"network"`
+	Wallet struct {
+		Address   string `json:"address"`    // rewards go here unless mining.reward_address is set
+		PublicKey string `json:"public_key"` // the address's key; alone it stands in for the address
+	} `json:"wallet"`
+	Sync struct {
+		SPVMode          bool  `json:"spv_mode"`
+		FastSync         bool  `json:"fast_sync"` // download the next batch while importing the current one
+		ProgressInterval int64 `json:"progress_interval"`
+		MaxBlockBatch    int   `json:"max_block_batch"`
+		MaxBlockHeaders  int   `json:"max_block_headers"`
+		CacheSize        int   `json:"cache_size"` // MB of stored chain data kept in memory
+	} `json:"sync"`
+	RPC struct {
+		Enabled              bool     `json:"enabled"`
+		Host                 string   `json:"host"`
+		Port                 int      `json:"port"`
+		CORSOrigins          []string `json:"cors_origins"`
+		MaxRequestSize       int64    `json:"max_request_size"` // bytes
+		MaxBatchSize         int      `json:"max_batch_size"`
+		BatchConcurrency     int      `json:"batch_concurrency"`
+		DisableServerSigning bool     `json:"disable_server_signing"`
+	} `json:"rpc"`
+	Mining struct {
+		Enabled       bool   `json:"enabled"`
+		Threads       int    `json:"threads"`
+		RewardAddress string `json:"reward_address"`
+	} `json:"mining"`
+	Consensus struct {
+		Participate      bool   `json:"participate"`       // mint PoS blocks
+		ValidatorAddress string `json:"validator_address"` // validator minted for, "" = the signing key's address
+		BlockTime        int    `json:"block_time"`        // seconds between production attempts, 0 = genesis blockTime
+		BlockSizeLimit   int64  `json:"block_size_limit"`
+		MaxReorgDepth    int64  `json:"max_reorg_depth"`
+	} `json:"consensus"`
+	Mempool struct {
+		MaxSize      int `json:"max_size"`
+		MaxPerSender int `json:"max_per_sender"`
+		Lifetime     int `json:"lifetime"`   // seconds
+		PriceBump    int `json:"price_bump"` // percent
+	} `json:"mempool"`
+	Logging struct {
+		Level      string `json:"level"`
+		File       string `json:"file"`
+		MaxSize    int    `json:"max_size"` // MB
+		MaxBackups int    `json:"max_backups"`
+	} `json:"logging"`
+	Security struct {
+		PrivateKeyFile string `json:"private_key_file"`
+		TLSEnabled     bool   `json:"tls_enabled"`
+		TLSCert        string `json:"tls_cert"`
+		TLSKey         string `json:"tls_key"`
+	} `json:"security"`
+	Metrics struct {
+		Enabled bool   `json:"enabled"`
+		Port    int    `json:"port"`
+		Path    string `json:"path"`
+	} `json:"metrics"`
+	Pruning struct {
+		Enabled          bool  `json:"enabled"`
+		KeepRecentBlocks int64 `json:"keep_recent_blocks"` // blocks below the newest ones lose their bodies or validator sets
+		PruneInterval    int   `json:"prune_interval"`     // seconds
+	} `json:"pruning"`
+}
+
+// defaultNodeVersion is the version a node reports unless node.version is set
+const defaultNodeVersion = "1.0.0"
+
+// DefaultNodeConfig returns the settings used when no config file is given
+func DefaultNodeConfig() *NodeConfig {
+	cfg := &NodeConfig{}
+	cfg.Node.Type = "full"
+	cfg.Node.Version = defaultNodeVersion
+	cfg.Node.DataDir = "./data"
+	cfg.Network.ListenAddress = "0.0.0.0"
+	cfg.Network.Port = 30303
+	cfg.Network.MaxPeers = 50
+	cfg.Sync.ProgressInterval = 1000
+	cfg.Sync.MaxBlockBatch = 500
+	cfg.Sync.MaxBlockHeaders = maxHeadersServed
+	cfg.Sync.CacheSize = 512
+	cfg.RPC.Enabled = true
+	cfg.RPC.Host = "0.0.0.0"
+	cfg.RPC.Port = 8545
+	cfg.RPC.CORSOrigins = []string{"*"}
+	cfg.RPC.MaxRequestSize = 1 << 20
+	cfg.RPC.MaxBatchSize = 100
+	cfg.RPC.BatchConcurrency = 4
+	cfg.Mining.Enabled = true
+	cfg.Mining.Threads = 1
+	cfg.Consensus.Participate = true
+	cfg.Consensus.MaxReorgDepth = DefaultMaxReorgDepth
+	mempool := DefaultMempoolConfig()
+	cfg.Mempool.MaxSize = mempool.MaxSize
+	cfg.Mempool.MaxPerSender = mempool.MaxPerSender
+	cfg.Mempool.Lifetime = int(mempool.Lifetime / time.Second)
+	cfg.Mempool.PriceBump = mempool.PriceBump
+	cfg.Logging.Level = "info"
+	cfg.Logging.MaxSize = 100
+	cfg.Logging.MaxBackups = 3
+	cfg.Metrics.Port = 9090
+	cfg.Metrics.Path = "/metrics"
+	cfg.Pruning.KeepRecentBlocks = 1000
+	cfg.Pruning.PruneInterval = 3600
+	return cfg
+}
+
+// loadNodeConfig reads the file named by the -config flag (if any), applies
+// environment overrides and validates the result
+func loadNodeConfig(args []string) (*NodeConfig, error) {
+	flags := flag.NewFlagSet("gydschain-node", flag.ContinueOnError)
+	path := flags.String("config", "", "path to node-config.yml")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := DefaultNodeConfig()
+	if *path != "" {
+		if err := cfg.loadFile(*path); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile merges a YAML config file over the current settings
+func (c *NodeConfig) loadFile(path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read config: %w", err)
+	}
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return fmt.Errorf("%s: %s", path, strings.TrimPrefix(err.Error(), "yaml: "))
+	}
+
+	// sync.checkpoint_interval is the old name of sync.progress_interval
+	if sync, ok := doc["sync"].(map[string]interface{}); ok {
+		if interval, ok := sync["checkpoint_interval"]; ok {
+			if _, both := sync["progress_interval"]; both {
+				return fmt.Errorf("%s: sync.checkpoint_interval is the old name of sync.progress_interval, set only one", path)
+			}
+			sync["progress_interval"] = interval
+			delete(sync, "checkpoint_interval")
+		}
+	}
+
+	// Round-trip through JSON so the struct tags drive decoding
+	encoded, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(encoded))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return fmt.Errorf("%s: %s: expected %s, got %s", path, typeErr.Field, typeErr.Type, typeErr.Value)
+		}
+		return fmt.Errorf("%s: %s", path, strings.TrimPrefix(err.Error(), "json: "))
+	}
+	return nil
+}
+
+// applyEnv overrides settings from environment variables
+func (c *NodeConfig) applyEnv() error {
+	var errs []string
+	str := func(name string, dst *string) {
+		if v, ok := os.LookupEnv(name); ok {
+			*dst = v
+		}
+	}
+	num := func(name string, dst *int) {
+		if v, ok := os.LookupEnv(name); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %q is not an integer", name, v))
+				return
+			}
+			*dst = n
+		}
+	}
+	num64 := func(name string, dst *int64) {
+		n := int(*dst)
+		num(name, &n)
+		*dst = int64(n)
+	}
+	boolean := func(name string, dst *bool) {
+		if v, ok := os.LookupEnv(name); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %q is not a boolean", name, v))
+				return
+			}
+			*dst = b
+		}
+	}
+
+	str("NODE_TYPE", &c.Node.Type)
+	str("DATA_DIR", &c.Node.DataDir)
+	str("GENESIS_FILE", &c.Node.GenesisFile)
+	num("P2P_PORT", &c.Network.Port)
+	num("MAX_PEERS", &c.Network.MaxPeers)
+	if v, ok := os.LookupEnv("BOOTSTRAP_NODES"); ok {
+		c.Network.BootstrapNodes = nil
+		for _, addr := range strings.Split(v, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				c.Network.BootstrapNodes = append(c.Network.BootstrapNodes, addr)
+			}
+		}
+	}
+	num("SYNC_MAX_BLOCK_BATCH", &c.Sync.MaxBlockBatch)
+	num("SYNC_CACHE_SIZE", &c.Sync.CacheSize)
+	boolean("SYNC_FAST_SYNC", &c.Sync.FastSync)
+	num64("SYNC_CHECKPOINT_INTERVAL", &c.Sync.ProgressInterval) // old name
+	num64("SYNC_PROGRESS_INTERVAL", &c.Sync.ProgressInterval)
+	str("RPC_HOST", &c.RPC.Host)
+	num("PORT", &c.RPC.Port)
+	num("RPC_MAX_BATCH_SIZE", &c.RPC.MaxBatchSize)
+	boolean("DISABLE_SERVER_SIGNING", &c.RPC.DisableServerSigning)
+	boolean("MINING_ENABLED", &c.Mining.Enabled)
+	num("MINING_THREADS", &c.Mining.Threads)
+	str("REWARD_ADDRESS", &c.Mining.RewardAddress)
+	str("VALIDATOR_ADDRESS", &c.Consensus.ValidatorAddress)
+	num64("MAX_REORG_DEPTH", &c.Consensus.MaxReorgDepth)
+	str("LOG_LEVEL", &c.Logging.Level)
+	str("LOG_FILE", &c.Logging.File)
+	str("PRIVATE_KEY_FILE", &c.Security.PrivateKeyFile)
+	boolean("METRICS_ENABLED", &c.Metrics.Enabled)
+	num("METRICS_PORT", &c.Metrics.Port)
+	boolean("PRUNING_ENABLED", &c.Pruning.Enabled)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid environment:\n  - %s", strings.Join(errs, "\n  - "))
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once
+func (c *NodeConfig) Validate() error {
+	var errs []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+	validPort := func(port int) bool { return port > 0 && port <= 65535 }
+
+	check(c.Node.Type == "full" || c.Node.Type == "lite", "node.type: must be \"full\" or \"lite\", got %q", c.Node.Type)
+	if err := validateClientVersion(c.Node.Version); err != nil {
+		check(false, "node.version: %v", err)
+	}
+	check(c.Node.DataDir != "", "node.data_dir: must not be empty")
+	if err := c.Location.validate(); err != nil {
+		check(false, "location.%v", err)
+	}
+
+	check(validPort(c.Network.Port), "network.port: must be between 1 and 65535, got %d", c.Network.Port)
+	check(c.Network.MaxPeers > 0, "network.max_peers: must be positive, got %d", c.Network.MaxPeers)
+	for _, addr := range c.Network.BootstrapNodes {
+		check(strings.Contains(addr, ":"), "network.bootstrap_nodes: %q must be host:port", addr)
+	}
+
+	check(c.Sync.ProgressInterval > 0, "sync.progress_interval: must be positive, got %d", c.Sync.ProgressInterval)
+	check(c.Sync.MaxBlockBatch > 0 && c.Sync.MaxBlockBatch <= maxBodiesServed,
+		"sync.max_block_batch: must be between 1 and %d, got %d", maxBodiesServed, c.Sync.MaxBlockBatch)
+	check(c.Sync.MaxBlockHeaders > 0, "sync.max_block_headers: must be positive, got %d", c.Sync.MaxBlockHeaders)
+	check(c.Sync.CacheSize > 0, "sync.cache_size: must be positive, got %d", c.Sync.CacheSize)
+
+	check(validPort(c.RPC.Port), "rpc.port: must be between 1 and 65535, got %d", c.RPC.Port)
+	check(c.RPC.MaxRequestSize > 0, "rpc.max_request_size: must be positive, got %d", c.RPC.MaxRequestSize)
+	check(c.RPC.MaxBatchSize > 0, "rpc.max_batch_size: must be positive, got %d", c.RPC.MaxBatchSize)
+	check(c.RPC.BatchConcurrency > 0, "rpc.batch_concurrency: must be positive, got %d", c.RPC.BatchConcurrency)
+
+	check(c.Mining.Threads > 0 && c.Mining.Threads <= 256, "mining.threads: must be between 1 and 256, got %d", c.Mining.Threads)
+	if c.Mining.RewardAddress != "" {
+		if err := ValidateAddress(c.Mining.RewardAddress); err != nil {
+			check(false, "mining.reward_address: %v", err)
+		}
+	}
+	if c.Wallet.Address != "" {
+		if err := ValidateAddress(c.Wallet.Address); err != nil {
+			check(false, "wallet.address: %v", err)
+		}
+	}
+	if c.Wallet.PublicKey != "" {
+		if key, err := DecodePublicKey(c.Wallet.PublicKey); err != nil {
+			check(false, "wallet.public_key: %v", err)
+		} else if c.Wallet.Address != "" && !addressesEqual(PublicKeyToAddress(key), c.Wallet.Address) {
+			check(false, "wallet.public_key: is the key of %s, not of wallet.address %s", PublicKeyToAddress(key), c.Wallet.Address)
+		}
+	}
+	check(!(c.Node.Type == "lite" && c.Mining.Enabled), "mining.enabled: lite nodes cannot mine")
+	check(!(c.Node.Type == "lite" && c.Consensus.Participate), "consensus.participate: lite nodes cannot produce blocks")
+	check(!(c.Node.Type == "full" && c.Sync.SPVMode), "sync.spv_mode: only lite nodes run in SPV mode")
+
+	if c.Consensus.ValidatorAddress != "" {
+		if err := ValidateAddress(c.Consensus.ValidatorAddress); err != nil {
+			check(false, "consensus.validator_address: %v", err)
+		}
+	}
+	check(c.Consensus.BlockTime >= 0, "consensus.block_time: must not be negative")
+	check(c.Consensus.BlockSizeLimit >= 0, "consensus.block_size_limit: must not be negative")
+	check(c.Consensus.MaxReorgDepth > 0, "consensus.max_reorg_depth: must be positive, got %d", c.Consensus.MaxReorgDepth)
+
+	check(c.Mempool.MaxSize > 0, "mempool.max_size: must be positive, got %d", c.Mempool.MaxSize)
+	check(c.Mempool.MaxPerSender > 0 && c.Mempool.MaxPerSender <= c.Mempool.MaxSize,
+		"mempool.max_per_sender: must be between 1 and mempool.max_size, got %d", c.Mempool.MaxPerSender)
+	check(c.Mempool.Lifetime > 0, "mempool.lifetime: must be positive, got %d", c.Mempool.Lifetime)
+	check(c.Mempool.PriceBump >= 0, "mempool.price_bump: must not be negative")
+
+	switch c.Logging.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		check(false, "logging.level: must be debug, info, warn or error, got %q", c.Logging.Level)
+	}
+	if c.Logging.File != "" {
+		check(c.Logging.MaxSize > 0, "logging.max_size: must be positive, got %d", c.Logging.MaxSize)
+		check(c.Logging.MaxBackups >= 0, "logging.max_backups: must not be negative")
+	}
+
+	if c.Security.TLSEnabled {
+		check(c.Security.TLSCert != "" && c.Security.TLSKey != "", "security.tls_cert and security.tls_key: required when tls_enabled is true")
+		for name, path := range map[string]string{"security.tls_cert": c.Security.TLSCert, "security.tls_key": c.Security.TLSKey} {
+			if path != "" {
+				_, err := os.Stat(path)
+				check(err == nil, "%s: %v", name, err)
+			}
+		}
+	}
+
+	if c.Metrics.Enabled {
+		check(validPort(c.Metrics.Port), "metrics.port: must be between 1 and 65535, got %d", c.Metrics.Port)
+		check(c.Metrics.Port != c.RPC.Port, "metrics.port: must differ from rpc.port")
+		check(strings.HasPrefix(c.Metrics.Path, "/"), "metrics.path: must start with /, got %q", c.Metrics.Path)
+	}
+
+	if c.Pruning.Enabled {
+		check(c.Pruning.KeepRecentBlocks > 0, "pruning.keep_recent_blocks: must be positive, got %d", c.Pruning.KeepRecentBlocks)
+		check(c.Pruning.PruneInterval > 0, "pruning.prune_interval: must be positive, got %d", c.Pruning.PruneInterval)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid node config:\n  - %s", strings.Join(errs, "\n  - "))
+	}
+	return nil
+}
+
+// P2PConfig returns the peer-to-peer settings
+func (c *NodeConfig) P2PConfig() P2PConfig {
+	return P2PConfig{
+		ListenAddr:     fmt.Sprintf("%s:%d", c.Network.ListenAddress, c.Network.Port),
+		BootstrapNodes: c.Network.BootstrapNodes,
+		MaxPeers:       c.Network.MaxPeers,
+		ClientVersion:  c.Node.Version,
+		Location:       c.NodeLocation(),
+	}
+}
+
+// NodeLocation returns the configured location, nil if none is set
+func (c *NodeConfig) NodeLocation() *Location {
+	if c.Location == (Location{}) {
+		return nil
+	}
+	location := c.Location
+	return &location
+}
+
+// MempoolConfig returns the pending transaction pool limits
+func (c *NodeConfig) MempoolConfig() MempoolConfig {
+	return MempoolConfig{
+		MaxSize:      c.Mempool.MaxSize,
+		MaxPerSender: c.Mempool.MaxPerSender,
+		Lifetime:     time.Duration(c.Mempool.Lifetime) * time.Second,
+		PriceBump:    c.Mempool.PriceBump,
+	}
+}
+
+// SyncConfig returns the chain sync settings
+func (c *NodeConfig) SyncConfig() SyncConfig {
+	return SyncConfig{
+		MaxBlockBatch:    c.Sync.MaxBlockBatch,
+		MaxHeaderBatch:   c.Sync.MaxBlockHeaders,
+		ProgressInterval: c.Sync.ProgressInterval,
+		FastSync:         c.Sync.FastSync,
+	}
+}
+
+// WalletAddress returns wallet.address, or the address of wallet.public_key
+// if only the key is set
+func (c *NodeConfig) WalletAddress() string {
+	if c.Wallet.Address == "" && c.Wallet.PublicKey != "" {
+		if key, err := DecodePublicKey(c.Wallet.PublicKey); err == nil {
+			return PublicKeyToAddress(key)
+		}
+	}
+	return c.Wallet.Address
+}
+
+// RPCAddr returns the address the HTTP/JSON-RPC server listens on
+func (c *NodeConfig) RPCAddr() string {
+	return fmt.Sprintf("%s:%d", c.RPC.Host, c.RPC.Port)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes a config file into a temporary directory
+func writeConfig(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "node-config.yml")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigFileOverridesDefaults(t *testing.T) {
+	cfg := DefaultNodeConfig()
+	err := cfg.loadFile(writeConfig(t, `# comment
+network:
+  port: 30304  # trailing comment
+  bootstrap_nodes:
+    - "node1.example.com:30303"
+    - node2.example.com:30303
+rpc:
+  cors_origins: ["https://a.example", 'https://b.example']
+mining:
+  enabled: false
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Network.Port != 30304 || cfg.Mining.Enabled || cfg.RPC.Port != 8545 {
+		t.Fatalf("port %d, mining %v, rpc port %d", cfg.Network.Port, cfg.Mining.Enabled, cfg.RPC.Port)
+	}
+	if got := strings.Join(cfg.Network.BootstrapNodes, ","); got != "node1.example.com:30303,node2.example.com:30303" {
+		t.Fatalf("bootstrap nodes = %s", got)
+	}
+	if got := strings.Join(cfg.RPC.CORSOrigins, ","); got != "https://a.example,https://b.example" {
+		t.Fatalf("cors origins = %s", got)
+	}
+}
+
+func TestConfigFileErrors(t *testing.T) {
+	for contents, want := range map[string]string{
+		"network:\n  port: abc\n":          "network.port: expected int",
+		"network:\n  port: 1\n   max: 2\n": "line 3",
+		"- a\n- b\n":                       "cannot unmarshal",
+		"rpc:\n  unknown_key: 1\n":         `unknown field "unknown_key"`,
+	} {
+		err := DefaultNodeConfig().loadFile(writeConfig(t, contents))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: loadFile = %v, want an error containing %q", contents, err, want)
+		}
+	}
+}
+
+func TestVersionAndLocation(t *testing.T) {
+	cfg := DefaultNodeConfig()
+	err := cfg.loadFile(writeConfig(t, `node:
+  version: "1.2.0-rc1"
+location:
+  region: "us-east-1"
+  country: "US"
+  latitude: 40.7128
+  longitude: -74.0060
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	want := Location{Region: "us-east-1", Country: "US", Latitude: 40.7128, Longitude: -74.006}
+	if p2p := cfg.P2PConfig(); p2p.ClientVersion != "1.2.0-rc1" || p2p.Location == nil || *p2p.Location != want {
+		t.Fatalf("reported version %q, location %+v", p2p.ClientVersion, p2p.Location)
+	}
+	if DefaultNodeConfig().NodeLocation() != nil {
+		t.Fatal("a location is reported when none is set")
+	}
+
+	for setting, want := range map[string]string{
+		"node:\n  version: \"\"\n":         "node.version",
+		"node:\n  version: \"1.0 beta\"\n": "node.version",
+		"location:\n  country: \"usa\"\n":  "location.country",
+		"location:\n  latitude: 91\n":      "location.latitude",
+		"location:\n  longitude: -181\n":   "location.longitude",
+	} {
+		cfg := DefaultNodeConfig()
+		if err := cfg.loadFile(writeConfig(t, setting)); err != nil {
+			t.Fatal(err)
+		}
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: Validate = %v, want an error about %s", setting, err, want)
+		}
+	}
+}
+
+func TestWalletPublicKey(t *testing.T) {
+	key, address := testKey(t)
+	other, _ := testKey(t)
+	publicKey := EncodePublicKey(&key.PublicKey)
+
+	// The key alone stands in for the address rewards are paid to
+	cfg := DefaultNodeConfig()
+	cfg.Wallet.PublicKey = publicKey
+	if err := cfg.Validate(); err != nil || cfg.WalletAddress() != address {
+		t.Fatalf("wallet address = %s, %v; want %s", cfg.WalletAddress(), err, address)
+	}
+	cfg.Wallet.Address = address
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, wallet := range map[string][2]string{
+		"another key":  {address, EncodePublicKey(&other.PublicKey)},
+		"not a key":    {"", "04ab"},
+		"not on curve": {"", strings.Repeat("11", 64)},
+	} {
+		cfg := DefaultNodeConfig()
+		cfg.Wallet.Address, cfg.Wallet.PublicKey = wallet[0], wallet[1]
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "wallet.public_key") {
+			t.Errorf("%s: Validate = %v, want a wallet.public_key error", name, err)
+		}
+	}
+}
+
+func TestExampleConfigsAreValid(t *testing.T) {
+	for _, name := range []string{"../node-config.full.example", "../node-config.lite.example"} {
+		cfg := DefaultNodeConfig()
+		if err := cfg.loadFile(name); err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestCheckpointIntervalIsProgressInterval(t *testing.T) {
+	cfg := DefaultNodeConfig()
+	if err := cfg.loadFile(writeConfig(t, "sync:\n  checkpoint_interval: 250\n")); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Sync.ProgressInterval != 250 {
+		t.Fatalf("progress interval = %d, want 250", cfg.Sync.ProgressInterval)
+	}
+
+	both := "sync:\n  checkpoint_interval: 250\n  progress_interval: 500\n"
+	if err := DefaultNodeConfig().loadFile(writeConfig(t, both)); err == nil {
+		t.Fatal("both names accepted")
+	}
+
+	t.Setenv("SYNC_CHECKPOINT_INTERVAL", "750")
+	cfg = DefaultNodeConfig()
+	if err := cfg.applyEnv(); err != nil || cfg.Sync.ProgressInterval != 750 {
+		t.Fatalf("progress interval = %d, %v; want 750", cfg.Sync.ProgressInterval, err)
+	}
+}
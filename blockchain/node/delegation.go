@@ -0,0 +1,295 @@
+package main
+
+import (
+	"errors"
+	"math/big"
+	"sort"
+)
+
+// Delegation transaction types. They are sent to the validator's address.
+const (
+	TxDelegate   = "delegate"   // bond Value from the balance to the validator
+	TxUndelegate = "undelegate" // start unbonding Value of the delegation
+	TxClaim      = "claim"      // pay the delegation's rewards to the balance
+)
+
+// rewardScale is the fixed-point precision of DelegationPool.RewardPerShare
+var rewardScale = new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+
+var (
+	ErrNotDelegated           = errors.New("no delegation to this validator")
+	ErrNothingToClaim         = errors.New("no delegation rewards to claim")
+	ErrInsufficientDelegation = errors.New("undelegate amount exceeds delegation")
+)
+
+// DelegationPool is the stake delegated to a validator. Delegators' rewards
+// accumulate in RewardPerShare, the reward paid per delegated wei scaled by
+// rewardScale, and are settled when a delegation changes or is claimed. The
+// pool outlives the validator leaving the set, so delegators can still
+// undelegate and claim.
+type DelegationPool struct {
+	Delegated      *big.Int `json:"delegated"`
+	RewardPerShare *big.Int `json:"rewardPerShare"`
+}
+
+func (p *DelegationPool) copy() *DelegationPool {
+	return &DelegationPool{
+		Delegated:      new(big.Int).Set(p.Delegated),
+		RewardPerShare: new(big.Int).Set(p.RewardPerShare),
+	}
+}
+
+// Delegation is stake an account has bonded to a validator
+type Delegation struct {
+	Validator   string   `json:"validator"`
+	Amount      *big.Int `json:"amount"`
+	Rewards     *big.Int `json:"rewards"`     // settled, not yet claimed
+	RewardEntry *big.Int `json:"rewardEntry"` // the pool's RewardPerShare when last settled
+}
+
+func (d Delegation) copy() Delegation {
+	return Delegation{
+		Validator:   d.Validator,
+		Amount:      new(big.Int).Set(d.Amount),
+		Rewards:     new(big.Int).Set(d.Rewards),
+		RewardEntry: new(big.Int).Set(d.RewardEntry),
+	}
+}
+
+// isDelegation reports whether a transaction is a delegation operation
+func isDelegation(tx *Transaction) bool {
+	return tx.Type == TxDelegate || tx.Type == TxUndelegate || tx.Type == TxClaim
+}
+
+// applyDelegation carries out a delegation transaction at block time now.
+// The fee and nonce are handled by the caller.
+func (s *State) applyDelegation(tx *Transaction, value *big.Int, now int64) error {
+	if !s.staking.Enabled {
+		return ErrStakingNotSupported
+	}
+	switch tx.Type {
+	case TxDelegate:
+		return s.delegate(tx.From, tx.To, value)
+	case TxUndelegate:
+		return s.undelegate(tx.From, tx.To, value, now)
+	default:
+		return s.claim(tx.From, tx.To)
+	}
+}
+
+// delegate bonds amount, already debited from the balance, to a validator
+func (s *State) delegate(delegator, validator string, amount *big.Int) error {
+	validator = normalizeAddress(validator)
+	val := s.GetAccount(validator).Validator
+	if val == nil || val.Status == ValidatorExited {
+		return ErrNotValidator
+	}
+	if val.Status == ValidatorExiting {
+		return ErrValidatorExiting
+	}
+	s.settleDelegation(delegator, validator)
+
+	acct := s.mutable(delegator)
+	i := delegationIndex(acct, validator)
+	acct.Delegations[i].Amount.Add(acct.Delegations[i].Amount, amount)
+	pool := s.mutable(validator).Pool
+	pool.Delegated.Add(pool.Delegated, amount)
+	return nil
+}
+
+// undelegate starts unbonding amount of a delegation. Its rewards stay
+// claimable.
+func (s *State) undelegate(delegator, validator string, amount *big.Int, now int64) error {
+	validator = normalizeAddress(validator)
+	del, ok := s.delegation(delegator, validator)
+	if !ok {
+		return ErrNotDelegated
+	}
+	if amount.Cmp(del.Amount) > 0 {
+		return ErrInsufficientDelegation
+	}
+	s.settleDelegation(delegator, validator)
+
+	acct := s.mutable(delegator)
+	i := delegationIndex(acct, validator)
+	acct.Delegations[i].Amount.Sub(acct.Delegations[i].Amount, amount)
+	acct.Unbonding = append(acct.Unbonding, Unbonding{Amount: new(big.Int).Set(amount), ReleaseAt: now + s.staking.UnlockDuration})
+	pruneDelegation(acct, i)
+	pool := s.mutable(validator).Pool
+	pool.Delegated.Sub(pool.Delegated, amount)
+	return nil
+}
+
+// claim pays the rewards of a delegation to the delegator's balance
+func (s *State) claim(delegator, validator string) error {
+	validator = normalizeAddress(validator)
+	if _, ok := s.delegation(delegator, validator); !ok {
+		return ErrNotDelegated
+	}
+	s.settleDelegation(delegator, validator)
+
+	acct := s.mutable(delegator)
+	i := delegationIndex(acct, validator)
+	rewards := acct.Delegations[i].Rewards
+	if rewards.Sign() == 0 {
+		return ErrNothingToClaim
+	}
+	acct.Balance.Add(acct.Balance, rewards)
+	acct.Delegations[i].Rewards = big.NewInt(0)
+	pruneDelegation(acct, i)
+	return nil
+}
+
+// settleDelegation moves the rewards a delegation has earned since it was
+// last settled into its Rewards, creating the delegation and the validator's
+// pool if they do not exist yet
+func (s *State) settleDelegation(delegator, validator string) {
+	if s.GetAccount(validator).Pool == nil {
+		s.mutable(validator).Pool = &DelegationPool{Delegated: big.NewInt(0), RewardPerShare: big.NewInt(0)}
+	}
+	perShare := s.accounts[validator].Pool.RewardPerShare
+
+	acct := s.mutable(delegator)
+	i := delegationIndex(acct, validator)
+	del := &acct.Delegations[i]
+	del.Rewards.Add(del.Rewards, accruedRewards(del, perShare))
+	del.RewardEntry = new(big.Int).Set(perShare)
+}
+
+// accruedRewards is what a delegation earned since it was last settled
+func accruedRewards(del *Delegation, perShare *big.Int) *big.Int {
+	accrued := new(big.Int).Sub(perShare, del.RewardEntry)
+	accrued.Mul(accrued, del.Amount)
+	return accrued.Div(accrued, rewardScale)
+}
+
+// delegation returns an account's delegation to a validator
+func (s *State) delegation(delegator, validator string) (Delegation, bool) {
+	for _, del := range s.GetAccount(delegator).Delegations {
+		if del.Validator == validator {
+			return del, true
+		}
+	}
+	return Delegation{}, false
+}
+
+// delegationIndex returns the index of acct's delegation to validator,
+// inserting an empty one in validator order if there is none
+func delegationIndex(acct *AccountState, validator string) int {
+	i := sort.Search(len(acct.Delegations), func(i int) bool { return acct.Delegations[i].Validator >= validator })
+	if i < len(acct.Delegations) && acct.Delegations[i].Validator == validator {
+		return i
+	}
+	acct.Delegations = append(acct.Delegations, Delegation{})
+	copy(acct.Delegations[i+1:], acct.Delegations[i:])
+	acct.Delegations[i] = Delegation{Validator: validator, Amount: big.NewInt(0), Rewards: big.NewInt(0), RewardEntry: big.NewInt(0)}
+	return i
+}
+
+// pruneDelegation drops a delegation with nothing bonded and nothing to claim
+func pruneDelegation(acct *AccountState, i int) {
+	if acct.Delegations[i].Amount.Sign() != 0 || acct.Delegations[i].Rewards.Sign() != 0 {
+		return
+	}
+	acct.Delegations = append(acct.Delegations[:i], acct.Delegations[i+1:]...)
+	if len(acct.Delegations) == 0 {
+		acct.Delegations = nil
+	}
+}
+
+// payStakeReward credits a POS block reward. Of the part proportional to the
+// delegations in the validator's effective stake, the validator keeps the
+// commission and the rest is shared among its delegators.
+func (s *State) payStakeReward(validator string, reward *big.Int) {
+	acct := s.GetAccount(validator)
+	if acct.Validator == nil || acct.Pool == nil || acct.Pool.Delegated.Sign() == 0 {
+		s.AddBalance(validator, reward)
+		return
+	}
+
+	effective := new(big.Int).Add(acct.Validator.Stake, acct.Pool.Delegated)
+	shared := new(big.Int).Mul(reward, acct.Pool.Delegated)
+	shared.Div(shared, effective)
+	shared.Mul(shared, big.NewInt(100-s.staking.Commission))
+	shared.Div(shared, big.NewInt(100))
+
+	perShare := new(big.Int).Mul(shared, rewardScale)
+	perShare.Div(perShare, acct.Pool.Delegated)
+	pool := s.mutable(validator).Pool
+	pool.RewardPerShare.Add(pool.RewardPerShare, perShare)
+
+	// The validator also keeps the dust the per-share division leaves over
+	distributed := new(big.Int).Mul(perShare, acct.Pool.Delegated)
+	distributed.Div(distributed, rewardScale)
+	s.AddBalance(validator, new(big.Int).Sub(reward, distributed))
+}
+
+// effectiveStake is a validator's own stake plus the stake delegated to it
+func effectiveStake(acct *AccountState) *big.Int {
+	stake := new(big.Int).Set(acct.Validator.Stake)
+	if acct.Pool != nil {
+		stake.Add(stake, acct.Pool.Delegated)
+	}
+	return stake
+}
+
+// DelegationView is a delegation with the rewards claimable now
+type DelegationView struct {
+	Delegator string `json:"delegator"`
+	Validator string `json:"validator"`
+	Amount    string `json:"amount"`
+	Rewards   string `json:"rewards"`
+}
+
+// delegationView settles a delegation's rewards for display without
+// changing the state
+func (s *State) delegationView(delegator string, del Delegation) DelegationView {
+	rewards := new(big.Int).Set(del.Rewards)
+	if pool := s.GetAccount(del.Validator).Pool; pool != nil {
+		rewards.Add(rewards, accruedRewards(&del, pool.RewardPerShare))
+	}
+	return DelegationView{
+		Delegator: normalizeAddress(delegator),
+		Validator: del.Validator,
+		Amount:    del.Amount.String(),
+		Rewards:   rewards.String(),
+	}
+}
+
+// Delegations returns the delegations made by an address
+func (s *State) Delegations(delegator string) []DelegationView {
+	views := []DelegationView{}
+	for _, del := range s.GetAccount(delegator).Delegations {
+		views = append(views, s.delegationView(delegator, del))
+	}
+	return views
+}
+
+// Delegators returns the delegations made to a validator, by delegator
+// address
+func (s *State) Delegators(validator string) []DelegationView {
+	validator = normalizeAddress(validator)
+	views := []DelegationView{}
+	for _, address := range s.sortedAddresses() {
+		for _, del := range s.accounts[address].Delegations {
+			if del.Validator == validator {
+				views = append(views, s.delegationView(address, del))
+			}
+		}
+	}
+	return views
+}
+
+// Delegations returns the delegations made by an address
+func (bc *Blockchain) Delegations(delegator string) []DelegationView {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.State.Delegations(delegator)
+}
+
+// Delegators returns the delegations made to a validator
+func (bc *Blockchain) Delegators(validator string) []DelegationView {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.State.Delegators(validator)
+}
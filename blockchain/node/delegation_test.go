@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"gydschain/client"
+)
+
+func TestStakeRewardIsPaidInFull(t *testing.T) {
+	state := testChain(t, testGenesis(t)).State
+	state.staking.Commission = 10
+	validator := "0x3333333333333333333333333333333333333333"
+	acct := state.mutable(validator)
+	acct.Validator = &ValidatorState{Status: ValidatorActive, Stake: big.NewInt(1e18)}
+	acct.Pool = &DelegationPool{Delegated: big.NewInt(3), RewardPerShare: big.NewInt(0)}
+	del := Delegation{Validator: validator, Amount: big.NewInt(3), Rewards: big.NewInt(0), RewardEntry: big.NewInt(0)}
+
+	reward := big.NewInt(1e18)
+	state.payStakeReward(validator, reward)
+
+	paid := new(big.Int).Add(state.GetBalance(validator), accruedRewards(&del, state.GetAccount(validator).Pool.RewardPerShare))
+	if paid.Cmp(reward) != 0 {
+		t.Fatalf("validator and delegators were paid %s of a %s reward", paid, reward)
+	}
+}
+
+func TestDelegatorsShareRewardsUntilUndelegated(t *testing.T) {
+	key, delegator := testKey(t)
+	validator := "0x3333333333333333333333333333333333333333"
+	state := fundedState(t, delegator)
+	state.staking.Commission = 10
+	state.mutable(validator).Validator = &ValidatorState{Status: ValidatorActive, Stake: gyds(50)}
+	now := int64(1_700_000_000)
+	nonce, fees := int64(0), big.NewInt(0)
+	apply := func(tx *client.Transaction) error {
+		fee, err := applyAt(state, now, signedTx(t, tx, key))
+		if err == nil {
+			nonce++
+			fees.Add(fees, fee)
+		}
+		return err
+	}
+
+	if err := apply(client.NewDelegate(delegator, "0x4444444444444444444444444444444444444444", gyds(10), nonce)); !errors.Is(err, ErrNotValidator) {
+		t.Fatalf("delegating to a non-validator = %v, want %v", err, ErrNotValidator)
+	}
+	if err := apply(client.NewDelegate(delegator, validator, gyds(50), nonce)); err != nil {
+		t.Fatal(err)
+	}
+	if val, _ := state.Validator(validator); val.EffectiveStake != gyds(100).String() {
+		t.Fatalf("effective stake = %s", val.EffectiveStake)
+	}
+	if err := apply(client.NewClaim(delegator, validator, nonce)); !errors.Is(err, ErrNothingToClaim) {
+		t.Fatalf("claim before any reward = %v, want %v", err, ErrNothingToClaim)
+	}
+
+	// Half the effective stake is delegated: 90% of half the reward is theirs
+	state.payStakeReward(validator, gyds(10))
+	if got := state.GetBalance(validator); got.Cmp(big.NewInt(55e16)) != 0 {
+		t.Fatalf("validator kept %s, want %s", got, big.NewInt(55e16))
+	}
+	if err := apply(client.NewClaim(delegator, validator, nonce)); err != nil {
+		t.Fatal(err)
+	}
+	want := new(big.Int).Sub(gyds(50), fees)
+	want.Add(want, big.NewInt(45e16))
+	if got := state.GetBalance(delegator); got.Cmp(want) != 0 {
+		t.Fatalf("delegator balance = %s, want %s", got, want)
+	}
+
+	// Undelegated stake unbonds and earns nothing more
+	if err := apply(client.NewUndelegate(delegator, validator, gyds(51), nonce)); !errors.Is(err, ErrInsufficientDelegation) {
+		t.Fatalf("undelegating too much = %v, want %v", err, ErrInsufficientDelegation)
+	}
+	if err := apply(client.NewUndelegate(delegator, validator, gyds(50), nonce)); err != nil {
+		t.Fatal(err)
+	}
+	unbonding := state.GetAccount(delegator).Unbonding
+	if len(unbonding) != 1 || unbonding[0].Amount.Cmp(gyds(50)) != 0 || unbonding[0].ReleaseAt != now+state.staking.UnlockDuration {
+		t.Fatalf("unbonding = %+v", unbonding)
+	}
+	if len(state.Delegations(delegator)) != 0 {
+		t.Fatalf("delegations left: %+v", state.Delegations(delegator))
+	}
+	state.payStakeReward(validator, gyds(10))
+	if err := apply(client.NewClaim(delegator, validator, nonce)); !errors.Is(err, ErrNotDelegated) {
+		t.Fatalf("claim after undelegating = %v, want %v", err, ErrNotDelegated)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"log"
+	"sync"
+)
+
+// Kinds of events published on the event bus
+const (
+	EventNewBlock = "block" // a block became part of the canonical chain
+	EventNewTx    = "tx"    // a transaction entered the pending pool
+)
+
+// ChainEvent is a chain change delivered to event bus subscribers
+type ChainEvent struct {
+	Kind  string
+	Block Block       // EventNewBlock
+	Tx    Transaction // EventNewTx
+}
+
+// eventBufferSize is how many events a subscriber may fall behind before it
+// is dropped
+const eventBufferSize = 256
+
+// EventBus fans chain events out to subscribers such as WebSocket clients. It
+// is registered as a ChainListener, so every block the node mines, mints or
+// receives and every transaction submitted over HTTP, RPC or P2P is published.
+type EventBus struct {
+	mu   sync.Mutex
+	subs map[*EventSubscription]bool
+}
+
+// EventSubscription receives events on C until it is unsubscribed. C is
+// closed if the subscriber does not keep up.
+type EventSubscription struct {
+	C   chan ChainEvent
+	bus *EventBus
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subs: make(map[*EventSubscription]bool)}
+}
+
+// Subscribe starts delivering events to a new subscription
+func (b *EventBus) Subscribe() *EventSubscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sub := &EventSubscription{C: make(chan ChainEvent, eventBufferSize), bus: b}
+	b.subs[sub] = true
+	return sub
+}
+
+// Unsubscribe stops delivery and closes C
+func (s *EventSubscription) Unsubscribe() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	if s.bus.subs[s] {
+		delete(s.bus.subs, s)
+		close(s.C)
+	}
+}
+
+// Publish delivers an event without blocking; subscribers whose buffer is
+// full are dropped
+func (b *EventBus) Publish(event ChainEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs {
+		select {
+		case sub.C <- event:
+		default:
+			log.Printf("⚠️  Dropping slow event subscriber")
+			delete(b.subs, sub)
+			close(sub.C)
+		}
+	}
+}
+
+func (b *EventBus) OnNewBlock(block Block) {
+	b.Publish(ChainEvent{Kind: EventNewBlock, Block: block})
+}
+
+func (b *EventBus) OnNewTransaction(tx Transaction) {
+	b.Publish(ChainEvent{Kind: EventNewTx, Tx: tx})
+}
+
+// OnReorg needs no action: the blocks of the new branch are published
+// through OnNewBlock
+func (b *EventBus) OnReorg(event ReorgEvent) {}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+)
+
+// DefaultMaxReorgDepth is how many blocks the chain may be rolled back by default
+const DefaultMaxReorgDepth = 100
+
+// posBlockWeight is the fork-choice weight of a PoS block, equal to the
+// minimum PoW difficulty
+const posBlockWeight = 0x10000
+
+// maxReorgHistory caps how many reorg events are kept for the API
+const maxReorgHistory = 100
+
+var (
+	ErrUnknownParent = errors.New("unknown parent block")
+	ErrReorgTooDeep  = errors.New("fork exceeds maximum reorg depth")
+)
+
+// blockNode is an entry in the block tree. Every canonical block and every
+// side-chain block within the reorg window has one.
+type blockNode struct {
+	Hash   string
+	Parent string
+	Index  int64
+	Weight *big.Int // cumulative fork-choice weight including this block
+}
+
+// blockUndo holds what is needed to take a block back off the chain head
+type blockUndo struct {
+	Accounts []accountUndo `json:"accounts"`
+	Meta     chainMeta     `json:"meta"` // chain bookkeeping before the block
+}
+
+// accountUndo is the value an account had before a block modified it
+type accountUndo struct {
+	Address string        `json:"address"`
+	Prev    *AccountState `json:"prev"` // nil if the account did not exist
+}
+
+// ReorgEvent describes a switch of the canonical chain to a heavier branch
+type ReorgEvent struct {
+	OldHead        string   `json:"oldHead"`
+	NewHead        string   `json:"newHead"`
+	CommonAncestor int64    `json:"commonAncestor"`
+	Depth          int64    `json:"depth"`
+	Removed        []string `json:"removed"`
+	Added          []string `json:"added"`
+	ReturnedTxs    int      `json:"returnedTxs"`
+	Timestamp      int64    `json:"timestamp"`
+}
+
+// blockWeight is a block's contribution to fork choice. PoW blocks count their
+// difficulty (work); PoS blocks count as a minimum-difficulty PoW block, so a
+// branch wins by total work plus the number of stake-produced blocks.
+func blockWeight(block *Block) *big.Int {
+	switch block.Type {
+	case "POW":
+		return big.NewInt(block.Difficulty)
+	case "POS":
+		return big.NewInt(posBlockWeight)
+	}
+	return big.NewInt(0)
+}
+
+// addTreeNode records a block in the block tree. Callers must hold bc.mu.
+func (bc *Blockchain) addTreeNode(block *Block) *blockNode {
+	if node, ok := bc.tree[block.Hash]; ok {
+		return node
+	}
+	weight := blockWeight(block)
+	if parent, ok := bc.tree[block.PreviousHash]; ok {
+		weight.Add(weight, parent.Weight)
+	}
+	node := &blockNode{
+		Hash:   block.Hash,
+		Parent: block.PreviousHash,
+		Index:  block.Index,
+		Weight: weight,
+	}
+	bc.tree[block.Hash] = node
+	return node
+}
+
+// isCanonical reports whether hash is on the canonical chain. Callers must hold bc.mu.
+func (bc *Blockchain) isCanonical(hash string) bool {
+	node, ok := bc.tree[hash]
+	return ok && node.Index < int64(len(bc.Blocks)) && bc.Blocks[node.Index].Hash == hash
+}
+
+// knownBlock returns a canonical or side-chain block. Callers must hold bc.mu.
+func (bc *Blockchain) knownBlock(hash string) (Block, bool) {
+	if bc.isCanonical(hash) {
+		return bc.Blocks[bc.tree[hash].Index], true
+	}
+	block, ok := bc.sideBlocks[hash]
+	return block, ok
+}
+
+// HasBlock reports whether a block is in the block tree, canonical or not
+func (bc *Blockchain) HasBlock(hash string) bool {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	_, ok := bc.tree[hash]
+	return ok
+}
+
+// HeadWeight returns the cumulative fork-choice weight of the canonical head
+func (bc *Blockchain) HeadWeight() *big.Int {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return new(big.Int).Set(bc.tree[bc.Blocks[len(bc.Blocks)-1].Hash].Weight)
+}
+
+// CanonicalHash returns the hash of the canonical block at index
+func (bc *Blockchain) CanonicalHash(index int64) (string, bool) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	if index < 0 || index >= int64(len(bc.Blocks)) {
+		return "", false
+	}
+	return bc.Blocks[index].Hash, true
+}
+
+// RecentReorgs returns the most recent reorg events, oldest first
+func (bc *Blockchain) RecentReorgs() []ReorgEvent {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return append([]ReorgEvent{}, bc.reorgs...)
+}
+
+// addSideBlock stores a block that does not extend the head, checked as far
+// as it can be without the state of its branch, and switches to its branch if
+// that branch is now heavier. Callers must hold bc.mu.
+func (bc *Blockchain) addSideBlock(block Block) error {
+	parent, ok := bc.knownBlock(block.PreviousHash)
+	if !ok {
+		return ErrUnknownParent
+	}
+
+	head := bc.Blocks[len(bc.Blocks)-1]
+	if head.Index-parent.Index > bc.MaxReorgDepth {
+		return ErrReorgTooDeep
+	}
+	// A side block only gains weight once its proposer and difficulty check
+	// out; without the validator set parent commits to it is refused until
+	// sync fetches the set. The branch's state is checked in reorg, once it
+	// is replayed.
+	if err := bc.checkBlock(&block, &parent); err != nil {
+		return err
+	}
+	if block.Type == "POW" {
+		difficulty, err := bc.difficultyAfter(&parent)
+		if err != nil {
+			return err
+		}
+		if block.Difficulty != difficulty {
+			return errors.New("invalid block difficulty")
+		}
+	}
+
+	bc.checkEquivocation(&block)
+	bc.sideBlocks[block.Hash] = block
+	node := bc.addTreeNode(&block)
+
+	if node.Weight.Cmp(bc.tree[head.Hash].Weight) <= 0 {
+		log.Printf("🌿 Side chain block #%d stored (%s)", block.Index, shortID(block.Hash))
+		return nil
+	}
+	return bc.reorg(block.Hash)
+}
+
+// difficultyAfter returns the POW difficulty in force on top of block on its
+// own branch: the canonical difficulty where the branch leaves the chain,
+// retargeted at every tenth block of the branch as applyBlock does. Callers
+// must hold bc.mu.
+func (bc *Blockchain) difficultyAfter(block *Block) (int64, error) {
+	// The side blocks between the canonical chain and block, oldest first
+	path := []Block{}
+	hash := block.Hash
+	for !bc.isCanonical(hash) {
+		side, ok := bc.sideBlocks[hash]
+		if !ok {
+			return 0, ErrUnknownParent
+		}
+		path = append([]Block{side}, path...)
+		hash = side.PreviousHash
+	}
+
+	fork := bc.tree[hash].Index
+	difficulty := bc.CurrentDiff
+	if fork < int64(len(bc.Blocks))-1 {
+		undo, err := bc.loadUndo(bc.Blocks[fork+1].Hash)
+		if err != nil {
+			return 0, err
+		}
+		difficulty = undo.Meta.CurrentDiff
+	}
+	timestamp := func(index int64) int64 {
+		if index <= fork {
+			return bc.Blocks[index].Timestamp
+		}
+		return path[index-fork-1].Timestamp
+	}
+	for _, side := range path {
+		if side.Type == "POW" && side.Index%10 == 0 {
+			difficulty = bc.retarget(difficulty, side.Timestamp-timestamp(side.Index-9))
+		}
+	}
+	return difficulty, nil
+}
+
+// reorg makes the branch ending at newHead canonical: the current chain is
+// rolled back to the common ancestor and the new branch applied. If any block
+// on the new branch turns out to be invalid the old chain is restored.
+// Callers must hold bc.mu.
+func (bc *Blockchain) reorg(newHead string) error {
+	oldHead := bc.Blocks[len(bc.Blocks)-1]
+
+	// Walk the new branch back to where it joins the canonical chain
+	branch := []Block{}
+	for hash := newHead; !bc.isCanonical(hash); {
+		block, ok := bc.sideBlocks[hash]
+		if !ok {
+			return ErrUnknownParent
+		}
+		branch = append([]Block{block}, branch...)
+		hash = block.PreviousHash
+	}
+	ancestor := branch[0].Index - 1
+	depth := oldHead.Index - ancestor
+	if depth > bc.MaxReorgDepth {
+		return ErrReorgTooDeep
+	}
+
+	removed := []Block{}
+	for int64(len(bc.Blocks)-1) > ancestor {
+		block, err := bc.revertHead()
+		if err != nil {
+			// Undo data is missing; put back what we took off and give up
+			bc.restoreChain(0, removed)
+			return err
+		}
+		removed = append(removed, block)
+	}
+
+	added := []Block{}
+	for _, block := range branch {
+		err := bc.validateNext(block)
+		if err == nil {
+			err = bc.applyBlock(block)
+		}
+		if err != nil {
+			bc.discardBranch(block.Hash, branch)
+			bc.restoreChain(len(added), removed)
+			return fmt.Errorf("reorg to #%d aborted, block #%d invalid: %w", branch[len(branch)-1].Index, block.Index, err)
+		}
+		added = append(added, block)
+	}
+
+	// Transactions that only existed on the abandoned branch go back to the pool
+	included := make(map[string]bool)
+	for _, block := range added {
+		for _, tx := range block.Transactions {
+			included[tx.Hash] = true
+		}
+	}
+	returned := []Transaction{}
+	for i := len(removed) - 1; i >= 0; i-- {
+		for _, tx := range removed[i].Transactions {
+			if !included[tx.Hash] {
+				returned = append(returned, tx)
+			}
+		}
+	}
+	for _, tx := range returned {
+		bc.pool.Add(tx, bc.State)
+	}
+	bc.prunePending(nil)
+
+	// The store still holds the old chain; carrying on would lose the new
+	// one on restart
+	if err := bc.persistChain(added, removed); err != nil {
+		log.Fatalf("❌ Failed to persist reorg: %v", err)
+	}
+
+	event := ReorgEvent{
+		OldHead:        oldHead.Hash,
+		NewHead:        newHead,
+		CommonAncestor: ancestor,
+		Depth:          depth,
+		Removed:        blockHashes(removed),
+		Added:          blockHashes(added),
+		ReturnedTxs:    len(returned),
+		Timestamp:      time.Now().Unix(),
+	}
+	bc.reorgs = append(bc.reorgs, event)
+	if len(bc.reorgs) > maxReorgHistory {
+		bc.reorgs = bc.reorgs[len(bc.reorgs)-maxReorgHistory:]
+	}
+
+	log.Printf("🔀 Reorg at #%d: depth %d, head #%d %s → #%d %s (%d txs returned)",
+		ancestor, depth, oldHead.Index, shortID(oldHead.Hash), added[len(added)-1].Index, shortID(newHead), len(returned))
+
+	for _, l := range bc.listeners {
+		l.OnReorg(event)
+		for _, block := range added {
+			l.OnNewBlock(block)
+		}
+	}
+	return nil
+}
+
+// restoreChain undoes a failed reorg: it takes the added blocks of the new
+// branch back off and re-applies the removed blocks of the old one. Those
+// were valid a moment ago, so a failure means the chain in memory no longer
+// matches the store and the node stops; on restart it loads the old chain.
+// Callers must hold bc.mu.
+func (bc *Blockchain) restoreChain(added int, removed []Block) {
+	for i := 0; i < added; i++ {
+		if _, err := bc.revertHead(); err != nil {
+			log.Fatalf("❌ Failed to restore the chain after an aborted reorg: %v", err)
+		}
+	}
+	for i := len(removed) - 1; i >= 0; i-- {
+		if err := bc.applyBlock(removed[i]); err != nil {
+			log.Fatalf("❌ Failed to restore block #%d after an aborted reorg: %v", removed[i].Index, err)
+		}
+	}
+}
+
+// revertHead takes the head block off the chain, restoring accounts and
+// bookkeeping to how they were before it. Callers must hold bc.mu.
+func (bc *Blockchain) revertHead() (Block, error) {
+	head := bc.Blocks[len(bc.Blocks)-1]
+	if head.Index == 0 {
+		return Block{}, errors.New("cannot revert genesis")
+	}
+
+	undo, err := bc.loadUndo(head.Hash)
+	if err != nil {
+		return Block{}, fmt.Errorf("missing undo data for block #%d: %w", head.Index, err)
+	}
+
+	bc.State.revert(undo.Accounts)
+	bc.TotalSupply.SetString(undo.Meta.TotalSupply, 10)
+	bc.CurrentDiff = undo.Meta.CurrentDiff
+	bc.LastPOWBlock = undo.Meta.LastPOWBlock
+	bc.LastPOSBlock = undo.Meta.LastPOSBlock
+
+	bc.Blocks = bc.Blocks[:len(bc.Blocks)-1]
+	bc.updateValidators()
+	bc.unindexBlock(&head)
+	bc.sideBlocks[head.Hash] = head
+	delete(bc.undo, head.Hash)
+	return head, nil
+}
+
+// discardBranch forgets an invalid block and everything after it on branch
+func (bc *Blockchain) discardBranch(invalid string, branch []Block) {
+	drop := false
+	for _, block := range branch {
+		if block.Hash == invalid {
+			drop = true
+		}
+		if drop {
+			delete(bc.sideBlocks, block.Hash)
+			delete(bc.tree, block.Hash)
+		}
+	}
+}
+
+// pruneSideBlocks drops side-chain blocks that fell out of the reorg window
+func (bc *Blockchain) pruneSideBlocks() {
+	limit := bc.Blocks[len(bc.Blocks)-1].Index - bc.MaxReorgDepth
+	for hash, block := range bc.sideBlocks {
+		if block.Index < limit {
+			delete(bc.sideBlocks, hash)
+			delete(bc.tree, hash)
+		}
+	}
+}
+
+func blockHashes(blocks []Block) []string {
+	hashes := make([]string, len(blocks))
+	for i, block := range blocks {
+		hashes[i] = block.Hash
+	}
+	return hashes
+}
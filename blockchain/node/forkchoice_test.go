@@ -0,0 +1,268 @@
+package main
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"gydschain/client"
+)
+
+// reseal changes a block with edit and finds a new nonce for it
+func reseal(block Block, edit func(*Block)) Block {
+	edit(&block)
+	block.Hash = calculateHash(block)
+	sealed, _ := searchNonce(block, 1, func() bool { return false })
+	return sealed
+}
+
+func TestHeavierBranchBecomesCanonical(t *testing.T) {
+	key, sender := testKey(t)
+	genesis := testGenesis(t)
+	genesis.Alloc = map[string]GenesisAccount{sender: {Balance: "10000000000000000000"}}
+	chain, other := testChain(t, genesis), testChain(t, genesis)
+	tx := signedTx(t, client.NewTransfer(sender, "0x3333333333333333333333333333333333333333", big.NewInt(5), 0), key)
+	if err := chain.AddTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, chain, "0x1111111111111111111111111111111111111111", 1)
+	dropped := chain.Blocks[1]
+	mineBlocks(t, other, "0x2222222222222222222222222222222222222222", 2)
+
+	// A branch as heavy as the chain is only stored...
+	if err := chain.AddBlock(other.Blocks[1]); err != nil {
+		t.Fatal(err)
+	}
+	if chain.Blocks[1].Hash != dropped.Hash {
+		t.Fatal("switched to a branch no heavier than the chain")
+	}
+	// ...a heavier one is switched to
+	if err := chain.AddBlock(other.Blocks[2]); err != nil {
+		t.Fatal(err)
+	}
+	if chain.Height() != 2 || chain.Blocks[2].Hash != other.Blocks[2].Hash {
+		t.Fatalf("head = #%d, want the branch head", chain.Height())
+	}
+	if chain.State.GetNonce(sender) != 0 || chain.State.GetBalance(sender).String() != "10000000000000000000" {
+		t.Fatal("state of the dropped block survived the reorg")
+	}
+	if lookup, ok := chain.TransactionByHash(tx.Hash); !ok || !lookup.Pending {
+		t.Fatal("transaction of the dropped block was not returned to the pool")
+	}
+	reorgs := chain.RecentReorgs()
+	if len(reorgs) != 1 || reorgs[0].Depth != 1 || reorgs[0].ReturnedTxs != 1 || reorgs[0].OldHead != dropped.Hash {
+		t.Fatalf("reorgs = %+v", reorgs)
+	}
+}
+
+func TestForkBelowReorgWindowRejected(t *testing.T) {
+	genesis := testGenesis(t)
+	chain := testChain(t, genesis)
+	chain.MaxReorgDepth = 2
+	mineBlocks(t, chain, "0x1111111111111111111111111111111111111111", 3)
+
+	other := testChain(t, genesis)
+	mineBlocks(t, other, "0x2222222222222222222222222222222222222222", 5)
+	if err := chain.AddBlock(other.Blocks[1]); !errors.Is(err, ErrReorgTooDeep) {
+		t.Fatalf("AddBlock = %v, want %v", err, ErrReorgTooDeep)
+	}
+
+	// A fork inside the window is still taken
+	near := testChain(t, genesis)
+	if err := near.AddBlock(chain.Blocks[1]); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, near, "0x2222222222222222222222222222222222222222", 3)
+	for _, block := range near.Blocks[2:] {
+		if err := chain.AddBlock(block); err != nil {
+			t.Fatalf("block #%d: %v", block.Index, err)
+		}
+	}
+	if chain.Height() != 4 || chain.Blocks[2].Hash != near.Blocks[2].Hash {
+		t.Fatal("fork inside the reorg window was not taken")
+	}
+}
+
+func TestAbortedReorgRestoresChain(t *testing.T) {
+	genesis := testGenesis(t)
+	chain := testChain(t, genesis)
+	mineBlocks(t, chain, "0x1111111111111111111111111111111111111111", 1)
+	head := chain.Blocks[1]
+
+	other := testChain(t, genesis)
+	mineBlocks(t, other, "0x2222222222222222222222222222222222222222", 2)
+	bad := reseal(other.Blocks[2], func(b *Block) { b.StateRoot = head.StateRoot })
+
+	if err := chain.AddBlock(other.Blocks[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.AddBlock(bad); err == nil {
+		t.Fatal("reorg onto a block with a wrong state root succeeded")
+	}
+
+	if chain.Height() != 1 || chain.Blocks[1].Hash != head.Hash {
+		t.Fatalf("head = #%d %s, want #1 %s", chain.Height(), shortID(chain.Blocks[len(chain.Blocks)-1].Hash), shortID(head.Hash))
+	}
+	if root := chain.State.Root(); root != head.StateRoot {
+		t.Fatalf("state root = %s, want %s", root, head.StateRoot)
+	}
+	if chain.HasBlock(bad.Hash) {
+		t.Fatal("invalid block kept in the block tree")
+	}
+}
+
+func TestSideBranchProposerCheckedAgainstBranchState(t *testing.T) {
+	oldKey, validator := testKey(t)
+	newKey, _ := testKey(t)
+	genesis := slashingGenesis(t, oldKey, validator)
+	chain := testChain(t, genesis)
+	other := testChain(t, genesis)
+
+	// The canonical chain rotates the validator's key...
+	tx := signedTx(t, client.NewRotateKey(validator, EncodePublicKey(&newKey.PublicKey), 0), oldKey)
+	if err := chain.AddTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, chain, "0x1111111111111111111111111111111111111111", 1)
+
+	// ...while on the heavier branch it still signs with the old one
+	mineBlocks(t, other, "0x2222222222222222222222222222222222222222", 1)
+	mintBlock(t, other, oldKey)
+
+	for _, block := range other.Blocks[1:] {
+		if err := chain.AddBlock(block); err != nil {
+			t.Fatalf("block #%d: %v", block.Index, err)
+		}
+	}
+	if head := chain.Blocks[len(chain.Blocks)-1]; head.Hash != other.Blocks[2].Hash {
+		t.Fatalf("head = #%d %s, want the branch head", head.Index, shortID(head.Hash))
+	}
+}
+
+// assertUndoWindow checks that exactly the blocks from floor up have undo
+// data, in memory and in store
+func assertUndoWindow(t *testing.T, chain *Blockchain, store Store, floor int64) {
+	t.Helper()
+	stored := map[string]bool{}
+	store.Iterate(prefixUndo, func(key string, _ []byte) error {
+		stored[key[len(prefixUndo):]] = true
+		return nil
+	})
+	for _, block := range chain.Blocks[1:] {
+		want := block.Index >= floor
+		if stored[block.Hash] != want {
+			t.Errorf("block #%d: undo stored %v, want %v", block.Index, stored[block.Hash], want)
+		}
+		if _, inMemory := chain.undo[block.Hash]; inMemory && !want {
+			t.Errorf("block #%d: undo kept in memory", block.Index)
+		}
+	}
+	if want := chain.Height() - floor + 1; int64(len(stored)) != want {
+		t.Errorf("%d undo entries stored, want %d", len(stored), want)
+	}
+}
+
+func TestUndoDataKeptOnlyInsideReorgWindow(t *testing.T) {
+	genesis := testGenesis(t)
+	miner := "0x1111111111111111111111111111111111111111"
+	store := NewMemoryStore()
+	chain, err := openBlockchain(store, genesis, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain.CurrentDiff = 0x1000
+	mineBlocks(t, chain, miner, 6)
+
+	// Shrinking the window drops everything that fell out of it at once
+	chain.MaxReorgDepth = 2
+	mineBlocks(t, chain, miner, 1)
+	assertUndoWindow(t, chain, store, 5)
+
+	// So does reopening the store with a stale entry in it
+	stale, _ := store.Get(undoKey(chain.Blocks[5].Hash))
+	store.Put(undoKey(chain.Blocks[1].Hash), stale)
+	chain, err = openBlockchain(store, genesis, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain.CurrentDiff = 0x1000
+	chain.MaxReorgDepth = 2
+	mineBlocks(t, chain, miner, 1)
+	assertUndoWindow(t, chain, store, 6)
+}
+
+func TestSideBlockDifficultyFollowsItsBranch(t *testing.T) {
+	genesis := testGenesis(t)
+	chain, other := testChain(t, genesis), testChain(t, genesis)
+	// Both retarget after block #10, so the chain's head is past the
+	// retarget while the branch's first blocks are not
+	mineBlocks(t, chain, "0x1111111111111111111111111111111111111111", 11)
+	mineBlocks(t, other, "0x2222222222222222222222222222222222222222", 11)
+	before, after := other.Blocks[10].Difficulty, other.Blocks[11].Difficulty
+	if before == after || chain.CurrentDiff != after {
+		t.Fatalf("difficulty %#x before the retarget, %#x after, %#x at the head", before, after, chain.CurrentDiff)
+	}
+
+	for _, block := range other.Blocks[1:10] {
+		if err := chain.AddBlock(block); err != nil {
+			t.Fatalf("block #%d: %v", block.Index, err)
+		}
+	}
+	// Block #10 is still due at the old difficulty on its branch...
+	atHead := reseal(other.Blocks[10], func(b *Block) { b.Difficulty = after })
+	if err := chain.AddBlock(atHead); err == nil {
+		t.Fatal("side block at the head's difficulty accepted")
+	}
+	if err := chain.AddBlock(other.Blocks[10]); err != nil {
+		t.Fatal(err)
+	}
+	// ...and retargets the branch for block #11
+	unchanged := reseal(other.Blocks[11], func(b *Block) { b.Difficulty = before })
+	if err := chain.AddBlock(unchanged); err == nil {
+		t.Fatal("side block missing its branch's retarget accepted")
+	}
+	if err := chain.AddBlock(other.Blocks[11]); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnverifiablePOSSideBlockRefused(t *testing.T) {
+	validatorKey, validator := testKey(t)
+	delegatorKey, delegator := testKey(t)
+	genesis := validatorGenesis(t, validatorKey, delegator)
+	chain, other := testChain(t, genesis), testChain(t, genesis)
+
+	// On the branch a delegation changes the set the next POS block is
+	// checked against
+	tx := signedTx(t, client.NewDelegate(delegator, validator, big.NewInt(1e18), 0), delegatorKey)
+	if err := other.AddTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, other, "0x2222222222222222222222222222222222222222", 1)
+	mintBlock(t, other, validatorKey)
+	mineBlocks(t, chain, "0x1111111111111111111111111111111111111111", 1)
+
+	if err := chain.AddBlock(other.Blocks[1]); err != nil {
+		t.Fatal(err)
+	}
+	pos := other.Blocks[2]
+	if err := chain.AddBlock(pos); !errors.Is(err, ErrUnknownValidatorSet) {
+		t.Fatalf("AddBlock = %v, want %v", err, ErrUnknownValidatorSet)
+	}
+	if chain.HasBlock(pos.Hash) {
+		t.Fatal("unverified POS block kept in the block tree")
+	}
+
+	// With the set fetched it checks out, and its branch takes over
+	root := other.Blocks[1].ValidatorsRoot
+	set, _ := other.ValidatorSet(root)
+	if err := chain.AddValidatorSet(root, set); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.AddBlock(pos); err != nil {
+		t.Fatal(err)
+	}
+	if head := chain.Blocks[len(chain.Blocks)-1]; head.Hash != pos.Hash {
+		t.Fatalf("head = #%d %s, want the branch head", head.Index, shortID(head.Hash))
+	}
+}
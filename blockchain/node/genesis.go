@@ -0,0 +1,426 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Genesis is the chain specification read from genesis.json
+type Genesis struct {
+	Config     GenesisConfig             `json:"config"`
+	Timestamp  string                    `json:"timestamp"`
+	Difficulty string                    `json:"difficulty"`
+	GasLimit   string                    `json:"gasLimit"`
+	Alloc      map[string]GenesisAccount `json:"alloc"`
+	Validators []GenesisValidator        `json:"validators"`
+	ExtraData  string                    `json:"extraData"`
+	Nonce      string                    `json:"nonce"`
+
+	// Parsed from the hex fields above by Validate
+	timestamp  int64
+	difficulty int64
+	nonce      int64
+}
+
+// GenesisConfig is the "config" section of genesis.json
+type GenesisConfig struct {
+	ChainID     int64  `json:"chainId"`
+	NetworkID   int64  `json:"networkId"`
+	ChainName   string `json:"chainName"`
+	NativeAsset string `json:"nativeAsset"`
+	Symbol      string `json:"symbol"`
+	Decimals    int    `json:"decimals"`
+	Consensus   struct {
+		Type string `json:"type"`
+		POW  struct {
+			Enabled                      bool   `json:"enabled"`
+			Algorithm                    string `json:"algorithm"`
+			BlockReward                  string `json:"blockReward"`
+			InitialDifficulty            string `json:"initialDifficulty"`
+			DifficultyAdjustmentInterval int64  `json:"difficultyAdjustmentInterval"`
+			RetargetingAlgorithm         string `json:"retargetingAlgorithm"`
+		} `json:"pow"`
+		POS struct {
+			Enabled             bool   `json:"enabled"`
+			MinStake            string `json:"minStake"`
+			StakeRewardPerBlock string `json:"stakeRewardPerBlock"`
+			ValidatorSlots      int    `json:"validatorSlots"`
+			StakeLockDuration   int64  `json:"stakeLockDuration"`
+			StakeUnlockDuration int64  `json:"stakeUnlockDuration"`
+			ValidatorCommission *int64 `json:"validatorCommission,omitempty"` // percent, defaults to defaultCommission
+			Slashing            bool   `json:"slashing"`
+			// Slashing parameters; left out or 0 means the default
+			DoubleSignSlashPercent int64  `json:"doubleSignSlashPercent,omitempty"`
+			DowntimeSlashPercent   int64  `json:"downtimeSlashPercent,omitempty"`
+			JailDuration           int64  `json:"jailDuration,omitempty"`
+			MaxMissedSlots         int    `json:"maxMissedSlots,omitempty"`
+			SlotTimeout            int64  `json:"slotTimeout,omitempty"`
+			SlashPenalty           string `json:"slashPenalty,omitempty"` // "burn" or "reporter"
+		} `json:"pos"`
+	} `json:"consensus"`
+	Block struct {
+		BlockTime          int    `json:"blockTime"`
+		GasLimit           int64  `json:"gasLimit"`
+		BaseTransactionFee string `json:"baseTransactionFee"`
+		MaxBlockSize       int64  `json:"maxBlockSize"`
+	} `json:"block"`
+	Economic struct {
+		MaximumSupply          string `json:"maximumSupply"`
+		InitialSupply          string `json:"initialSupply"`
+		StopMintingAtMaxSupply bool   `json:"stopMintingAtMaxSupply"`
+		DynamicMinting         bool   `json:"dynamicMinting"`
+	} `json:"economic"`
+}
+
+// GenesisAccount is a pre-allocated balance
+type GenesisAccount struct {
+	Balance string `json:"balance"`
+}
+
+// GenesisValidator is a validator active from the first block
+type GenesisValidator struct {
+	Address   string `json:"address"`
+	PublicKey string `json:"publicKey"`
+	Stake     string `json:"stake"`
+}
+
+// genesisPath returns the genesis file to load: the configured path if set,
+// otherwise genesis.json in the working directory or its parent (for `go run .` in node/)
+func genesisPath(configured string) string {
+	if configured != "" {
+		return configured
+	}
+	for _, path := range []string{"genesis.json", "../genesis.json"} {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return "genesis.json"
+}
+
+// LoadGenesis reads and validates a genesis file
+func LoadGenesis(path string) (*Genesis, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var g Genesis
+	if err := json.Unmarshal(raw, &g); err != nil {
+		return nil, fmt.Errorf("invalid genesis file %s: %w", path, err)
+	}
+	if err := g.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid genesis file %s: %w", path, err)
+	}
+	return &g, nil
+}
+
+// Validate checks the genesis specification and parses its hex fields
+func (g *Genesis) Validate() error {
+	var err error
+	if g.Config.ChainID <= 0 || g.Config.NetworkID <= 0 {
+		return errors.New("chainId and networkId must be positive")
+	}
+	if g.Config.Block.BlockTime <= 0 {
+		return errors.New("blockTime must be positive")
+	}
+	if g.timestamp, err = parseHexInt(g.Timestamp); err != nil {
+		return fmt.Errorf("timestamp: %w", err)
+	}
+	if g.difficulty, err = parseHexInt(g.Difficulty); err != nil {
+		return fmt.Errorf("difficulty: %w", err)
+	}
+	if g.nonce, err = parseHexInt(g.Nonce); err != nil {
+		return fmt.Errorf("nonce: %w", err)
+	}
+	initialDifficulty, err := g.initialDifficulty()
+	if err == nil {
+		err = checkDifficulty(initialDifficulty)
+	}
+	if err != nil {
+		return fmt.Errorf("initialDifficulty: %w", err)
+	}
+	gasLimit, err := g.blockGasLimit()
+	if err != nil {
+		return fmt.Errorf("gasLimit: %w", err)
+	}
+	if gasLimit < MinGasLimit {
+		return fmt.Errorf("block gasLimit %d is below the %d gas of a transfer", gasLimit, MinGasLimit)
+	}
+	if g.GasLimit != "" {
+		if top, err := parseHexInt(g.GasLimit); err != nil || top != gasLimit {
+			return fmt.Errorf("gasLimit %s does not match config.block.gasLimit %d", g.GasLimit, gasLimit)
+		}
+	}
+	if g.Config.Block.MaxBlockSize < 0 {
+		return errors.New("maxBlockSize must not be negative")
+	}
+	if g.ExtraData != "" {
+		if _, err := hex.DecodeString(strings.TrimPrefix(g.ExtraData, "0x")); err != nil {
+			return errors.New("extraData must be hex")
+		}
+	}
+
+	amounts := map[string]string{
+		"blockReward":         g.Config.Consensus.POW.BlockReward,
+		"stakeRewardPerBlock": g.Config.Consensus.POS.StakeRewardPerBlock,
+		"maximumSupply":       g.Config.Economic.MaximumSupply,
+	}
+	for name, value := range amounts {
+		if _, err := parseAmount(value); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	pos := g.Config.Consensus.POS
+	minStake := big.NewInt(0)
+	if pos.MinStake != "" {
+		var err error
+		if minStake, err = parseAmount(pos.MinStake); err != nil {
+			return fmt.Errorf("minStake: %w", err)
+		}
+	}
+	if pos.StakeLockDuration < 0 || pos.StakeUnlockDuration < 0 {
+		return errors.New("stakeLockDuration and stakeUnlockDuration must not be negative")
+	}
+	if c := pos.ValidatorCommission; c != nil && (*c < 0 || *c > 100) {
+		return fmt.Errorf("validatorCommission %d is not a percentage", *c)
+	}
+	if pos.DoubleSignSlashPercent < 0 || pos.DoubleSignSlashPercent > 100 || pos.DowntimeSlashPercent < 0 || pos.DowntimeSlashPercent > 100 {
+		return errors.New("doubleSignSlashPercent and downtimeSlashPercent must be percentages")
+	}
+	if pos.JailDuration < 0 || pos.MaxMissedSlots < 0 || pos.SlotTimeout < 0 {
+		return errors.New("jailDuration, maxMissedSlots and slotTimeout must not be negative")
+	}
+	if p := pos.SlashPenalty; p != "" && p != "burn" && p != "reporter" {
+		return fmt.Errorf("slashPenalty must be burn or reporter, not %q", p)
+	}
+
+	allocated := big.NewInt(0)
+	for address, acct := range g.Alloc {
+		if err := ValidateAddress(address); err != nil {
+			return fmt.Errorf("alloc %s: %w", address, err)
+		}
+		balance, err := parseAmount(acct.Balance)
+		if err != nil {
+			return fmt.Errorf("alloc %s: %w", address, err)
+		}
+		allocated.Add(allocated, balance)
+	}
+	maxSupply, _ := parseAmount(g.Config.Economic.MaximumSupply)
+	if allocated.Cmp(maxSupply) > 0 {
+		return errors.New("alloc exceeds maximumSupply")
+	}
+	if g.Config.Economic.InitialSupply != "" {
+		initial, err := parseAmount(g.Config.Economic.InitialSupply)
+		if err != nil {
+			return fmt.Errorf("initialSupply: %w", err)
+		}
+		if initial.Sign() > 0 && initial.Cmp(allocated) != 0 {
+			return fmt.Errorf("initialSupply %s does not match alloc total %s", initial, allocated)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, val := range g.Validators {
+		if err := ValidateAddress(val.Address); err != nil {
+			return fmt.Errorf("validator %s: %w", val.Address, err)
+		}
+		if seen[strings.ToLower(val.Address)] {
+			return fmt.Errorf("validator %s listed twice", val.Address)
+		}
+		seen[strings.ToLower(val.Address)] = true
+		if err := checkValidatorKey(val.Address, val.PublicKey); err != nil {
+			return fmt.Errorf("validator %s publicKey: %w", val.Address, err)
+		}
+		stake, err := parseAmount(val.Stake)
+		if err != nil {
+			return fmt.Errorf("validator %s stake: %w", val.Address, err)
+		}
+		if stake.Cmp(minStake) < 0 || stake.Sign() == 0 {
+			return fmt.Errorf("validator %s stake is below minStake %s", val.Address, minStake)
+		}
+	}
+	if slots := g.Config.Consensus.POS.ValidatorSlots; slots > 0 && len(g.Validators) > slots {
+		return fmt.Errorf("%d validators exceed %d validator slots", len(g.Validators), slots)
+	}
+	return nil
+}
+
+// Hash is a digest of the whole genesis specification. It is used as the
+// genesis block's parent hash so that nodes whose allocations, validators or
+// parameters differ end up with different genesis blocks.
+func (g *Genesis) Hash() string {
+	raw, _ := json.Marshal(g)
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// ToBlock builds the genesis block
+func (g *Genesis) ToBlock() Block {
+	block := Block{
+		Index:        0,
+		Timestamp:    g.timestamp,
+		Transactions: []Transaction{},
+		PreviousHash: g.Hash(),
+		Nonce:        g.nonce,
+		Difficulty:   g.difficulty,
+		Miner:        "genesis",
+		Type:         "GENESIS",
+		Reward:       "0",
+	}
+	block.TxRoot = transactionsRoot(block.Transactions)
+	block.ReceiptsRoot = receiptsRoot(nil)
+	state := g.allocState()
+	block.StateRoot = state.Root()
+	block.ValidatorsRoot = validatorsRoot(proposerSet(state.Validators()))
+	block.Hash = calculateHash(block)
+	return block
+}
+
+// allocState is the account ledger at genesis: the allocated balances and
+// the stake of the initial validators
+func (g *Genesis) allocState() *State {
+	state := NewState()
+	state.staking = g.ChainConfig().stakingRules()
+	state.slashing = g.ChainConfig().slashingRules()
+	for address, acct := range g.Alloc {
+		balance, _ := parseAmount(acct.Balance)
+		state.AddBalance(address, balance)
+	}
+	for _, val := range g.Validators {
+		stake, _ := parseAmount(val.Stake)
+		state.mutable(val.Address).Validator = &ValidatorState{
+			Status:      ValidatorActive,
+			PublicKey:   val.PublicKey,
+			Stake:       stake,
+			JoinedAt:    g.timestamp,
+			LockedUntil: g.timestamp + state.staking.LockDuration,
+		}
+	}
+	return state
+}
+
+// ChainConfig returns the chain parameters defined by the genesis config
+func (g *Genesis) ChainConfig() ChainConfig {
+	slots := g.Config.Consensus.POS.ValidatorSlots
+	if slots <= 0 {
+		slots = 21
+	}
+	gasLimit, _ := g.blockGasLimit()
+	maxSize := g.Config.Block.MaxBlockSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxBlockSize
+	}
+	minStake, err := parseAmount(g.Config.Consensus.POS.MinStake)
+	if err != nil {
+		minStake = big.NewInt(0)
+	}
+	commission := int64(defaultCommission)
+	if c := g.Config.Consensus.POS.ValidatorCommission; c != nil {
+		commission = *c
+	}
+	pos := g.Config.Consensus.POS
+	slotTimeout := pos.SlotTimeout
+	if slotTimeout <= 0 {
+		slotTimeout = 2 * int64(g.Config.Block.BlockTime)
+	}
+	return ChainConfig{
+		ChainID:         g.Config.ChainID,
+		NetworkID:       g.Config.NetworkID,
+		ChainName:       g.Config.ChainName,
+		MaxSupply:       g.Config.Economic.MaximumSupply,
+		BlockTime:       g.Config.Block.BlockTime,
+		POWEnabled:      g.Config.Consensus.POW.Enabled,
+		POSEnabled:      g.Config.Consensus.POS.Enabled,
+		BlockReward:     g.Config.Consensus.POW.BlockReward,
+		StakeReward:     g.Config.Consensus.POS.StakeRewardPerBlock,
+		ValidatorSlots:  slots,
+		MinStake:        minStake.String(),
+		StakeLock:       g.Config.Consensus.POS.StakeLockDuration,
+		StakeUnlock:     g.Config.Consensus.POS.StakeUnlockDuration,
+		Commission:      commission,
+		Slashing:        pos.Slashing,
+		DoubleSignSlash: orDefault(pos.DoubleSignSlashPercent, defaultDoubleSignSlash),
+		DowntimeSlash:   orDefault(pos.DowntimeSlashPercent, defaultDowntimeSlash),
+		JailDuration:    orDefault(pos.JailDuration, defaultJailDuration),
+		MaxMissedSlots:  int(orDefault(int64(pos.MaxMissedSlots), defaultMaxMissedSlots)),
+		SlotTimeout:     slotTimeout,
+		SlashPenalty:    pos.SlashPenalty,
+		BlockGasLimit:   gasLimit,
+		MaxBlockSize:    maxSize,
+	}
+}
+
+// Block limits used when genesis.json leaves them out
+const (
+	defaultBlockGasLimit = 30000000
+	defaultMaxBlockSize  = 2 << 20
+)
+
+// defaultCommission is the percent of the delegators' reward share a
+// validator keeps when genesis.json sets no validatorCommission
+const defaultCommission = 10
+
+// Slashing parameters used when genesis.json leaves them out. The slot
+// timeout defaults to two block times.
+const (
+	defaultDoubleSignSlash = 5 // percent
+	defaultDowntimeSlash   = 1 // percent
+	defaultJailDuration    = 86400
+	defaultMaxMissedSlots  = 10
+)
+
+func orDefault(v, def int64) int64 {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
+
+// blockGasLimit is the gas all transactions of a block may use together,
+// from config.block.gasLimit or else the top-level gasLimit
+func (g *Genesis) blockGasLimit() (int64, error) {
+	if g.Config.Block.GasLimit != 0 {
+		return g.Config.Block.GasLimit, nil
+	}
+	if g.GasLimit != "" {
+		return parseHexInt(g.GasLimit)
+	}
+	return defaultBlockGasLimit, nil
+}
+
+// initialDifficulty is the PoW difficulty of the first mined blocks
+func (g *Genesis) initialDifficulty() (int64, error) {
+	if g.Config.Consensus.POW.InitialDifficulty == "" {
+		return parseHexInt(g.Difficulty)
+	}
+	return parseHexInt(g.Config.Consensus.POW.InitialDifficulty)
+}
+
+// parseHexInt parses a 0x-prefixed (or decimal) non-negative integer
+func parseHexInt(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(s, 0, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return n, nil
+}
+
+// parseAmount parses a non-negative decimal wei amount
+func parseAmount(s string) (*big.Int, error) {
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok || n.Sign() < 0 {
+		return nil, fmt.Errorf("invalid amount %q", s)
+	}
+	return n, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenesisBlockIsDeterministic(t *testing.T) {
+	first := testGenesis(t).ToBlock()
+	if second := testGenesis(t).ToBlock(); second.Hash != first.Hash {
+		t.Fatalf("genesis.json produced %s, then %s", first.Hash, second.Hash)
+	}
+	if first.Hash != calculateHash(first) {
+		t.Fatal("genesis hash does not match its header")
+	}
+
+	// Every part of the specification is committed to
+	changed := testGenesis(t)
+	changed.Alloc["0x2222222222222222222222222222222222222222"] = GenesisAccount{Balance: "1"}
+	if changed.ToBlock().Hash == first.Hash {
+		t.Fatal("a different allocation produced the same genesis block")
+	}
+	changed = testGenesis(t)
+	changed.Config.Block.GasLimit++
+	if changed.ToBlock().Hash == first.Hash {
+		t.Fatal("a different gas limit produced the same genesis block")
+	}
+}
+
+func TestOpenRefusesOtherGenesis(t *testing.T) {
+	store := NewMemoryStore()
+	bc, err := openBlockchain(store, testGenesis(t), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesisHash := bc.Blocks[0].Hash
+
+	if bc, err = openBlockchain(store, testGenesis(t), false); err != nil {
+		t.Fatal(err)
+	}
+	if bc.Blocks[0].Hash != genesisHash {
+		t.Fatal("reopened with a different genesis block")
+	}
+
+	other := testGenesis(t)
+	other.ExtraData = "0x00"
+	if _, err := openBlockchain(store, other, false); err == nil || !strings.Contains(err.Error(), "different genesis") {
+		t.Fatalf("opening with another genesis: %v", err)
+	}
+}
@@ -3,3 +3,5 @@ module gydschain
 go 1.21
 
 require golang.org/x/crypto v0.31.0
+
+require gopkg.in/yaml.v3 v3.0.1
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"gydschain/client"
+)
+
+// testKey generates a key and returns it with its address
+func testKey(t *testing.T) (*ecdsa.PrivateKey, string) {
+	t.Helper()
+	key, err := client.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, normalizeAddress(client.Address(&key.PublicKey))
+}
+
+// testGenesis loads the repository's genesis.json
+func testGenesis(t *testing.T) *Genesis {
+	t.Helper()
+	genesis, err := LoadGenesis("../genesis.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return genesis
+}
+
+// testChain is an in-memory chain at genesis with an easy difficulty
+func testChain(t *testing.T, genesis *Genesis) *Blockchain {
+	t.Helper()
+	bc := initBlockchain(genesis)
+	bc.CurrentDiff = 0x1000
+	return bc
+}
+
+// signedTx signs a client transaction with key, for the repository genesis's
+// chain unless it names another, and converts it
+func signedTx(t *testing.T, tx *client.Transaction, key *ecdsa.PrivateKey) Transaction {
+	t.Helper()
+	if tx.ChainID == 0 {
+		tx.ChainID = testGenesis(t).Config.ChainID
+	}
+	if err := tx.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := json.Marshal(tx)
+	var converted Transaction
+	if err := json.Unmarshal(raw, &converted); err != nil {
+		t.Fatal(err)
+	}
+	return converted
+}
+
+// mineBlocks mines n POW blocks on bc paying miner
+func mineBlocks(t *testing.T, bc *Blockchain, miner string, n int) {
+	t.Helper()
+	blockchain, nodeAddress = bc, miner
+	for i := 0; i < n; i++ {
+		height := bc.Height()
+		minePOWBlock()
+		if bc.Height() != height+1 {
+			t.Fatalf("block #%d was not mined", height+1)
+		}
+	}
+}
+
+// pendingTransfers is a chain with n transfers from one sender waiting in
+// the pool, returned with its genesis
+func pendingTransfers(t *testing.T, n int) (*Blockchain, *Genesis) {
+	t.Helper()
+	key, sender := testKey(t)
+	genesis := testGenesis(t)
+	genesis.Alloc = map[string]GenesisAccount{sender: {Balance: "10000000000000000000"}}
+	bc := testChain(t, genesis)
+	for nonce := 0; nonce < n; nonce++ {
+		to := fmt.Sprintf("0x%040x", nonce+1)
+		if err := bc.AddTransaction(signedTx(t, client.NewTransfer(sender, to, big.NewInt(5), int64(nonce)), key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return bc, genesis
+}
+
+// minedTransfers is a chain whose block #1 holds n transfers from one sender,
+// returned with its genesis
+func minedTransfers(t *testing.T, n int) (*Blockchain, *Genesis) {
+	t.Helper()
+	bc, genesis := pendingTransfers(t, n)
+	mineBlocks(t, bc, "0x1111111111111111111111111111111111111111", 1)
+	if got := len(bc.Blocks[1].Transactions); got != n {
+		t.Fatalf("mined %d transactions, want %d", got, n)
+	}
+	return bc, genesis
+}
+
+// validatorGenesis is the repository genesis with validator staked in the
+// genesis block and funded holding 10 GYDS
+func validatorGenesis(t *testing.T, validator *ecdsa.PrivateKey, funded string) *Genesis {
+	t.Helper()
+	genesis := testGenesis(t)
+	genesis.Validators = []GenesisValidator{{
+		Address:   client.Address(&validator.PublicKey),
+		PublicKey: EncodePublicKey(&validator.PublicKey),
+		Stake:     "5000000000000000000",
+	}}
+	genesis.Alloc = map[string]GenesisAccount{funded: {Balance: "10000000000000000000"}}
+	if err := genesis.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return genesis
+}
+
+// mintBlock mints a POS block on bc as the validator holding key, which must
+// be the slot's proposer
+func mintBlock(t *testing.T, bc *Blockchain, key *ecdsa.PrivateKey) {
+	t.Helper()
+	blockchain, signingKey, validatorAddress = bc, key, PrivateKeyToAddress(key)
+	height := bc.Height()
+	mintPOSBlock()
+	if bc.Height() != height+1 {
+		t.Fatalf("block #%d was not minted", height+1)
+	}
+}
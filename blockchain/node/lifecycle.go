@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Validator statuses. Only active validators are drawn as proposers.
+const (
+	ValidatorPending = "pending" // staked, joins the rotation from the next block
+	ValidatorActive  = "active"
+	ValidatorJailed  = "jailed"  // slashed, out of the rotation until it unjails
+	ValidatorExiting = "exiting" // leaving, its stake still bonded and slashable until ExitAt
+	ValidatorExited  = "exited"  // left the set, its stake unbonding or withdrawn
+)
+
+// Validator lifecycle transaction types. They are sent to the sender's own
+// address with value 0.
+const (
+	TxUnjail    = "unjail"    // return to the rotation once the jail period is over
+	TxExit      = "exit"      // leave the set after the unlock duration
+	TxRotateKey = "rotateKey" // sign POS blocks from the next block with the public key in Data
+)
+
+var (
+	ErrNotJailed        = errors.New("validator is not jailed")
+	ErrStillJailed      = errors.New("jail period is not over")
+	ErrValidatorExiting = errors.New("validator is leaving the set")
+	ErrSameKey          = errors.New("key is already the validator's signing key")
+)
+
+// RetiredKey is a signing key a validator rotated away from. It is kept so a
+// double sign made with it can still be proven.
+type RetiredKey struct {
+	PublicKey string `json:"publicKey"`
+	Until     int64  `json:"until"` // the last height it could sign
+}
+
+// isLifecycle reports whether a transaction is a validator lifecycle operation
+func isLifecycle(tx *Transaction) bool {
+	return tx.Type == TxUnjail || tx.Type == TxExit || tx.Type == TxRotateKey
+}
+
+// applyLifecycle carries out a validator lifecycle transaction in block. The
+// fee and nonce are handled by the caller.
+func (s *State) applyLifecycle(tx *Transaction, block *Block) error {
+	if !s.staking.Enabled {
+		return ErrStakingNotSupported
+	}
+	val := s.GetAccount(tx.From).Validator
+	if val == nil || val.Status == ValidatorExited {
+		return ErrNotValidator
+	}
+	if val.Status == ValidatorExiting {
+		return ErrValidatorExiting
+	}
+
+	switch tx.Type {
+	case TxUnjail:
+		return s.unjail(tx.From, block.Timestamp)
+	case TxExit:
+		return s.exit(tx.From, block.Timestamp)
+	default:
+		return s.rotateKey(tx.From, tx.Data, block.Index)
+	}
+}
+
+// unjail returns a jailed validator to the rotation
+func (s *State) unjail(address string, now int64) error {
+	val := s.GetAccount(address).Validator
+	if val.Status != ValidatorJailed {
+		return ErrNotJailed
+	}
+	if now < val.JailedUntil {
+		return fmt.Errorf("%w until %d", ErrStillJailed, val.JailedUntil)
+	}
+	acct := s.mutable(address)
+	acct.Validator.Status = ValidatorActive
+	acct.Validator.JailedUntil = 0
+	return nil
+}
+
+// exit takes a validator out of the rotation. Its stake stays bonded, and
+// can still be slashed for faults it committed, for the unlock duration;
+// then it leaves the set and the stake can be withdrawn.
+func (s *State) exit(address string, now int64) error {
+	val := s.GetAccount(address).Validator
+	if now < val.LockedUntil {
+		return fmt.Errorf("%w until %d", ErrStakeLocked, val.LockedUntil)
+	}
+	acct := s.mutable(address)
+	acct.Validator.Status = ValidatorExiting
+	acct.Validator.JailedUntil = 0
+	acct.Validator.ExitAt = now + s.staking.UnlockDuration
+	return nil
+}
+
+// rotateKey replaces the key a validator signs POS blocks with. The old key
+// remains valid up to height, the block the rotation is included in.
+func (s *State) rotateKey(address, publicKeyHex string, height int64) error {
+	publicKey, err := DecodePublicKey(publicKeyHex)
+	if err != nil {
+		return err
+	}
+	key := EncodePublicKey(publicKey)
+	if key == s.GetAccount(address).Validator.PublicKey {
+		return ErrSameKey
+	}
+	val := s.mutable(address).Validator
+	val.RetiredKeys = append(val.RetiredKeys, RetiredKey{PublicKey: val.PublicKey, Until: height})
+	val.PublicKey = key
+	return nil
+}
+
+// keyAt returns the public key a validator signed blocks at height with
+func (v *ValidatorState) keyAt(height int64) string {
+	for _, retired := range v.RetiredKeys {
+		if height <= retired.Until {
+			return retired.PublicKey
+		}
+	}
+	return v.PublicKey
+}
+
+// advanceValidators moves validators along their lifecycle at the start of a
+// block: pending validators join the rotation and exiting validators whose
+// exit time has come leave the set, their stake released at once.
+func (s *State) advanceValidators(now int64) {
+	for _, address := range s.validatorAddresses() {
+		switch val := s.accounts[address].Validator; {
+		case val.Status == ValidatorPending:
+			s.mutable(address).Validator.Status = ValidatorActive
+		case val.Status == ValidatorExiting && val.ExitAt <= now:
+			s.releaseStake(address, val.ExitAt, val.ExitAt)
+		}
+	}
+}
+
+// uptime is the percentage of a validator's assigned slots it produced a
+// block in
+func uptime(val *ValidatorState) float64 {
+	slots := val.BlocksMinted + val.TotalMissed
+	if slots == 0 {
+		return 100
+	}
+	return math.Round(float64(val.BlocksMinted)*10000/float64(slots)) / 100
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"testing"
+
+	"gydschain/client"
+)
+
+// stakedValidator returns a state where address, holding key, staked 2 GYDS
+// at now and joined the rotation at the next block
+func stakedValidator(t *testing.T) (key *ecdsa.PrivateKey, address string, state *State, now int64) {
+	t.Helper()
+	key, address = testKey(t)
+	state = fundedState(t, address)
+	now = 1_700_000_000
+	if _, err := applyAt(state, now, signedTx(t, client.NewStake(address, gyds(20), 0), key)); err != nil {
+		t.Fatal(err)
+	}
+	state.advanceValidators(now)
+	return key, address, state, now
+}
+
+func TestUnjailAfterJailPeriod(t *testing.T) {
+	key, address, state, now := stakedValidator(t)
+
+	if _, err := applyAt(state, now, signedTx(t, client.NewUnjail(address, 1), key)); !errors.Is(err, ErrNotJailed) {
+		t.Fatalf("unjail while active = %v, want %v", err, ErrNotJailed)
+	}
+	state.slashing.JailDuration = 600
+	state.slash(address, 10, "", now)
+	if _, err := applyAt(state, now+599, signedTx(t, client.NewUnjail(address, 1), key)); !errors.Is(err, ErrStillJailed) {
+		t.Fatalf("unjail while jailed = %v, want %v", err, ErrStillJailed)
+	}
+	if _, err := applyAt(state, now+600, signedTx(t, client.NewUnjail(address, 1), key)); err != nil {
+		t.Fatal(err)
+	}
+	if val, _ := state.Validator(address); !val.Active || val.JailedUntil != 0 {
+		t.Fatalf("unjailed validator %s until %d", val.State, val.JailedUntil)
+	}
+}
+
+func TestExitReleasesStakeAfterUnlock(t *testing.T) {
+	key, address, state, now := stakedValidator(t)
+	rules := state.staking
+
+	unlocked := now + rules.LockDuration
+	if _, err := applyAt(state, unlocked-1, signedTx(t, client.NewExit(address, 1), key)); !errors.Is(err, ErrStakeLocked) {
+		t.Fatalf("exit while locked = %v, want %v", err, ErrStakeLocked)
+	}
+	if _, err := applyAt(state, unlocked, signedTx(t, client.NewExit(address, 1), key)); err != nil {
+		t.Fatal(err)
+	}
+	exitAt := unlocked + rules.UnlockDuration
+	if val, _ := state.Validator(address); val.State != ValidatorExiting || val.ExitAt != exitAt {
+		t.Fatalf("validator %s at %d, want exiting at %d", val.State, val.ExitAt, exitAt)
+	}
+	if _, err := applyAt(state, unlocked, signedTx(t, client.NewUnstake(address, gyds(20), 2), key)); !errors.Is(err, ErrValidatorExiting) {
+		t.Fatalf("unstake while exiting = %v, want %v", err, ErrValidatorExiting)
+	}
+
+	// Until it leaves its stake can still be slashed, without jailing it
+	state.slash(address, 10, "", exitAt-1)
+	state.advanceValidators(exitAt - 1)
+	if val, _ := state.Validator(address); val.State != ValidatorExiting || val.Stake != gyds(18).String() {
+		t.Fatalf("slashed exiting validator %s with stake %s", val.State, val.Stake)
+	}
+
+	// Then the rest is released at once
+	balance := state.GetBalance(address)
+	fee, err := applyAt(state, exitAt, signedTx(t, client.NewWithdraw(address, 2), key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val, _ := state.Validator(address); val.State != ValidatorExited || val.Stake != "0" {
+		t.Fatalf("validator %s with stake %s after its exit time", val.State, val.Stake)
+	}
+	want := balance.Add(balance, gyds(18))
+	want.Sub(want, fee)
+	if got := state.GetBalance(address); got.Cmp(want) != 0 {
+		t.Fatalf("balance = %s, want %s", got, want)
+	}
+}
+
+func TestRotateKeyRetiresOldKey(t *testing.T) {
+	key, address, state, now := stakedValidator(t)
+	oldKey := EncodePublicKey(&key.PublicKey)
+	newKey, _ := testKey(t)
+
+	if _, err := applyAt(state, now, signedTx(t, client.NewRotateKey(address, oldKey, 1), key)); !errors.Is(err, ErrSameKey) {
+		t.Fatalf("rotating to the same key = %v, want %v", err, ErrSameKey)
+	}
+	// applyAt's block is at height 0, the last the old key signs
+	if _, err := applyAt(state, now, signedTx(t, client.NewRotateKey(address, EncodePublicKey(&newKey.PublicKey), 1), key)); err != nil {
+		t.Fatal(err)
+	}
+	val := state.GetAccount(address).Validator
+	if val.PublicKey != EncodePublicKey(&newKey.PublicKey) {
+		t.Fatal("signing key not rotated")
+	}
+	if val.keyAt(0) != oldKey || val.keyAt(1) != val.PublicKey {
+		t.Fatalf("retired keys = %+v", val.RetiredKeys)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+)
+
+// ErrNoProof is returned when no full peer answers a lite node's query with
+// a proof that checks out against its headers
+var ErrNoProof = errors.New("no full peer returned a valid proof")
+
+// canonicalHeader returns the canonical header at index if its hash is hash
+func (bc *Blockchain) canonicalHeader(index int64, hash string) (BlockHeader, bool) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	if index < 0 || index >= int64(len(bc.Blocks)) || bc.Blocks[index].Hash != hash {
+		return BlockHeader{}, false
+	}
+	return bc.Blocks[index].Header(), true
+}
+
+// fullPeers returns the connected peers that keep bodies and state, highest
+// head first
+func (s *P2PServer) fullPeers() []*Peer {
+	s.mu.RLock()
+	peers := make([]*Peer, 0, len(s.peers))
+	for _, p := range s.peers {
+		if !p.getStatus().Lite {
+			peers = append(peers, p)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Height() > peers[j].Height() })
+	return peers
+}
+
+// txProof answers a lite peer's transaction query, nil if there is nothing
+// to prove
+func (s *P2PServer) txProof(hash string) *TxProof {
+	if s.chain.Lite {
+		return nil
+	}
+	proof, ok := s.chain.TxProof(hash)
+	if !ok {
+		return nil
+	}
+	return &proof
+}
+
+// accountProof answers a lite peer's account query
+func (s *P2PServer) accountProof(address string) *AccountProof {
+	if s.chain.Lite || ValidateAddress(address) != nil {
+		return nil
+	}
+	proof := s.chain.AccountProof(address)
+	return &proof
+}
+
+// validatorSet answers a peer's query for the validator set of a validators
+// root, nil if this node does not have it
+func (s *P2PServer) validatorSet(root string) []Validator {
+	set, ok := s.chain.ValidatorSet(root)
+	if !ok {
+		return nil
+	}
+	return set
+}
+
+// requestProof asks peer for a proof and decodes it into proof. A peer that
+// has nothing to prove answers null, reported as found = false.
+func requestProof(peer *Peer, msgType string, query string, proof interface{}) (found bool, err error) {
+	resp, err := peer.Request(msgType, query)
+	if err != nil {
+		return false, err
+	}
+	if string(resp.Payload) == "null" {
+		return false, nil
+	}
+	return true, json.Unmarshal(resp.Payload, proof)
+}
+
+// trustedHeader returns our canonical header for a proof's block. A header we
+// lack usually means the peer is ahead of us, so sync is nudged.
+func (s *P2PServer) trustedHeader(header BlockHeader) (BlockHeader, bool) {
+	trusted, ok := s.chain.canonicalHeader(header.Index, header.Hash)
+	if !ok && header.Index > s.chain.Height() && s.syncer != nil {
+		s.syncer.Trigger()
+	}
+	return trusted, ok
+}
+
+// ProveAccount fetches an account from full peers, accepting the first answer
+// whose proof checks out against this node's headers
+func (s *P2PServer) ProveAccount(address string) (AccountState, error) {
+	for _, peer := range s.fullPeers() {
+		var proof AccountProof
+		found, err := requestProof(peer, MsgGetAccountProof, address, &proof)
+		if err != nil || !found {
+			continue
+		}
+		header, ok := s.trustedHeader(proof.Header)
+		if !ok {
+			continue
+		}
+		acct, err := verifyAccountProof(&proof, address, &header)
+		if err != nil {
+			log.Printf("⚠️  Bad account proof from peer %s: %v", shortID(peer.ID()), err)
+			continue
+		}
+		return acct, nil
+	}
+	return AccountState{}, ErrNoProof
+}
+
+// FetchValidatorSet fetches the validator set of a validators root from full
+// peers, accepting the first one that hashes to the root
+func (s *P2PServer) FetchValidatorSet(root string) error {
+	for _, peer := range s.fullPeers() {
+		var set []Validator
+		found, err := requestProof(peer, MsgGetValidatorSet, root, &set)
+		if err != nil || !found {
+			continue
+		}
+		if err := s.chain.AddValidatorSet(root, set); err != nil {
+			log.Printf("⚠️  Bad validator set from peer %s: %v", shortID(peer.ID()), err)
+			continue
+		}
+		return nil
+	}
+	return ErrNoProof
+}
+
+// ProveTransaction fetches a mined transaction and its receipt from full
+// peers. It reports found = false if every peer that answered does not know
+// the transaction.
+func (s *P2PServer) ProveTransaction(hash string) (proof TxProof, found bool, err error) {
+	answered := false
+	for _, peer := range s.fullPeers() {
+		var candidate TxProof
+		found, err := requestProof(peer, MsgGetTxProof, hash, &candidate)
+		if err != nil {
+			continue
+		}
+		answered = true
+		if !found {
+			continue
+		}
+		header, ok := s.trustedHeader(candidate.Header)
+		if !ok {
+			continue
+		}
+		if err := verifyTxProof(&candidate, &header); err != nil {
+			log.Printf("⚠️  Bad transaction proof from peer %s: %v", shortID(peer.ID()), err)
+			continue
+		}
+		return candidate, true, nil
+	}
+	if !answered {
+		return TxProof{}, false, ErrNoProof
+	}
+	return TxProof{}, false, nil
+}
+
+// lookupAccount returns an account from the local state, or on a lite node
+// from a proof fetched from a full peer
+func lookupAccount(address string) (AccountState, error) {
+	if !blockchain.Lite {
+		return blockchain.Account(address), nil
+	}
+	return p2pServer.ProveAccount(address)
+}
+
+// lookupTransaction finds a transaction, with its receipt once mined. A lite
+// node has no pool and no bodies, so it only finds mined transactions, through
+// a proof fetched from a full peer.
+func lookupTransaction(hash string) (lookup TxLookup, receipt *Receipt, found bool, err error) {
+	if !blockchain.Lite {
+		lookup, found = blockchain.TransactionByHash(hash)
+		if r, ok := blockchain.Receipt(hash); ok && found && !lookup.Pending {
+			receipt = &r
+		}
+		return lookup, receipt, found, nil
+	}
+
+	proof, found, err := p2pServer.ProveTransaction(hash)
+	if err != nil || !found {
+		return TxLookup{}, nil, false, err
+	}
+	lookup = TxLookup{
+		Tx:          proof.Transaction,
+		BlockHash:   proof.Header.Hash,
+		BlockNumber: proof.Header.Index,
+		Index:       proof.Index,
+	}
+	return lookup, &proof.Receipt, true, nil
+}
+
+// lookupTxProof returns the inclusion proof of a mined transaction. A lite
+// node passes on a proof from a full peer once it has checked it.
+func lookupTxProof(hash string) (TxProof, bool, error) {
+	if !blockchain.Lite {
+		proof, ok := blockchain.TxProof(hash)
+		return proof, ok, nil
+	}
+	return p2pServer.ProveTransaction(hash)
+}
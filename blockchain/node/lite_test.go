@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"gydschain/client"
+)
+
+// liteSyncedTo returns a lite chain with the same genesis as full, synced
+// from it over loopback, and the lite node's server
+func liteSyncedTo(t *testing.T, full *Blockchain, genesis *Genesis) (*Blockchain, *P2PServer) {
+	t.Helper()
+	lite, err := openBlockchain(NewMemoryStore(), genesis, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lite.CurrentDiff = full.CurrentDiff
+	server := testServer(t, full)
+	liteServer, _ := testSyncedServer(t, lite, SyncConfig{}, server.ListenAddr())
+	waitFor(t, "lite sync", func() bool { return lite.Height() == full.Height() })
+	return lite, liteServer
+}
+
+func TestLiteNodeSyncsHeaders(t *testing.T) {
+	full, genesis := minedTransfers(t, 3)
+	lite, _ := liteSyncedTo(t, full, genesis)
+
+	head := lite.Blocks[len(lite.Blocks)-1]
+	if head.Hash != full.Blocks[1].Hash {
+		t.Fatalf("lite head = %s, want %s", shortID(head.Hash), shortID(full.Blocks[1].Hash))
+	}
+	if len(head.Transactions) != 0 {
+		t.Fatalf("lite node kept %d transaction bodies", len(head.Transactions))
+	}
+}
+
+func TestLiteNodeProvesFromFullPeers(t *testing.T) {
+	full, genesis := minedTransfers(t, 3)
+	_, server := liteSyncedTo(t, full, genesis)
+	waitFor(t, "full peer", func() bool { return len(server.fullPeers()) == 1 })
+
+	sender := full.Blocks[1].Transactions[0].From
+	acct, err := server.ProveAccount(sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acct.Balance.Cmp(full.State.GetBalance(sender)) != 0 || acct.Nonce != 3 {
+		t.Fatalf("proven account balance %s nonce %d", acct.Balance, acct.Nonce)
+	}
+
+	tx := full.Blocks[1].Transactions[2]
+	proof, found, err := server.ProveTransaction(tx.Hash)
+	if err != nil || !found {
+		t.Fatalf("ProveTransaction: found %v, %v", found, err)
+	}
+	if proof.Index != 2 || proof.Receipt.TxHash != tx.Hash {
+		t.Fatalf("proof for index %d, receipt %s", proof.Index, shortID(proof.Receipt.TxHash))
+	}
+	if _, found, err := server.ProveTransaction(full.Blocks[0].Hash); found || err != nil {
+		t.Fatalf("ProveTransaction of a block hash: found %v, %v", found, err)
+	}
+}
+
+func TestLiteNodeWithoutFullPeers(t *testing.T) {
+	lite, err := openBlockchain(NewMemoryStore(), testGenesis(t), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := testServer(t, lite)
+	if _, err := server.ProveAccount("0x0000000000000000000000000000000000000001"); !errors.Is(err, ErrNoProof) {
+		t.Fatalf("ProveAccount = %v, want %v", err, ErrNoProof)
+	}
+}
+
+// delegatedChain is a full chain with a POS block, then a POW block with a
+// delegation that changes the validator set, then a POS block checked
+// against the new set. It returns the chain, the validator and a lite chain
+// at genesis.
+func delegatedChain(t *testing.T) (full *Blockchain, validator string, lite *Blockchain) {
+	t.Helper()
+	validatorKey, validator := testKey(t)
+	delegatorKey, delegator := testKey(t)
+	genesis := validatorGenesis(t, validatorKey, delegator)
+	full = testChain(t, genesis)
+	lite, err := openBlockchain(NewMemoryStore(), genesis, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lite.CurrentDiff = full.CurrentDiff
+
+	mintBlock(t, full, validatorKey)
+	tx := signedTx(t, client.NewDelegate(delegator, validator, big.NewInt(1e18), 0), delegatorKey)
+	if err := full.AddTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, full, "0x1111111111111111111111111111111111111111", 1)
+	mintBlock(t, full, validatorKey)
+	if full.Blocks[2].ValidatorsRoot == full.Blocks[1].ValidatorsRoot {
+		t.Fatal("delegation left the validators root unchanged")
+	}
+	return full, validator, lite
+}
+
+func TestLiteNodeChecksProposers(t *testing.T) {
+	full, validator, lite := delegatedChain(t)
+	changed := full.Blocks[2].ValidatorsRoot
+
+	for _, block := range full.Blocks[1:3] {
+		if err := lite.AddBlock(block); err != nil {
+			t.Fatalf("block #%d: %v", block.Index, err)
+		}
+	}
+	if err := lite.AddBlock(full.Blocks[3]); !errors.Is(err, ErrUnknownValidatorSet) {
+		t.Fatalf("AddBlock without the validator set = %v, want %v", err, ErrUnknownValidatorSet)
+	}
+
+	// The set is only taken if it matches the root
+	set, ok := full.ValidatorSet(changed)
+	if !ok {
+		t.Fatal("full node does not serve its validator set")
+	}
+	inflated := append([]Validator(nil), set...)
+	inflated[0].EffectiveStake = "100000000000000000000"
+	if err := lite.AddValidatorSet(changed, inflated); err == nil {
+		t.Fatal("validator set not matching its root was accepted")
+	}
+	if err := lite.AddValidatorSet(changed, set); err != nil {
+		t.Fatal(err)
+	}
+
+	// A block from anyone but the drawn validator is rejected...
+	otherKey, other := testKey(t)
+	wrong := full.Blocks[3]
+	wrong.Validator = other
+	wrong.Hash = calculateHash(wrong)
+	if err := signBlock(&wrong, otherKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := lite.AddBlock(wrong); !errors.Is(err, ErrWrongProposer) {
+		t.Fatalf("AddBlock from the wrong proposer = %v, want %v", err, ErrWrongProposer)
+	}
+	// ...and so is the drawn validator's block signed by another key
+	forged := full.Blocks[3]
+	if err := signBlock(&forged, otherKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := lite.AddBlock(forged); !errors.Is(err, ErrBadBlockSignature) {
+		t.Fatalf("AddBlock with a forged signature = %v, want %v", err, ErrBadBlockSignature)
+	}
+	if err := lite.AddBlock(full.Blocks[3]); err != nil {
+		t.Fatal(err)
+	}
+	if lite.Validators[validator].EffectiveStake != "6000000000000000000" {
+		t.Fatalf("lite validators = %+v", lite.Validators)
+	}
+}
+
+func TestLiteNodeSyncsValidatorSets(t *testing.T) {
+	full, _, lite := delegatedChain(t)
+	server := testServer(t, full)
+	testSyncedServer(t, lite, SyncConfig{}, server.ListenAddr())
+
+	waitFor(t, "lite sync", func() bool { return lite.Height() == full.Height() })
+	if head := lite.Blocks[len(lite.Blocks)-1]; head.Hash != full.Blocks[3].Hash {
+		t.Fatalf("lite head = %s, want %s", shortID(head.Hash), shortID(full.Blocks[3].Hash))
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// setupLogging points the standard logger at stdout and, if configured, a
+// size-rotated log file, dropping lines below the configured level. Levels
+// follow the log prefixes used across the node: ❌ is an error, ⚠️ a warning,
+// everything else is info.
+func setupLogging(cfg *NodeConfig) error {
+	var out io.Writer = os.Stdout
+	if cfg.Logging.File != "" {
+		file, err := newRotatingFile(cfg.Logging.File, int64(cfg.Logging.MaxSize)<<20, cfg.Logging.MaxBackups)
+		if err != nil {
+			return fmt.Errorf("cannot open log file: %w", err)
+		}
+		out = io.MultiWriter(os.Stdout, file)
+	}
+	log.SetOutput(&levelWriter{out: out, level: logLevels[cfg.Logging.Level]})
+	return nil
+}
+
+var logLevels = map[string]int{"debug": 0, "info": 1, "warn": 2, "error": 3}
+
+type levelWriter struct {
+	out   io.Writer
+	level int
+}
+
+func (w *levelWriter) Write(p []byte) (int, error) {
+	if lineLevel(p) < w.level {
+		return len(p), nil
+	}
+	return w.out.Write(p)
+}
+
+// lineLevel classifies a formatted log line by its emoji prefix
+func lineLevel(line []byte) int {
+	switch {
+	case bytes.Contains(line, []byte("❌")):
+		return logLevels["error"]
+	case bytes.Contains(line, []byte("⚠️")):
+		return logLevels["warn"]
+	}
+	return logLevels["info"]
+}
+
+// rotatingFile is a log file that is renamed to path.1, path.2, ... once it
+// exceeds maxSize bytes, keeping at most maxBackups old files
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+	mu         sync.Mutex
+}
+
+func newRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	r := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.size+int64(len(p)) > r.maxSize && r.size > 0 {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	r.file.Close()
+	os.Remove(fmt.Sprintf("%s.%d", r.path, r.maxBackups))
+	for i := r.maxBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	if r.maxBackups > 0 {
+		os.Rename(r.path, r.path+".1")
+	} else {
+		os.Remove(r.path)
+	}
+	return r.open()
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/ecdsa"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
@@ -9,36 +10,78 @@ import (
 	"math/big"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
 // Chain Configuration
 type ChainConfig struct {
-	ChainID     int64  `json:"chainId"`
-	NetworkID   int64  `json:"networkId"`
-	ChainName   string `json:"chainName"`
-	MaxSupply   string `json:"maximumSupply"`
-	BlockTime   int    `json:"blockTime"`
-	POWEnabled  bool   `json:"powEnabled"`
-	POSEnabled  bool   `json:"posEnabled"`
-	BlockReward string `json:"blockReward"`
-	StakeReward string `json:"stakeReward"`
+	ChainID         int64  `json:"chainId"`
+	NetworkID       int64  `json:"networkId"`
+	ChainName       string `json:"chainName"`
+	MaxSupply       string `json:"maximumSupply"`
+	BlockTime       int    `json:"blockTime"`
+	POWEnabled      bool   `json:"powEnabled"`
+	POSEnabled      bool   `json:"posEnabled"`
+	BlockReward     string `json:"blockReward"`
+	StakeReward     string `json:"stakeReward"`
+	ValidatorSlots  int    `json:"validatorSlots"`
+	MinStake        string `json:"minStake"`
+	StakeLock       int64  `json:"stakeLockDuration"`   // seconds new stake stays locked
+	StakeUnlock     int64  `json:"stakeUnlockDuration"` // seconds unstaked funds take to release
+	Commission      int64  `json:"validatorCommission"` // percent of the delegators' reward share validators keep
+	Slashing        bool   `json:"slashing"`
+	DoubleSignSlash int64  `json:"doubleSignSlashPercent"`
+	DowntimeSlash   int64  `json:"downtimeSlashPercent"`
+	JailDuration    int64  `json:"jailDuration"`   // seconds
+	MaxMissedSlots  int    `json:"maxMissedSlots"` // in a row before a downtime fault
+	SlotTimeout     int64  `json:"slotTimeout"`    // seconds
+	SlashPenalty    string `json:"slashPenalty"`   // "burn" or "reporter"
+	BlockGasLimit   int64  `json:"blockGasLimit"`
+	MaxBlockSize    int64  `json:"maxBlockSize"` // bytes of the block's JSON encoding
 }
 
 // Block structure
 type Block struct {
-	Index        int64             `json:"index"`
-	Timestamp    int64             `json:"timestamp"`
-	Transactions []Transaction     `json:"transactions"`
-	PreviousHash string            `json:"previousHash"`
-	Hash         string            `json:"hash"`
-	Nonce        int64             `json:"nonce"`
-	Difficulty   int64             `json:"difficulty"`
-	Miner        string            `json:"miner"`
-	Validator    string            `json:"validator"`
-	Type         string            `json:"type"` // "POW" or "POS"
-	Reward       string            `json:"reward"`
+	Index          int64         `json:"index"`
+	Timestamp      int64         `json:"timestamp"`
+	Transactions   []Transaction `json:"transactions"`
+	PreviousHash   string        `json:"previousHash"`
+	TxRoot         string        `json:"transactionsRoot"`
+	ReceiptsRoot   string        `json:"receiptsRoot"`
+	StateRoot      string        `json:"stateRoot"`
+	ValidatorsRoot string        `json:"validatorsRoot"` // validator set in force for the next block
+	Hash           string        `json:"hash"`
+	Nonce          int64         `json:"nonce"`
+	Difficulty     int64         `json:"difficulty"`
+	Miner          string        `json:"miner"`
+	Validator      string        `json:"validator"`
+	Type           string        `json:"type"` // "POW" or "POS"
+	Reward         string        `json:"reward"`
+	Signature      string        `json:"signature,omitempty"` // validator's signature over Hash, POS only
+}
+
+// BlockHeader is a block without its transactions
+type BlockHeader struct {
+	Index          int64  `json:"index"`
+	Timestamp      int64  `json:"timestamp"`
+	PreviousHash   string `json:"previousHash"`
+	TxRoot         string `json:"transactionsRoot"`
+	ReceiptsRoot   string `json:"receiptsRoot"`
+	StateRoot      string `json:"stateRoot"`
+	ValidatorsRoot string `json:"validatorsRoot"`
+	Hash           string `json:"hash"`
+	Nonce          int64  `json:"nonce"`
+	Difficulty     int64  `json:"difficulty"`
+	Miner          string `json:"miner"`
+	Validator      string `json:"validator"`
+	Type           string `json:"type"`
+	Reward         string `json:"reward"`
+	Signature      string `json:"signature,omitempty"`
 }
 
 // Transaction structure
@@ -51,173 +94,320 @@ type Transaction struct {
 	Nonce     int64  `json:"nonce"`
 	Hash      string `json:"hash"`
 	Timestamp int64  `json:"timestamp"`
+	Signature string `json:"signature,omitempty"`
+	PublicKey string `json:"publicKey,omitempty"`
+	Type      string `json:"type,omitempty"` // "" for a transfer, or a staking operation
+	Data      string `json:"data,omitempty"` // evidence of an evidence transaction
+	ChainID   int64  `json:"chainId"`        // chain the signature is valid on
 }
 
 // Validator structure
 type Validator struct {
-	Address      string `json:"address"`
-	Stake        string `json:"stake"`
-	Active       bool   `json:"active"`
-	JoinedAt     int64  `json:"joinedAt"`
-	BlocksMinted int    `json:"blocksMinted"`
+	Address        string  `json:"address"`
+	PublicKey      string  `json:"publicKey"`      // key POS blocks are signed with
+	Stake          string  `json:"stake"`          // the validator's own stake
+	Delegated      string  `json:"delegated"`      // stake delegated to it
+	EffectiveStake string  `json:"effectiveStake"` // own plus delegated, used for proposer selection
+	State          string  `json:"state"`          // pending, active, jailed, exiting or exited
+	Active         bool    `json:"active"`         // in the proposer rotation
+	JoinedAt       int64   `json:"joinedAt"`
+	LockedUntil    int64   `json:"lockedUntil"`
+	BlocksMinted   int     `json:"blocksMinted"`
+	JailedUntil    int64   `json:"jailedUntil,omitempty"`
+	ExitAt         int64   `json:"exitAt,omitempty"`
+	MissedSlots    int     `json:"missedSlots"` // in a row
+	TotalMissed    int     `json:"totalMissed"`
+	Uptime         float64 `json:"uptime"` // percent of its slots it produced a block in
 }
 
 // Blockchain structure
 type Blockchain struct {
 	Blocks          []Block              `json:"blocks"`
-	PendingTxs      []Transaction        `json:"pendingTxs"`
 	Validators      map[string]Validator `json:"validators"`
 	TotalSupply     *big.Int             `json:"totalSupply"`
 	Config          ChainConfig          `json:"config"`
 	CurrentDiff     int64                `json:"currentDifficulty"`
 	LastPOWBlock    int64                `json:"lastPOWBlock"`
 	LastPOSBlock    int64                `json:"lastPOSBlock"`
+	State           *State               `json:"-"`
+	MaxReorgDepth   int64                `json:"-"`
+	Lite            bool                 `json:"-"` // headers only: no bodies, state or mempool
+	BlockSizeLimit  int64                `json:"-"` // size of blocks this node builds, 0 = MaxBlockSize
+	KeepBlocks      int64                `json:"-"` // newest blocks Prune leaves whole, 0 = never prune
 	mu              sync.RWMutex
+	store           Store
+	pool            *TxPool
+	listeners       []ChainListener
+	tree            map[string]*blockNode
+	sideBlocks      map[string]Block
+	undo            map[string]*blockUndo
+	undoPruned      int64                   // stored undo data below this height is deleted; -1 until the store is swept
+	prunedBelow     int64                   // blocks below this height have been pruned
+	receipts        map[string]Receipt      // transaction index: tx hash → receipt
+	history         map[string][]historyRef // address index: address → its transactions and rewards, oldest first
+	reorgs          []ReorgEvent
+	evidence        map[string]Evidence    // double signs seen, by the two block hashes
+	validatorSets   map[string][]Validator // validator sets not yet stored, by root
 }
 
 var blockchain *Blockchain
+var p2pServer *P2PServer
+var syncManager *SyncManager
 var nodeAddress = generateAddress()
 
+// nodeVersion and nodeLocation are what this node reports about itself
+var nodeVersion = defaultNodeVersion
+var nodeLocation *Location
+
+// serverSigningDisabled rejects /transaction/send requests that carry a private key
+var serverSigningDisabled bool
+
+// miningThreads is how many goroutines search for a PoW nonce
+var miningThreads = 1
+
+// signingKey signs the POS blocks this node mints; nil if it has none
+var signingKey *ecdsa.PrivateKey
+
+// validatorAddress is the validator this node mints for. It differs from the
+// signing key's address once the validator has rotated its key.
+var validatorAddress string
+
 func main() {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8545"
+	cfg, err := loadNodeConfig(os.Args[1:])
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	if err := setupLogging(cfg); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	serverSigningDisabled = cfg.RPC.DisableServerSigning
+	miningThreads = cfg.Mining.Threads
+	rpcMaxBatchSize = cfg.RPC.MaxBatchSize
+	rpcBatchConcurrency = cfg.RPC.BatchConcurrency
+	nodeVersion = cfg.Node.Version
+	nodeLocation = cfg.NodeLocation()
+	if cfg.Mining.RewardAddress != "" {
+		nodeAddress = cfg.Mining.RewardAddress
+	} else if wallet := cfg.WalletAddress(); wallet != "" {
+		nodeAddress = wallet
 	}
 
-	// Initialize blockchain
-	blockchain = initBlockchain()
+	genesis, err := LoadGenesis(genesisPath(cfg.Node.GenesisFile))
+	if err != nil {
+		log.Fatalf("❌ Failed to load genesis: %v", err)
+	}
+	
+	// Open storage and load (or create) the blockchain
+	store, err := OpenFileStore(cfg.Node.DataDir, int64(cfg.Sync.CacheSize)<<20)
+	if err != nil {
+		log.Fatalf("❌ Failed to open data dir %s: %v", cfg.Node.DataDir, err)
+	}
+	blockchain, err = openBlockchain(store, genesis, cfg.Node.Type == "lite")
+	if err != nil {
+		log.Fatalf("❌ Failed to load blockchain: %v", err)
+	}
+	blockchain.MaxReorgDepth = cfg.Consensus.MaxReorgDepth
+	blockchain.pool.config = cfg.MempoolConfig()
+	blockchain.BlockSizeLimit = cfg.Consensus.BlockSizeLimit
+	if cfg.Pruning.Enabled {
+		blockchain.KeepBlocks = cfg.Pruning.KeepRecentBlocks
+	}
+	blockchain.AddListener(eventBus)
+	go closeStoreOnSignal(store)
 	
-	log.Printf("🚀 GYDSchain Node Starting...")
+	log.Printf("🚀 GYDSchain Node %s Starting (%s node)...", nodeVersion, cfg.Node.Type)
 	log.Printf("📍 Node Address: %s", nodeAddress)
 	log.Printf("⛓️  Chain ID: %d", blockchain.Config.ChainID)
-	log.Printf("🌐 RPC Port: %s", port)
+	log.Printf("💾 Data Dir: %s (height %d)", cfg.Node.DataDir, len(blockchain.Blocks)-1)
+	log.Printf("🌐 RPC: %s", cfg.RPCAddr())
+	
+	// Start peer-to-peer networking and chain sync
+	p2pServer = NewP2PServer(blockchain, cfg.P2PConfig())
+	syncManager = NewSyncManager(blockchain, p2pServer, cfg.SyncConfig())
+	if err := p2pServer.Start(); err != nil {
+		log.Fatalf("❌ Failed to start P2P server: %v", err)
+	}
+	log.Printf("🔗 P2P listening on %s (node %s)", p2pServer.ListenAddr(), shortID(p2pServer.NodeID()))
+	go syncManager.Run()
+	if cfg.Pruning.Enabled {
+		go pruneLoop(time.Duration(cfg.Pruning.PruneInterval) * time.Second)
+	}
 	
 	// Start mining/validation routines
-	go miningLoop()
-	go validationLoop()
+	interval := time.Duration(blockchain.Config.BlockTime) * time.Second
+	if cfg.Consensus.BlockTime > 0 {
+		interval = time.Duration(cfg.Consensus.BlockTime) * time.Second
+	}
+	if cfg.Mining.Enabled {
+		log.Printf("⛏️  Mining with %d thread(s)", miningThreads)
+		go miningLoop(interval)
+	}
+	if cfg.Consensus.Participate {
+		if err := loadSigningKey(cfg.Security.PrivateKeyFile, cfg.Consensus.ValidatorAddress); err != nil {
+			log.Fatalf("❌ Failed to load validator key: %v", err)
+		}
+		if signingKey != nil {
+			go validationLoop(interval)
+		}
+	}
+	if cfg.Metrics.Enabled {
+		startMetricsServer(cfg)
+	}
 	
 	// Setup HTTP handlers
 	http.HandleFunc("/", handleHome)
-	http.HandleFunc("/rpc", handleRPC)
+	if cfg.RPC.Enabled {
+		http.HandleFunc("/rpc", handleRPC)
+		http.HandleFunc("/ws", handleWebSocket(cfg.RPC.CORSOrigins, cfg.RPC.MaxRequestSize))
+	}
 	http.HandleFunc("/blocks", handleBlocks)
 	http.HandleFunc("/block/", handleBlock)
 	http.HandleFunc("/transactions", handleTransactions)
 	http.HandleFunc("/validators", handleValidators)
-	http.HandleFunc("/stake", handleStake)
+	http.HandleFunc("/validators/", handleValidator)
 	http.HandleFunc("/stats", handleStats)
+	http.HandleFunc("/peers", handlePeers)
+	http.HandleFunc("/reorgs", handleReorgs)
+	http.HandleFunc("/evidence", handleEvidence)
 	http.HandleFunc("/health", handleHealth)
 	http.HandleFunc("/wallet/create", handleCreateWallet)
 	http.HandleFunc("/wallet/recover", handleRecoverWallet)
+	http.HandleFunc("/transaction/", handleTransaction)
+	http.HandleFunc("/address/", handleAddress)
+	http.HandleFunc("/proof/tx/", handleTxProof)
 	http.HandleFunc("/transaction/send", handleSendTransaction)
+	http.HandleFunc("/transaction/raw", handleSendRawTransaction)
 	http.HandleFunc("/transaction/fee", handleCalculateFee)
 	
-	log.Printf("✅ Node ready on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, enableCORS(http.DefaultServeMux)))
+	handler := limitRequestSize(cfg.RPC.MaxRequestSize, enableCORS(cfg.RPC.CORSOrigins, http.DefaultServeMux))
+	log.Printf("✅ Node ready on %s", cfg.RPCAddr())
+	if cfg.Security.TLSEnabled {
+		log.Fatal(http.ListenAndServeTLS(cfg.RPCAddr(), cfg.Security.TLSCert, cfg.Security.TLSKey, handler))
+	}
+	log.Fatal(http.ListenAndServe(cfg.RPCAddr(), handler))
 }
 
-func initBlockchain() *Blockchain {
-	maxSupply := new(big.Int)
-	maxSupply.SetString("100000000000000000000000000", 10)
+// initBlockchain creates a chain holding only the genesis block described by
+// genesis, with its pre-allocated balances and initial validators
+func initBlockchain(genesis *Genesis) *Blockchain {
+	block := genesis.ToBlock()
+	difficulty, _ := genesis.initialDifficulty()
 	
-	genesis := Block{
-		Index:        0,
-		Timestamp:    time.Now().Unix(),
-		Transactions: []Transaction{},
-		PreviousHash: "0",
-		Difficulty:   0x20000,
-		Miner:        "genesis",
-		Type:         "GENESIS",
-		Reward:       "0",
-	}
-	genesis.Hash = calculateHash(genesis)
+	bc := &Blockchain{
+		Blocks:        []Block{block},
+		TotalSupply:   big.NewInt(0),
+		Config:        genesis.ChainConfig(),
+		CurrentDiff:   difficulty,
+		LastPOWBlock:  0,
+		LastPOSBlock:  0,
+		State:         genesis.allocState(),
+		MaxReorgDepth: DefaultMaxReorgDepth,
+		pool:          NewTxPool(DefaultMempoolConfig()),
+		tree:          make(map[string]*blockNode),
+		sideBlocks:    make(map[string]Block),
+		undo:          make(map[string]*blockUndo),
+		receipts:      make(map[string]Receipt),
+		history:       make(map[string][]historyRef),
+		evidence:      make(map[string]Evidence),
+		validatorSets: make(map[string][]Validator),
+	}
+	
+	for _, acct := range genesis.Alloc {
+		balance, _ := parseAmount(acct.Balance)
+		bc.TotalSupply.Add(bc.TotalSupply, balance)
+	}
+	for _, val := range genesis.Validators {
+		stake, _ := parseAmount(val.Stake)
+		bc.TotalSupply.Add(bc.TotalSupply, stake)
+	}
+	bc.updateValidators()
 	
-	return &Blockchain{
-		Blocks:      []Block{genesis},
-		PendingTxs:  []Transaction{},
-		Validators:  make(map[string]Validator),
-		TotalSupply: big.NewInt(0),
-		Config: ChainConfig{
-			ChainID:     9125,
-			NetworkID:   9125,
-			ChainName:   "GYDSchain",
-			MaxSupply:   "100000000000000000000000000",
-			BlockTime:   120,
-			POWEnabled:  true,
-			POSEnabled:  true,
-			BlockReward: "3000000000000000000",
-			StakeReward: "1000000000000000000",
-		},
-		CurrentDiff:  0x20000,
-		LastPOWBlock: 0,
-		LastPOSBlock: 0,
-	}
-}
-
-func miningLoop() {
+	bc.addTreeNode(&block)
+	return bc
+}
+
+// closeStoreOnSignal flushes and closes storage before the process exits
+func closeStoreOnSignal(store Store) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+
+	blockchain.mu.Lock()
+	if err := store.Close(); err != nil {
+		log.Printf("⚠️  Failed to close store: %v", err)
+	}
+	log.Printf("👋 Node stopped")
+	os.Exit(0)
+}
+
+func miningLoop(interval time.Duration) {
 	for {
 		if blockchain.Config.POWEnabled {
-			time.Sleep(time.Duration(blockchain.Config.BlockTime) * time.Second)
-			minePOWBlock()
+			time.Sleep(interval)
+			// Don't build on a stale head while catching up with peers
+			if !syncManager.Syncing() {
+				minePOWBlock()
+			}
 		}
 	}
 }
 
-func validationLoop() {
+func validationLoop(interval time.Duration) {
 	for {
 		if blockchain.Config.POSEnabled && len(blockchain.Validators) > 0 {
-			time.Sleep(time.Duration(blockchain.Config.BlockTime) * time.Second)
-			mintPOSBlock()
+			time.Sleep(interval)
+			if !syncManager.Syncing() {
+				mintPOSBlock()
+			}
 		}
 	}
 }
 
 func minePOWBlock() {
 	blockchain.mu.Lock()
-	defer blockchain.mu.Unlock()
 	
 	// Check max supply
 	maxSupply := new(big.Int)
 	maxSupply.SetString(blockchain.Config.MaxSupply, 10)
 	if blockchain.TotalSupply.Cmp(maxSupply) >= 0 {
+		blockchain.mu.Unlock()
 		return
 	}
 	
 	lastBlock := blockchain.Blocks[len(blockchain.Blocks)-1]
-	newBlock := Block{
+	newBlock := blockchain.buildBlock(Block{
 		Index:        lastBlock.Index + 1,
-		Timestamp:    time.Now().Unix(),
-		Transactions: blockchain.PendingTxs,
+		Timestamp:    nextBlockTimestamp(lastBlock),
 		PreviousHash: lastBlock.Hash,
 		Difficulty:   blockchain.CurrentDiff,
 		Miner:        nodeAddress,
 		Type:         "POW",
 		Reward:       blockchain.Config.BlockReward,
-	}
+	})
+	blockchain.mu.Unlock()
 	
-	// Simple POW - find nonce that creates hash with leading zeros
-	for {
-		newBlock.Hash = calculateHash(newBlock)
-		if isValidPOW(newBlock.Hash, blockchain.CurrentDiff) {
-			break
-		}
-		newBlock.Nonce++
+	// Simple POW - find nonce that creates hash with leading zeros.
+	// The chain is unlocked while searching so peers' blocks can still arrive;
+	// give up if the head moves on.
+	newBlock, found := searchNonce(newBlock, miningThreads, func() bool {
+		return blockchain.headHash() != lastBlock.Hash
+	})
+	if !found {
+		return
 	}
 	
-	blockchain.Blocks = append(blockchain.Blocks, newBlock)
-	blockchain.PendingTxs = []Transaction{}
-	blockchain.LastPOWBlock = newBlock.Index
-	
-	// Update supply
-	reward := new(big.Int)
-	reward.SetString(blockchain.Config.BlockReward, 10)
-	blockchain.TotalSupply.Add(blockchain.TotalSupply, reward)
+	blockchain.mu.Lock()
+	defer blockchain.mu.Unlock()
+	if blockchain.Blocks[len(blockchain.Blocks)-1].Hash != lastBlock.Hash {
+		return
+	}
 	
-	// Adjust difficulty every 10 blocks
-	if newBlock.Index%10 == 0 {
-		adjustDifficulty()
+	if err := blockchain.insertBlock(newBlock); err != nil {
+		log.Printf("⚠️  Failed to insert block #%d: %v", newBlock.Index, err)
+		return
 	}
 	
-	log.Printf("⛏️  POW Block #%d mined by %s", newBlock.Index, nodeAddress[:8])
+	log.Printf("⛏️  POW Block #%d mined by %s (%d txs)", newBlock.Index, nodeAddress[:8], len(newBlock.Transactions))
 }
 
 func mintPOSBlock() {
@@ -231,85 +421,132 @@ func mintPOSBlock() {
 		return
 	}
 	
-	// Select validator (simplified random)
-	var selectedValidator string
-	for addr, val := range blockchain.Validators {
-		if val.Active {
-			selectedValidator = addr
-			break
-		}
+	// Every node agrees on who produces the next block; only mint in our slots
+	lastBlock := blockchain.Blocks[len(blockchain.Blocks)-1]
+	timestamp := nextBlockTimestamp(lastBlock)
+	round := slotRound(timestamp, &lastBlock, blockchain.Config.SlotTimeout)
+	selectedValidator := expectedProposer(&lastBlock, blockchain.Validators, round)
+	if selectedValidator == "" || !addressesEqual(selectedValidator, validatorAddress) {
+		return
 	}
-	
-	if selectedValidator == "" {
+	if blockchain.Validators[selectedValidator].PublicKey != EncodePublicKey(&signingKey.PublicKey) {
+		log.Printf("⚠️  Signing key is not the registered key of validator %s, not minting", selectedValidator)
 		return
 	}
 	
-	lastBlock := blockchain.Blocks[len(blockchain.Blocks)-1]
-	newBlock := Block{
+	newBlock := blockchain.buildBlock(Block{
 		Index:        lastBlock.Index + 1,
-		Timestamp:    time.Now().Unix(),
-		Transactions: blockchain.PendingTxs,
+		Timestamp:    timestamp,
 		PreviousHash: lastBlock.Hash,
 		Validator:    selectedValidator,
 		Type:         "POS",
 		Reward:       blockchain.Config.StakeReward,
-	}
+	})
 	newBlock.Hash = calculateHash(newBlock)
-	
-	blockchain.Blocks = append(blockchain.Blocks, newBlock)
-	blockchain.PendingTxs = []Transaction{}
-	blockchain.LastPOSBlock = newBlock.Index
-	
-	// Update validator stats
-	if val, ok := blockchain.Validators[selectedValidator]; ok {
-		val.BlocksMinted++
-		blockchain.Validators[selectedValidator] = val
+	if err := signBlock(&newBlock, signingKey); err != nil {
+		log.Printf("⚠️  Failed to sign block #%d: %v", newBlock.Index, err)
+		return
 	}
 	
-	// Update supply
-	reward := new(big.Int)
-	reward.SetString(blockchain.Config.StakeReward, 10)
-	blockchain.TotalSupply.Add(blockchain.TotalSupply, reward)
+	if err := blockchain.insertBlock(newBlock); err != nil {
+		log.Printf("⚠️  Failed to insert block #%d: %v", newBlock.Index, err)
+		return
+	}
 	
-	log.Printf("🗳️  POS Block #%d minted by validator %s", newBlock.Index, selectedValidator[:8])
+	log.Printf("🗳️  POS Block #%d minted by validator %s (%d txs)", newBlock.Index, selectedValidator[:8], len(newBlock.Transactions))
 }
 
+// calculateHash hashes the block header, which commits to the transactions
+// and receipts through their Merkle roots
 func calculateHash(block Block) string {
-	record := fmt.Sprintf("%d%d%s%d%s%s%d", 
-		block.Index, block.Timestamp, block.PreviousHash, 
-		block.Nonce, block.Miner, block.Validator, block.Difficulty)
-	h := sha256.New()
-	h.Write([]byte(record))
-	return hex.EncodeToString(h.Sum(nil))
+	sum := sha256.Sum256(encodeHeader(&block))
+	return hex.EncodeToString(sum[:])
+}
+
+// searchNonce looks for a nonce satisfying the block's difficulty using
+// threads goroutines that each try every threads-th nonce. It gives up when
+// stale reports true, which is checked every 100000 nonces.
+func searchNonce(block Block, threads int, stale func() bool) (Block, bool) {
+	if threads < 1 {
+		threads = 1
+	}
+	results := make(chan Block, threads)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func(candidate Block, offset int) {
+			defer wg.Done()
+			candidate.Nonce += int64(offset)
+			for tries := 1; ; tries++ {
+				candidate.Hash = calculateHash(candidate)
+				if isValidPOW(candidate.Hash, candidate.Difficulty) {
+					results <- candidate
+					return
+				}
+				candidate.Nonce += int64(threads)
+				if tries%100000 == 0 {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					if stale() {
+						return
+					}
+				}
+			}
+		}(block, i)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	found, ok := <-results
+	close(done)
+	return found, ok
 }
 
 func isValidPOW(hash string, difficulty int64) bool {
 	// Simplified: check if hash is less than difficulty target
+	if checkDifficulty(difficulty) != nil {
+		return false
+	}
 	hashInt := new(big.Int)
 	hashInt.SetString(hash, 16)
 	target := new(big.Int).Lsh(big.NewInt(1), uint(256-difficulty/0x1000))
 	return hashInt.Cmp(target) < 0
 }
 
-func adjustDifficulty() {
+func (bc *Blockchain) adjustDifficulty() {
 	// Bitcoin-style difficulty adjustment
-	if len(blockchain.Blocks) < 10 {
+	if len(bc.Blocks) < 10 {
 		return
 	}
 	
-	expectedTime := int64(blockchain.Config.BlockTime * 10)
-	actualTime := blockchain.Blocks[len(blockchain.Blocks)-1].Timestamp - 
-		blockchain.Blocks[len(blockchain.Blocks)-10].Timestamp
-	
+	actualTime := bc.Blocks[len(bc.Blocks)-1].Timestamp - 
+		bc.Blocks[len(bc.Blocks)-10].Timestamp
+	bc.CurrentDiff = bc.retarget(bc.CurrentDiff, actualTime)
+}
+
+// retarget returns the difficulty following current once the last ten
+// blocks took actualTime seconds
+func (bc *Blockchain) retarget(current, actualTime int64) int64 {
+	expectedTime := int64(bc.Config.BlockTime * 10)
 	if actualTime < expectedTime/2 {
-		blockchain.CurrentDiff = blockchain.CurrentDiff * 2
+		current = current * 2
 	} else if actualTime > expectedTime*2 {
-		blockchain.CurrentDiff = blockchain.CurrentDiff / 2
+		current = current / 2
 	}
 	
-	if blockchain.CurrentDiff < 0x10000 {
-		blockchain.CurrentDiff = 0x10000
+	if current < 0x10000 {
+		current = 0x10000
+	}
+	if current > maxDifficulty {
+		current = maxDifficulty
 	}
+	return current
 }
 
 func generateAddress() string {
@@ -320,30 +557,113 @@ func generateAddress() string {
 
 // HTTP Handlers
 func handleHome(w http.ResponseWriter, r *http.Request) {
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	home := map[string]interface{}{
 		"chain":    "GYDSchain",
-		"version":  "1.0.0",
+		"version":  nodeVersion,
 		"node":     nodeAddress,
 		"status":   "running",
 		"chainId":  blockchain.Config.ChainID,
-	})
+	}
+	if nodeLocation != nil {
+		home["location"] = nodeLocation
+	}
+	json.NewEncoder(w).Encode(home)
 }
 
+// Page size limits for /blocks
+const (
+	defaultBlocksPageSize = 20
+	maxBlocksPageSize     = 100
+)
+
+// handleBlocks lists canonical blocks in ascending order. ?from= is the first
+// height (default "latest", meaning the newest page) and ?limit= the page size.
 func handleBlocks(w http.ResponseWriter, r *http.Request) {
-	blockchain.mu.RLock()
-	defer blockchain.mu.RUnlock()
-	json.NewEncoder(w).Encode(blockchain.Blocks)
+	query := r.URL.Query()
+	limit := defaultBlocksPageSize
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	if limit > maxBlocksPageSize {
+		limit = maxBlocksPageSize
+	}
+
+	height := blockchain.Height()
+	from := height - int64(limit) + 1
+	if v := query.Get("from"); v != "" && v != "latest" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, "from must be a block number or latest", http.StatusBadRequest)
+			return
+		}
+		from = n
+	}
+	if from < 0 {
+		from = 0
+	}
+
+	json.NewEncoder(w).Encode(blockchain.CanonicalBlocks(from, limit))
 }
 
+// handleBlock serves /block/{number}, /block/{hash} and /block/latest
 func handleBlock(w http.ResponseWriter, r *http.Request) {
-	// Implementation for specific block
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	id := strings.TrimPrefix(r.URL.Path, "/block/")
+
+	var block Block
+	var found bool
+	switch {
+	case id == "latest":
+		block, found = blockchain.BlockByNumber(blockchain.Height())
+	case isBlockNumber(id):
+		n, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid block number", http.StatusBadRequest)
+			return
+		}
+		block, found = blockchain.BlockByNumber(n)
+	default:
+		block, found = blockchain.BlockByHash(strings.TrimPrefix(strings.ToLower(id), "0x"))
+	}
+	if !found {
+		http.Error(w, "Block not found", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(block)
 }
 
+// isBlockNumber reports whether id is a decimal height rather than a hash
+func isBlockNumber(id string) bool {
+	if id == "" || len(id) > 20 {
+		return false
+	}
+	for _, c := range id {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// handleTransactions lists pending transactions: executable ones in block
+// inclusion order, then future ones. ?status=executable or ?status=future
+// returns a single group.
 func handleTransactions(w http.ResponseWriter, r *http.Request) {
-	blockchain.mu.RLock()
-	defer blockchain.mu.RUnlock()
-	json.NewEncoder(w).Encode(blockchain.PendingTxs)
+	executable, future := blockchain.PendingTransactions()
+	switch r.URL.Query().Get("status") {
+	case "":
+		json.NewEncoder(w).Encode(append(executable, future...))
+	case "executable":
+		json.NewEncoder(w).Encode(executable)
+	case "future":
+		json.NewEncoder(w).Encode(future)
+	default:
+		http.Error(w, "status must be executable or future", http.StatusBadRequest)
+	}
 }
 
 func handleValidators(w http.ResponseWriter, r *http.Request) {
@@ -352,40 +672,29 @@ func handleValidators(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(blockchain.Validators)
 }
 
-func handleStake(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-	
-	var req struct {
-		Address string `json:"address"`
-		Amount  string `json:"amount"`
-	}
-	
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+// handleValidator serves /validators/{address}: a validator, including one
+// that has exited, and the delegations made to it
+func handleValidator(w http.ResponseWriter, r *http.Request) {
+	address := normalizeAddress(strings.TrimPrefix(r.URL.Path, "/validators/"))
+	if err := ValidateAddress(address); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	
-	blockchain.mu.Lock()
-	defer blockchain.mu.Unlock()
-	
-	// Check if validator slots available
-	if len(blockchain.Validators) >= 21 {
-		http.Error(w, "Validator slots full", http.StatusBadRequest)
+	if blockchain.Lite {
+		http.Error(w, "Delegations are not available on lite nodes", http.StatusNotImplemented)
 		return
 	}
-	
-	blockchain.Validators[req.Address] = Validator{
-		Address:      req.Address,
-		Stake:        req.Amount,
-		Active:       true,
-		JoinedAt:     time.Now().Unix(),
-		BlocksMinted: 0,
+
+	val, ok := blockchain.Validator(address)
+	if !ok {
+		http.Error(w, "Validator not found", http.StatusNotFound)
+		return
 	}
-	
-	json.NewEncoder(w).Encode(map[string]string{"status": "staked"})
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"validator":   val,
+		"commission":  blockchain.Config.Commission,
+		"delegations": blockchain.Delegators(address),
+	})
 }
 
 func handleStats(w http.ResponseWriter, r *http.Request) {
@@ -395,58 +704,41 @@ func handleStats(w http.ResponseWriter, r *http.Request) {
 	maxSupply := new(big.Int)
 	maxSupply.SetString(blockchain.Config.MaxSupply, 10)
 	
+	progress, syncing := syncManager.Progress()
+	
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"blockHeight":    len(blockchain.Blocks) - 1,
 		"totalSupply":    blockchain.TotalSupply.String(),
 		"maxSupply":      blockchain.Config.MaxSupply,
 		"validators":     len(blockchain.Validators),
-		"pendingTxs":     len(blockchain.PendingTxs),
+		"pendingTxs":     blockchain.pool.Len(),
 		"difficulty":     blockchain.CurrentDiff,
 		"lastPOWBlock":   blockchain.LastPOWBlock,
 		"lastPOSBlock":   blockchain.LastPOSBlock,
+		"nextProposer":   blockchain.nextProposer(time.Now().Unix()),
 		"nodeAddress":    nodeAddress,
+		"peers":          p2pServer.PeerCount(),
+		"syncing":        syncing,
+		"syncProgress":   progress,
 	})
 }
 
-func handleHealth(w http.ResponseWriter, r *http.Request) {
-	json.NewEncoder(w).Encode(map[string]string{"status": "healthy"})
+func handlePeers(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(p2pServer.Peers())
 }
 
-func handleRPC(w http.ResponseWriter, r *http.Request) {
-	// JSON-RPC 2.0 handler
-	var req struct {
-		JSONRPC string        `json:"jsonrpc"`
-		Method  string        `json:"method"`
-		Params  []interface{} `json:"params"`
-		ID      interface{}   `json:"id"`
-	}
-	
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-	
-	// Handle different RPC methods
-	response := map[string]interface{}{
-		"jsonrpc": "2.0",
-		"id":      req.ID,
-	}
-	
-	switch req.Method {
-	case "eth_chainId":
-		response["result"] = fmt.Sprintf("0x%x", blockchain.Config.ChainID)
-	case "eth_blockNumber":
-		response["result"] = fmt.Sprintf("0x%x", len(blockchain.Blocks)-1)
-	case "net_version":
-		response["result"] = fmt.Sprintf("%d", blockchain.Config.NetworkID)
-	default:
-		response["error"] = map[string]interface{}{
-			"code":    -32601,
-			"message": "Method not found",
-		}
-	}
-	
-	json.NewEncoder(w).Encode(response)
+func handleReorgs(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(blockchain.RecentReorgs())
+}
+
+// handleEvidence lists the double signs this node has seen. Each entry's
+// JSON is the data of an evidence transaction against its validator.
+func handleEvidence(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(blockchain.DetectedEvidence())
+}
+
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{"status": "healthy"})
 }
 
 func handleCreateWallet(w http.ResponseWriter, r *http.Request) {
@@ -509,27 +801,179 @@ func handleSendTransaction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate transaction
-	if err := ValidateTransaction(&req.Transaction); err != nil {
+	// Legacy server-side signing; prefer signing locally and using /transaction/raw
+	if req.PrivateKey != "" {
+		if serverSigningDisabled {
+			http.Error(w, "Server-side signing is disabled, submit a signed transaction to /transaction/raw", http.StatusForbidden)
+			return
+		}
+		if req.Transaction.ChainID == 0 {
+			req.Transaction.ChainID = blockchain.Config.ChainID
+		}
+		if err := SignTransaction(&req.Transaction, req.PrivateKey); err != nil {
+			http.Error(w, "Failed to sign transaction: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := blockchain.AddTransaction(req.Transaction); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Sign transaction
-	if err := SignTransaction(&req.Transaction, req.PrivateKey); err != nil {
-		http.Error(w, "Failed to sign transaction", http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "pending",
+		"hash":   req.Transaction.Hash,
+	})
+}
+
+// handleTransaction serves /transaction/{hash}: a pending transaction, or a
+// mined one with its receipt
+func handleTransaction(w http.ResponseWriter, r *http.Request) {
+	hash := strings.TrimPrefix(strings.ToLower(strings.TrimPrefix(r.URL.Path, "/transaction/")), "0x")
+	lookup, receipt, ok, err := lookupTransaction(hash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if !ok {
+		http.Error(w, "Transaction not found", http.StatusNotFound)
 		return
 	}
 
-	// Add to pending transactions
-	blockchain.mu.Lock()
-	req.Transaction.Timestamp = time.Now().Unix()
-	blockchain.PendingTxs = append(blockchain.PendingTxs, req.Transaction)
-	blockchain.mu.Unlock()
+	resp := map[string]interface{}{
+		"transaction": lookup.Tx,
+		"status":      "pending",
+	}
+	if receipt != nil {
+		resp["status"] = "confirmed"
+		if receipt.Status == ReceiptFailed {
+			resp["status"] = "failed"
+		}
+		resp["receipt"] = receipt
+		resp["confirmations"] = blockchain.Height() - receipt.BlockNumber + 1
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleTxProof serves /proof/tx/{hash}: the Merkle inclusion proof of a
+// mined transaction
+func handleTxProof(w http.ResponseWriter, r *http.Request) {
+	hash := strings.TrimPrefix(strings.ToLower(strings.TrimPrefix(r.URL.Path, "/proof/tx/")), "0x")
+	proof, ok, err := lookupTxProof(hash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if !ok {
+		http.Error(w, "Transaction not mined", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(proof)
+}
+
+// handleAddress serves /address/{addr} and /address/{addr}/transactions
+func handleAddress(w http.ResponseWriter, r *http.Request) {
+	address, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/address/"), "/")
+	if err := ValidateAddress(address); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Lite nodes only know what a full peer can prove about the account
+	if blockchain.Lite {
+		if sub != "" {
+			http.Error(w, "Address history is not available on lite nodes", http.StatusNotImplemented)
+			return
+		}
+		acct, err := lookupAccount(address)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		staked := "0"
+		if acct.Validator != nil {
+			staked = acct.Validator.Stake.String()
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"address":   address,
+			"balance":   acct.Balance.String(),
+			"nonce":     acct.Nonce,
+			"staked":    staked,
+			"unbonding": unbondingTotal(&acct).String(),
+		})
+		return
+	}
+
+	switch sub {
+	case "":
+		json.NewEncoder(w).Encode(blockchain.AddressSummary(address))
+	case "transactions":
+		limit := defaultHistoryPageSize
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		if limit > maxHistoryPageSize {
+			limit = maxHistoryPageSize
+		}
+
+		entries, next, err := blockchain.AddressHistory(address, r.URL.Query().Get("cursor"), limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp := map[string]interface{}{
+			"address":      address,
+			"transactions": entries,
+		}
+		if next != "" {
+			resp["nextCursor"] = next
+		}
+		json.NewEncoder(w).Encode(resp)
+	case "delegations":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"address":     address,
+			"delegations": blockchain.Delegations(address),
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func handleSendRawTransaction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Raw string `json:"raw"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := DecodeRawTransaction(req.Raw)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := blockchain.AddTransaction(*tx); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"status": "pending",
-		"hash":   req.Transaction.Hash,
+		"hash":   tx.Hash,
 	})
 }
 
@@ -563,9 +1007,18 @@ func handleCalculateFee(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func enableCORS(next http.Handler) http.Handler {
+func enableCORS(origins []string, next http.Handler) http.Handler {
+	allowed := make(map[string]bool)
+	for _, origin := range origins {
+		allowed[origin] = true
+	}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+		if allowed["*"] {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else if origin := r.Header.Get("Origin"); allowed[origin] {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+		}
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 		
@@ -578,3 +1031,11 @@ func enableCORS(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// limitRequestSize rejects request bodies larger than maxBytes
+func limitRequestSize(maxBytes int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gydschain/client"
+)
+
+// get serves a GET request for path with handler and decodes a successful
+// JSON response into v
+func get(t *testing.T, handler http.HandlerFunc, path string, v interface{}) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("GET", path, nil))
+	if rec.Code == http.StatusOK && v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+	}
+	return rec.Code
+}
+
+// blockIndexes returns the heights of blocks
+func blockIndexes(blocks []Block) []int64 {
+	indexes := make([]int64, len(blocks))
+	for i, block := range blocks {
+		indexes[i] = block.Index
+	}
+	return indexes
+}
+
+func TestBlocksPagination(t *testing.T) {
+	bc := testChain(t, testGenesis(t))
+	mineBlocks(t, bc, "0x1111111111111111111111111111111111111111", 5)
+	blockchain = bc
+
+	for path, want := range map[string][]int64{
+		"/blocks":                     {0, 1, 2, 3, 4, 5},
+		"/blocks?limit=2":             {4, 5},
+		"/blocks?from=latest&limit=2": {4, 5},
+		"/blocks?from=1&limit=2":      {1, 2},
+		"/blocks?from=4&limit=10":     {4, 5},
+		"/blocks?from=9":              {},
+	} {
+		var blocks []Block
+		if code := get(t, handleBlocks, path, &blocks); code != http.StatusOK {
+			t.Fatalf("%s: status %d", path, code)
+		}
+		if got := blockIndexes(blocks); len(got) != len(want) || (len(got) > 0 && (got[0] != want[0] || got[len(got)-1] != want[len(want)-1])) {
+			t.Errorf("%s = blocks %v, want %v", path, got, want)
+		}
+	}
+	for _, path := range []string{"/blocks?limit=0", "/blocks?limit=ten", "/blocks?from=-1", "/blocks?from=0x1"} {
+		if code := get(t, handleBlocks, path, nil); code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d", path, code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestBlockLookup(t *testing.T) {
+	bc := testChain(t, testGenesis(t))
+	mineBlocks(t, bc, "0x1111111111111111111111111111111111111111", 3)
+	blockchain = bc
+
+	for path, want := range map[string]int64{
+		"/block/latest":               3,
+		"/block/0":                    0,
+		"/block/2":                    2,
+		"/block/" + bc.Blocks[1].Hash: 1,
+		"/block/0x" + strings.ToUpper(bc.Blocks[1].Hash): 1,
+	} {
+		var block Block
+		if code := get(t, handleBlock, path, &block); code != http.StatusOK || block.Hash != bc.Blocks[want].Hash {
+			t.Errorf("%s: status %d, block #%d, want #%d", path, code, block.Index, want)
+		}
+	}
+	for _, path := range []string{"/block/4", "/block/" + strings.Repeat("ab", 32), "/block/"} {
+		if code := get(t, handleBlock, path, nil); code != http.StatusNotFound {
+			t.Errorf("%s: status %d, want %d", path, code, http.StatusNotFound)
+		}
+	}
+}
+
+// txStatus is the body of a /transaction/{hash} response
+type txStatus struct {
+	Transaction   Transaction `json:"transaction"`
+	Status        string      `json:"status"`
+	Receipt       *Receipt    `json:"receipt"`
+	Confirmations int64       `json:"confirmations"`
+}
+
+func TestTransactionLookup(t *testing.T) {
+	bc, _ := minedTransfers(t, 2)
+	mined := bc.Blocks[1].Transactions[1]
+	mineBlocks(t, bc, "0x1111111111111111111111111111111111111111", 2)
+	blockchain = bc
+
+	var status txStatus
+	if code := get(t, handleTransaction, "/transaction/0x"+mined.Hash, &status); code != http.StatusOK {
+		t.Fatalf("mined transaction: status %d", code)
+	}
+	receipt := status.Receipt
+	if status.Status != "confirmed" || receipt == nil || status.Confirmations != 3 {
+		t.Fatalf("mined transaction: %+v", status)
+	}
+	if receipt.BlockHash != bc.Blocks[1].Hash || receipt.Index != 1 || receipt.Status != ReceiptSuccess || receipt.GasUsed != client.TransferGas {
+		t.Fatalf("receipt %+v", receipt)
+	}
+
+	if code := get(t, handleTransaction, "/transaction/"+strings.Repeat("ab", 32), nil); code != http.StatusNotFound {
+		t.Fatalf("unknown transaction: status %d", code)
+	}
+}
+
+func TestReorgRemovesTransactionIndex(t *testing.T) {
+	key, sender := testKey(t)
+	genesis := testGenesis(t)
+	genesis.Alloc = map[string]GenesisAccount{sender: {Balance: "10000000000000000000"}}
+	chain := testChain(t, genesis)
+	tx := signedTx(t, client.NewTransfer(sender, "0x3333333333333333333333333333333333333333", big.NewInt(5), 0), key)
+	if err := chain.AddTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, chain, "0x1111111111111111111111111111111111111111", 1)
+	if _, ok := chain.Receipt(tx.Hash); !ok {
+		t.Fatal("mined transaction not indexed")
+	}
+
+	other := testChain(t, genesis)
+	mineBlocks(t, other, "0x2222222222222222222222222222222222222222", 2)
+	for _, block := range other.Blocks[1:] {
+		if err := chain.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, ok := chain.Receipt(tx.Hash); ok {
+		t.Fatal("receipt of a transaction in a dropped block still indexed")
+	}
+	if entries, _, _ := chain.AddressHistory(sender, "", 10); len(entries) != 0 {
+		t.Fatalf("sender history holds %d entries from the dropped block", len(entries))
+	}
+	blockchain = chain
+	var status txStatus
+	if get(t, handleTransaction, "/transaction/"+tx.Hash, &status); status.Status != "pending" || status.Receipt != nil {
+		t.Fatalf("dropped transaction: %+v", status)
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+)
+
+// Errors returned when a transaction is refused by the pool
+var (
+	ErrAlreadyKnown       = errors.New("transaction already pending")
+	ErrReplaceUnderpriced = errors.New("replacement transaction underpriced")
+	ErrPoolFull           = errors.New("transaction pool is full")
+	ErrSenderLimit        = errors.New("too many pending transactions from sender")
+)
+
+// MempoolConfig limits the pending transaction pool
+type MempoolConfig struct {
+	MaxSize      int           // transactions held in total
+	MaxPerSender int           // transactions held per sender, also the furthest nonce gap accepted
+	Lifetime     time.Duration // how long a transaction may wait before it is dropped
+	PriceBump    int           // percent a replacement must raise the effective gas price by
+}
+
+func DefaultMempoolConfig() MempoolConfig {
+	return MempoolConfig{
+		MaxSize:      5000,
+		MaxPerSender: 64,
+		Lifetime:     3 * time.Hour,
+		PriceBump:    10,
+	}
+}
+
+// poolTx is a pending transaction with its precomputed priority
+type poolTx struct {
+	Tx    Transaction
+	price *big.Int  // effective gas price: total fee, base fee included, per unit of gas
+	added time.Time // when the transaction entered the pool; its own Timestamp is the sender's to choose
+}
+
+// TxPool holds pending transactions indexed by hash and by sender nonce.
+// Executable transactions continue their sender's account nonce without gaps;
+// the rest are future transactions waiting for the gap to fill. TxPool is not
+// safe for concurrent use; the chain guards it with bc.mu.
+type TxPool struct {
+	config  MempoolConfig
+	all     map[string]*poolTx
+	senders map[string]map[int64]*poolTx // normalized sender → nonce → tx
+}
+
+func NewTxPool(config MempoolConfig) *TxPool {
+	return &TxPool{
+		config:  config,
+		all:     make(map[string]*poolTx),
+		senders: make(map[string]map[int64]*poolTx),
+	}
+}
+
+// effectiveGasPrice is the fee a transaction pays per unit of gas
+func effectiveGasPrice(tx *Transaction) *big.Int {
+	fee, err := CalculateTransactionFee(tx.Gas, tx.GasPrice)
+	if err != nil || tx.Gas <= 0 {
+		return big.NewInt(0)
+	}
+	return fee.Div(fee, big.NewInt(tx.Gas))
+}
+
+// Add inserts a transaction whose nonce is not below the sender's account
+// nonce. A transaction with the same sender and nonce as a pending one
+// replaces it if it pays PriceBump percent more; the replaced transaction is
+// returned. When the pool is full the cheapest transaction is evicted,
+// future ones first, or tx is refused if it is the cheapest.
+func (p *TxPool) Add(tx Transaction, state *State) (replaced *Transaction, err error) {
+	return p.add(tx, state, time.Now())
+}
+
+// add inserts a transaction that entered the pool at added
+func (p *TxPool) add(tx Transaction, state *State, added time.Time) (replaced *Transaction, err error) {
+	if _, ok := p.all[tx.Hash]; ok {
+		return nil, ErrAlreadyKnown
+	}
+	sender := normalizeAddress(tx.From)
+	if tx.Nonce >= state.GetNonce(sender)+int64(p.config.MaxPerSender) {
+		return nil, fmt.Errorf("%w: nonce %d is too far ahead of account nonce %d", ErrNonceTooHigh, tx.Nonce, state.GetNonce(sender))
+	}
+
+	entry := &poolTx{Tx: tx, price: effectiveGasPrice(&tx), added: added}
+	if old, ok := p.senders[sender][tx.Nonce]; ok {
+		// Replace-by-fee
+		min := new(big.Int).Mul(old.price, big.NewInt(int64(100+p.config.PriceBump)))
+		if new(big.Int).Mul(entry.price, big.NewInt(100)).Cmp(min) < 0 {
+			return nil, fmt.Errorf("%w: need %d%% more than the pending transaction's gas price", ErrReplaceUnderpriced, p.config.PriceBump)
+		}
+		p.remove(old.Tx.Hash)
+		p.insert(sender, entry)
+		return &old.Tx, nil
+	}
+
+	if len(p.senders[sender]) >= p.config.MaxPerSender {
+		return nil, ErrSenderLimit
+	}
+	if len(p.all) >= p.config.MaxSize {
+		victim := p.evictionCandidate(state)
+		if victim == nil || victim.price.Cmp(entry.price) >= 0 {
+			return nil, ErrPoolFull
+		}
+		p.remove(victim.Tx.Hash)
+	}
+	p.insert(sender, entry)
+	return nil, nil
+}
+
+func (p *TxPool) insert(sender string, entry *poolTx) {
+	p.all[entry.Tx.Hash] = entry
+	if p.senders[sender] == nil {
+		p.senders[sender] = make(map[int64]*poolTx)
+	}
+	p.senders[sender][entry.Tx.Nonce] = entry
+}
+
+// remove drops a transaction from the pool
+func (p *TxPool) remove(hash string) {
+	entry, ok := p.all[hash]
+	if !ok {
+		return
+	}
+	delete(p.all, hash)
+	sender := normalizeAddress(entry.Tx.From)
+	delete(p.senders[sender], entry.Tx.Nonce)
+	if len(p.senders[sender]) == 0 {
+		delete(p.senders, sender)
+	}
+}
+
+// evictionCandidate returns the cheapest future transaction, or the cheapest
+// executable one if there are no future transactions
+func (p *TxPool) evictionCandidate(state *State) *poolTx {
+	executable := p.executableSet(state)
+	var victim *poolTx
+	for _, entry := range p.all {
+		if victim == nil {
+			victim = entry
+			continue
+		}
+		entryFuture, victimFuture := !executable[entry.Tx.Hash], !executable[victim.Tx.Hash]
+		if entryFuture != victimFuture {
+			if entryFuture {
+				victim = entry
+			}
+			continue
+		}
+		if c := entry.price.Cmp(victim.price); c < 0 || (c == 0 && entry.added.After(victim.added)) {
+			victim = entry
+		}
+	}
+	return victim
+}
+
+// executableSet returns the hashes of transactions that continue their
+// sender's account nonce without gaps
+func (p *TxPool) executableSet(state *State) map[string]bool {
+	set := make(map[string]bool)
+	for sender, txs := range p.senders {
+		for nonce := state.GetNonce(sender); txs[nonce] != nil; nonce++ {
+			set[txs[nonce].Tx.Hash] = true
+		}
+	}
+	return set
+}
+
+// Get returns a pending transaction by hash
+func (p *TxPool) Get(hash string) (Transaction, bool) {
+	entry, ok := p.all[hash]
+	if !ok {
+		return Transaction{}, false
+	}
+	return entry.Tx, true
+}
+
+// Len returns the number of pending transactions
+func (p *TxPool) Len() int {
+	return len(p.all)
+}
+
+// Executable returns the executable transactions in the order a block should
+// include them: highest effective gas price first, each sender's in nonce order
+func (p *TxPool) Executable(state *State) []Transaction {
+	queue := &priceQueue{}
+	next := make(map[string]int64)
+	for sender, txs := range p.senders {
+		nonce := state.GetNonce(sender)
+		if entry := txs[nonce]; entry != nil {
+			heap.Push(queue, entry)
+			next[sender] = nonce + 1
+		}
+	}
+
+	ordered := []Transaction{}
+	for queue.Len() > 0 {
+		entry := heap.Pop(queue).(*poolTx)
+		ordered = append(ordered, entry.Tx)
+		sender := normalizeAddress(entry.Tx.From)
+		if following := p.senders[sender][next[sender]]; following != nil {
+			heap.Push(queue, following)
+			next[sender]++
+		}
+	}
+	return ordered
+}
+
+// Future returns the transactions waiting for a nonce gap to fill, ordered
+// by sender and nonce
+func (p *TxPool) Future(state *State) []Transaction {
+	executable := p.executableSet(state)
+	future := []Transaction{}
+	for _, entry := range p.all {
+		if !executable[entry.Tx.Hash] {
+			future = append(future, entry.Tx)
+		}
+	}
+	sort.Slice(future, func(i, j int) bool {
+		if future[i].From != future[j].From {
+			return future[i].From < future[j].From
+		}
+		return future[i].Nonce < future[j].Nonce
+	})
+	return future
+}
+
+// Transactions returns every pending transaction, executable ones first
+func (p *TxPool) Transactions(state *State) []Transaction {
+	return append(p.Executable(state), p.Future(state)...)
+}
+
+// storedTx is a pending transaction as saved to disk, with the time it
+// entered the pool so a restart does not reset its expiry
+type storedTx struct {
+	Transaction
+	Added int64 `json:"added"` // unix nanoseconds
+}
+
+// stored returns every pending transaction in the order of Transactions,
+// ready to be saved
+func (p *TxPool) stored(state *State) []storedTx {
+	txs := p.Transactions(state)
+	stored := make([]storedTx, len(txs))
+	for i, tx := range txs {
+		stored[i] = storedTx{Transaction: tx, Added: p.all[tx.Hash].added.UnixNano()}
+	}
+	return stored
+}
+
+// restore adds saved transactions back with their arrival times. Those saved
+// before arrival times were kept arrive now.
+func (p *TxPool) restore(stored []storedTx, state *State) {
+	for _, s := range stored {
+		added := time.Now()
+		if s.Added > 0 {
+			added = time.Unix(0, s.Added)
+		}
+		p.add(s.Transaction, state, added)
+	}
+}
+
+// PendingNonce returns the nonce following address's executable transactions
+func (p *TxPool) PendingNonce(address string, state *State) int64 {
+	txs := p.senders[normalizeAddress(address)]
+	nonce := state.GetNonce(address)
+	for txs[nonce] != nil {
+		nonce++
+	}
+	return nonce
+}
+
+// Prune drops transactions whose nonce has been used and those that have
+// waited in the pool longer than Lifetime, returning the ones dropped
+func (p *TxPool) Prune(state *State, now time.Time) (stale, expired []Transaction) {
+	deadline := now.Add(-p.config.Lifetime)
+	for hash, entry := range p.all {
+		switch {
+		case entry.Tx.Nonce < state.GetNonce(entry.Tx.From):
+			stale = append(stale, entry.Tx)
+			p.remove(hash)
+		case entry.added.Before(deadline):
+			expired = append(expired, entry.Tx)
+			p.remove(hash)
+		}
+	}
+	return stale, expired
+}
+
+// priceQueue is a max-heap of transactions by effective gas price, oldest
+// first on ties
+type priceQueue []*poolTx
+
+func (q priceQueue) Len() int { return len(q) }
+func (q priceQueue) Less(i, j int) bool {
+	if c := q[i].price.Cmp(q[j].price); c != 0 {
+		return c > 0
+	}
+	return q[i].added.Before(q[j].added)
+}
+func (q priceQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *priceQueue) Push(x interface{}) { *q = append(*q, x.(*poolTx)) }
+func (q *priceQueue) Pop() interface{} {
+	old := *q
+	entry := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return entry
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"gydschain/client"
+)
+
+func TestPruneExpiresOnPoolArrival(t *testing.T) {
+	state := testChain(t, testGenesis(t)).State
+	pool := NewTxPool(DefaultMempoolConfig())
+
+	// Senders choose their own timestamps; neither one decides expiry
+	backdated := Transaction{Hash: "backdated", From: "0x1111111111111111111111111111111111111111", Gas: 21000, GasPrice: "1000000000", Timestamp: 1}
+	postdated := Transaction{Hash: "postdated", From: "0x2222222222222222222222222222222222222222", Gas: 21000, GasPrice: "1000000000", Timestamp: time.Now().Add(100 * time.Hour).Unix()}
+	for _, tx := range []Transaction{backdated, postdated} {
+		if _, err := pool.Add(tx, state); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, expired := pool.Prune(state, time.Now()); len(expired) != 0 {
+		t.Fatalf("expired %d transactions that just arrived", len(expired))
+	}
+	_, expired := pool.Prune(state, time.Now().Add(DefaultMempoolConfig().Lifetime+time.Minute))
+	if len(expired) != 2 || pool.Len() != 0 {
+		t.Fatalf("expired %d, %d left; want 2, 0", len(expired), pool.Len())
+	}
+}
+
+// pendingTx is a pool transaction from sender paying gwei per unit of gas.
+// The pool does not check signatures, so the hash only has to be unique.
+func pendingTx(sender string, nonce int64, gwei int64) Transaction {
+	return Transaction{
+		Hash:     fmt.Sprintf("%s-%d-%d", sender, nonce, gwei),
+		From:     sender,
+		Gas:      21000,
+		GasPrice: new(big.Int).Mul(big.NewInt(gwei), big.NewInt(1e9)).String(),
+		Nonce:    nonce,
+	}
+}
+
+func hashes(txs []Transaction) []string {
+	out := make([]string, len(txs))
+	for i, tx := range txs {
+		out[i] = tx.Hash
+	}
+	return out
+}
+
+func TestExecutableOrderedByPriceAndNonce(t *testing.T) {
+	state := testChain(t, testGenesis(t)).State
+	pool := NewTxPool(DefaultMempoolConfig())
+	alice, bob := "0x1111111111111111111111111111111111111111", "0x2222222222222222222222222222222222222222"
+
+	// Bob's second transaction pays the most but must wait for his first;
+	// Alice's nonce 3 leaves a gap and waits for nonce 2
+	for _, tx := range []Transaction{
+		pendingTx(alice, 0, 5),
+		pendingTx(alice, 1, 3),
+		pendingTx(alice, 3, 9),
+		pendingTx(bob, 1, 20),
+		pendingTx(bob, 0, 4),
+	} {
+		if _, err := pool.Add(tx, state); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{alice + "-0-5", bob + "-0-4", bob + "-1-20", alice + "-1-3"}
+	if got := hashes(pool.Executable(state)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("executable = %v, want %v", got, want)
+	}
+	if got := hashes(pool.Future(state)); !reflect.DeepEqual(got, []string{alice + "-3-9"}) {
+		t.Fatalf("future = %v", got)
+	}
+	if n := pool.PendingNonce(alice, state); n != 2 {
+		t.Fatalf("pending nonce = %d, want 2", n)
+	}
+
+	// Filling the gap makes the waiting transaction executable
+	if _, err := pool.Add(pendingTx(alice, 2, 1), state); err != nil {
+		t.Fatal(err)
+	}
+	if future := pool.Future(state); len(future) != 0 {
+		t.Fatalf("future = %v after the gap filled", hashes(future))
+	}
+	if got := hashes(pool.Executable(state)); got[len(got)-1] != alice+"-3-9" {
+		t.Fatalf("executable = %v, want nonce 3 last", got)
+	}
+}
+
+func TestReplaceByFee(t *testing.T) {
+	state := testChain(t, testGenesis(t)).State
+	pool := NewTxPool(DefaultMempoolConfig())
+	sender := "0x1111111111111111111111111111111111111111"
+
+	original := pendingTx(sender, 0, 100)
+	if _, err := pool.Add(original, state); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.Add(original, state); !errors.Is(err, ErrAlreadyKnown) {
+		t.Fatalf("adding twice = %v, want %v", err, ErrAlreadyKnown)
+	}
+	if _, err := pool.Add(pendingTx(sender, 0, 105), state); !errors.Is(err, ErrReplaceUnderpriced) {
+		t.Fatalf("5%% bump = %v, want %v", err, ErrReplaceUnderpriced)
+	}
+
+	replaced, err := pool.Add(pendingTx(sender, 0, 120), state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replaced == nil || replaced.Hash != original.Hash {
+		t.Fatalf("replaced = %v, want the original", replaced)
+	}
+	if _, ok := pool.Get(original.Hash); ok || pool.Len() != 1 {
+		t.Fatalf("original still pending, pool holds %d", pool.Len())
+	}
+}
+
+func TestFullPoolEvictsCheapest(t *testing.T) {
+	state := testChain(t, testGenesis(t)).State
+	config := DefaultMempoolConfig()
+	config.MaxSize = 2
+	pool := NewTxPool(config)
+	alice, bob, carol := "0x1111111111111111111111111111111111111111", "0x2222222222222222222222222222222222222222", "0x3333333333333333333333333333333333333333"
+
+	// A future transaction goes before a cheaper executable one
+	executable, future := pendingTx(alice, 0, 2), pendingTx(bob, 1, 3)
+	for _, tx := range []Transaction{executable, future} {
+		if _, err := pool.Add(tx, state); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := pool.Add(pendingTx(carol, 0, 4), state); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := pool.Get(future.Hash); ok {
+		t.Fatal("future transaction not evicted first")
+	}
+
+	// Then the cheapest, and a transaction no better than it is refused
+	if _, err := pool.Add(pendingTx(bob, 0, 2), state); !errors.Is(err, ErrPoolFull) {
+		t.Fatalf("adding the cheapest = %v, want %v", err, ErrPoolFull)
+	}
+	if _, err := pool.Add(pendingTx(bob, 0, 5), state); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := pool.Get(executable.Hash); ok || pool.Len() != 2 {
+		t.Fatalf("cheapest not evicted, pool holds %d", pool.Len())
+	}
+}
+
+func TestMaxPerSender(t *testing.T) {
+	state := testChain(t, testGenesis(t)).State
+	config := DefaultMempoolConfig()
+	config.MaxPerSender = 2
+	pool := NewTxPool(config)
+	sender := "0x1111111111111111111111111111111111111111"
+
+	if _, err := pool.Add(pendingTx(sender, 2, 1), state); !errors.Is(err, ErrNonceTooHigh) {
+		t.Fatalf("nonce beyond the gap limit = %v, want %v", err, ErrNonceTooHigh)
+	}
+	for nonce := int64(0); nonce < 2; nonce++ {
+		if _, err := pool.Add(pendingTx(sender, nonce, 1), state); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Once the account moves on, the gap limit allows nonce 2, but the
+	// sender already holds its share of the pool
+	state.mutable(sender).Nonce = 1
+	if _, err := pool.Add(pendingTx(sender, 2, 1), state); !errors.Is(err, ErrSenderLimit) {
+		t.Fatalf("third transaction = %v, want %v", err, ErrSenderLimit)
+	}
+	if _, err := pool.Add(pendingTx(sender, 1, 2), state); err != nil {
+		t.Fatalf("replacement refused at the sender limit: %v", err)
+	}
+}
+
+func TestPoolArrivalSurvivesRestart(t *testing.T) {
+	key, sender := testKey(t)
+	genesis := testGenesis(t)
+	genesis.Alloc = map[string]GenesisAccount{sender: {Balance: "10000000000000000000"}}
+	store := NewMemoryStore()
+	chain, err := openBlockchain(store, genesis, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The sender's timestamp is kept as sent; the pool tracks arrival itself
+	tx := signedTx(t, client.NewTransfer(sender, "0x2222222222222222222222222222222222222222", big.NewInt(5), 0), key)
+	tx.Timestamp = 1
+	if err := chain.AddTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	if pending, _ := chain.pool.Get(tx.Hash); pending.Timestamp != 1 {
+		t.Fatalf("pending timestamp = %d, want the sender's 1", pending.Timestamp)
+	}
+
+	arrived := time.Now().Add(-2 * time.Hour).Round(0)
+	chain.pool.all[tx.Hash].added = arrived
+	if err := chain.persistPending(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := openBlockchain(store, genesis, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := reopened.pool.all[tx.Hash]
+	if entry == nil || !entry.added.Equal(arrived) || entry.Tx.Timestamp != 1 {
+		t.Fatalf("reopened entry = %+v, want arrival %v and timestamp 1", entry, arrived)
+	}
+	// Two of its three hours are already spent
+	if _, expired := reopened.pool.Prune(reopened.State, time.Now().Add(time.Hour+time.Minute)); len(expired) != 1 {
+		t.Fatalf("expired %d transactions, want 1", len(expired))
+	}
+}
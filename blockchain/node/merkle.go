@@ -0,0 +1,300 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+)
+
+// Merkle tree node prefixes. Hashing leaves and interior nodes differently
+// keeps an interior node from being passed off as a leaf.
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+	merkleSizePrefix = 0x02
+)
+
+// MerkleStep is one sibling hash on the path from a leaf up to the root
+type MerkleStep struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left"` // the sibling is the left child
+}
+
+// merkleRoot returns the hex root of a binary Merkle tree over leaves. An odd
+// node at the end of a level is carried up unchanged. The root of no leaves
+// is the hash of the empty string.
+func merkleRoot(leaves [][]byte) string {
+	if len(leaves) == 0 {
+		sum := sha256.Sum256(nil)
+		return hex.EncodeToString(sum[:])
+	}
+
+	level := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		level[i] = merkleLeafHash(leaf)
+	}
+	for len(level) > 1 {
+		level = merkleParents(level)
+	}
+	return hex.EncodeToString(level[0])
+}
+
+func merkleLeafHash(leaf []byte) []byte {
+	sum := sha256.Sum256(append([]byte{merkleLeafPrefix}, leaf...))
+	return sum[:]
+}
+
+func merkleNodeHash(left, right []byte) []byte {
+	data := append([]byte{merkleNodePrefix}, left...)
+	sum := sha256.Sum256(append(data, right...))
+	return sum[:]
+}
+
+// merkleBranch returns the siblings needed to hash leaves[index] up to the
+// root. Levels where the node is carried up unchanged contribute no step.
+func merkleBranch(leaves [][]byte, index int) []MerkleStep {
+	level := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		level[i] = merkleLeafHash(leaf)
+	}
+
+	branch := []MerkleStep{}
+	for len(level) > 1 {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			branch = append(branch, MerkleStep{
+				Hash: hex.EncodeToString(level[sibling]),
+				Left: sibling < index,
+			})
+		}
+		level = merkleParents(level)
+		index /= 2
+	}
+	return branch
+}
+
+// merkleBranchRoot hashes leaf up branch and returns the hex root it leads to
+func merkleBranchRoot(leaf []byte, branch []MerkleStep) (string, error) {
+	node := merkleLeafHash(leaf)
+	for _, step := range branch {
+		sibling, err := hex.DecodeString(step.Hash)
+		if err != nil || len(sibling) != sha256.Size {
+			return "", errors.New("malformed branch hash")
+		}
+		if step.Left {
+			node = merkleNodeHash(sibling, node)
+		} else {
+			node = merkleNodeHash(node, sibling)
+		}
+	}
+	return hex.EncodeToString(node), nil
+}
+
+// merkleShape returns the Left flags of the branch of leaf index in a tree of
+// count leaves
+func merkleShape(index, count int) []bool {
+	shape := []bool{}
+	for width := count; width > 1; width = (width + 1) / 2 {
+		if sibling := index ^ 1; sibling < width {
+			shape = append(shape, sibling < index)
+		}
+		index /= 2
+	}
+	return shape
+}
+
+// merkleParents hashes one level of the tree into the next
+func merkleParents(level [][]byte) [][]byte {
+	parents := make([][]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			parents = append(parents, level[i])
+			continue
+		}
+		parents = append(parents, merkleNodeHash(level[i], level[i+1]))
+	}
+	return parents
+}
+
+// transactionsRoot commits to a block's transactions, signatures included
+func transactionsRoot(txs []Transaction) string {
+	leaves := make([][]byte, len(txs))
+	for i := range txs {
+		leaves[i] = encodeTransaction(&txs[i])
+	}
+	return merkleRoot(leaves)
+}
+
+// receiptsRoot commits to the outcome of a block's transactions
+func receiptsRoot(receipts []Receipt) string {
+	leaves := make([][]byte, len(receipts))
+	for i := range receipts {
+		leaves[i] = encodeReceipt(&receipts[i])
+	}
+	return merkleRoot(leaves)
+}
+
+// validatorsRoot commits to a validator set, the members ordered by address,
+// and to how many there are
+func validatorsRoot(set []Validator) string {
+	leaves := make([][]byte, len(set))
+	for i := range set {
+		leaves[i] = encodeValidator(&set[i])
+	}
+	return sizedRoot(len(set), merkleRoot(leaves))
+}
+
+// Root commits to the accounts, ordered by address, and to how many
+// there are. The count fixes the shape of the tree, so two neighbouring
+// leaves prove that no account lies between them.
+func (s *State) Root() string {
+	addresses := s.sortedAddresses()
+	leaves := make([][]byte, len(addresses))
+	for i, address := range addresses {
+		leaves[i] = encodeAccount(address, s.accounts[address])
+	}
+	return sizedRoot(len(leaves), merkleRoot(leaves))
+}
+
+// sizedRoot hashes a tree's leaf count together with its Merkle root
+func sizedRoot(count int, root string) string {
+	data := binary.BigEndian.AppendUint64([]byte{merkleSizePrefix}, uint64(count))
+	sum := sha256.Sum256(appendHash(data, root))
+	return hex.EncodeToString(sum[:])
+}
+
+// encodeHeader is the canonical binary encoding of a block header that the
+// block hash is computed over. Integers are big-endian, hashes are 32 raw
+// bytes and strings are prefixed with their uvarint length.
+func encodeHeader(block *Block) []byte {
+	buf := make([]byte, 0, 256)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(block.Index))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(block.Timestamp))
+	buf = appendHash(buf, block.PreviousHash)
+	buf = appendHash(buf, block.TxRoot)
+	buf = appendHash(buf, block.ReceiptsRoot)
+	buf = appendHash(buf, block.StateRoot)
+	buf = appendHash(buf, block.ValidatorsRoot)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(block.Nonce))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(block.Difficulty))
+	buf = appendString(buf, block.Miner)
+	buf = appendString(buf, block.Validator)
+	buf = appendString(buf, block.Type)
+	buf = appendString(buf, block.Reward)
+	return buf
+}
+
+// encodeTransaction is the Merkle leaf of a transaction
+func encodeTransaction(tx *Transaction) []byte {
+	buf := make([]byte, 0, 512)
+	buf = appendString(buf, tx.From)
+	buf = appendString(buf, tx.To)
+	buf = appendString(buf, tx.Value)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(tx.Gas))
+	buf = appendString(buf, tx.GasPrice)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(tx.Nonce))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(tx.Timestamp))
+	buf = appendString(buf, tx.Signature)
+	buf = appendString(buf, tx.PublicKey)
+	buf = appendString(buf, tx.Type)
+	// Data is rare, and left out when empty
+	if tx.Data != "" {
+		buf = appendString(buf, tx.Data)
+	}
+	return buf
+}
+
+// encodeReceipt is the Merkle leaf of a receipt. Where the receipt was
+// included is left out: the block hash depends on the root.
+func encodeReceipt(receipt *Receipt) []byte {
+	buf := make([]byte, 0, 128)
+	buf = appendHash(buf, receipt.TxHash)
+	buf = append(buf, byte(receipt.Status))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(receipt.GasUsed))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(receipt.CumulativeGasUsed))
+	buf = appendString(buf, receipt.Fee)
+	return buf
+}
+
+// encodeValidator is the Merkle leaf of a member of a validator set: what
+// proposer selection and block signatures need
+func encodeValidator(val *Validator) []byte {
+	buf := make([]byte, 0, 192)
+	buf = appendString(buf, val.Address)
+	buf = appendString(buf, val.PublicKey)
+	buf = appendString(buf, val.EffectiveStake)
+	return buf
+}
+
+// encodeAccount is the Merkle leaf of an account in the state root
+func encodeAccount(address string, acct *AccountState) []byte {
+	buf := make([]byte, 0, 96)
+	buf = appendString(buf, address)
+	buf = appendString(buf, acct.Balance.String())
+	buf = binary.BigEndian.AppendUint64(buf, uint64(acct.Nonce))
+	// Staking fields are only encoded when present, so plain accounts keep
+	// their original encoding
+	if acct.Validator == nil && len(acct.Unbonding) == 0 && acct.Pool == nil && len(acct.Delegations) == 0 {
+		return buf
+	}
+	if val := acct.Validator; val != nil {
+		buf = append(buf, 1)
+		buf = appendString(buf, val.Status)
+		buf = appendString(buf, val.PublicKey)
+		buf = appendString(buf, val.Stake.String())
+		buf = binary.BigEndian.AppendUint64(buf, uint64(val.JoinedAt))
+		buf = binary.BigEndian.AppendUint64(buf, uint64(val.LockedUntil))
+		buf = binary.BigEndian.AppendUint64(buf, uint64(val.BlocksMinted))
+		buf = binary.BigEndian.AppendUint64(buf, uint64(val.JailedUntil))
+		buf = binary.BigEndian.AppendUint64(buf, uint64(val.ExitAt))
+		buf = binary.BigEndian.AppendUint64(buf, uint64(val.MissedSlots))
+		buf = binary.BigEndian.AppendUint64(buf, uint64(val.TotalMissed))
+		buf = binary.BigEndian.AppendUint64(buf, uint64(val.LastDoubleSign))
+		buf = binary.AppendUvarint(buf, uint64(len(val.RetiredKeys)))
+		for _, retired := range val.RetiredKeys {
+			buf = appendString(buf, retired.PublicKey)
+			buf = binary.BigEndian.AppendUint64(buf, uint64(retired.Until))
+		}
+	} else {
+		buf = append(buf, 0)
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(acct.Unbonding)))
+	for _, entry := range acct.Unbonding {
+		buf = appendString(buf, entry.Amount.String())
+		buf = binary.BigEndian.AppendUint64(buf, uint64(entry.ReleaseAt))
+	}
+	if acct.Pool == nil && len(acct.Delegations) == 0 {
+		return buf
+	}
+	if pool := acct.Pool; pool != nil {
+		buf = append(buf, 1)
+		buf = appendString(buf, pool.Delegated.String())
+		buf = appendString(buf, pool.RewardPerShare.String())
+	} else {
+		buf = append(buf, 0)
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(acct.Delegations)))
+	for _, del := range acct.Delegations {
+		buf = appendString(buf, del.Validator)
+		buf = appendString(buf, del.Amount.String())
+		buf = appendString(buf, del.Rewards.String())
+		buf = appendString(buf, del.RewardEntry.String())
+	}
+	return buf
+}
+
+// appendHash appends a hex hash as 32 bytes. Anything that is not a 32-byte
+// hex hash is encoded as zeros; validation rejects such hashes separately.
+func appendHash(buf []byte, hash string) []byte {
+	var raw [sha256.Size]byte
+	if decoded, err := hex.DecodeString(hash); err == nil && len(decoded) == sha256.Size {
+		copy(raw[:], decoded)
+	}
+	return append(buf, raw[:]...)
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
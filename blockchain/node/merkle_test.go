@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestMerkleRootCommitsToEveryLeaf(t *testing.T) {
+	for count := 1; count <= 7; count++ {
+		leaves := make([][]byte, count)
+		for i := range leaves {
+			leaves[i] = []byte{byte(i)}
+		}
+		root := merkleRoot(leaves)
+		if count == 1 {
+			if want := hex.EncodeToString(merkleLeafHash(leaves[0])); root != want {
+				t.Fatalf("single leaf root %s, want its leaf hash %s", root, want)
+			}
+		}
+		for i := range leaves {
+			changed := append([][]byte(nil), leaves...)
+			changed[i] = []byte{0xff}
+			if merkleRoot(changed) == root {
+				t.Fatalf("%d leaves: changing leaf %d kept the root", count, i)
+			}
+		}
+	}
+
+	// A leaf cannot pass for an inner node
+	left, right := merkleLeafHash([]byte{0}), merkleLeafHash([]byte{1})
+	inner := bytes.Join([][]byte{left, right}, nil)
+	if merkleRoot([][]byte{inner}) == merkleRoot([][]byte{{0}, {1}}) {
+		t.Fatal("leaf holding two hashes has the root of their tree")
+	}
+}
+
+func TestBlockHashCommitsToTransactions(t *testing.T) {
+	bc, genesis := minedTransfers(t, 3)
+	block := bc.Blocks[1]
+	if block.TxRoot != transactionsRoot(block.Transactions) {
+		t.Fatal("mined block's transactions root does not match its transactions")
+	}
+
+	// Swapping the contents under the same header is caught...
+	other := testChain(t, genesis)
+	swapped := block
+	swapped.Transactions = []Transaction{block.Transactions[1], block.Transactions[0], block.Transactions[2]}
+	if err := other.AddBlock(swapped); err == nil || !strings.Contains(err.Error(), "transactions root") {
+		t.Fatalf("AddBlock = %v, want a transactions root mismatch", err)
+	}
+	// ...and a header with another root is another block
+	swapped.TxRoot = transactionsRoot(swapped.Transactions)
+	if calculateHash(swapped) == block.Hash {
+		t.Fatal("block hash does not cover the transactions root")
+	}
+}
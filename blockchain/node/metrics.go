@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+)
+
+// startMetricsServer serves node metrics in the Prometheus text format on
+// their own port so they can be scraped without exposing the RPC API
+func startMetricsServer(cfg *NodeConfig) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(cfg.Metrics.Path, handleMetrics)
+	addr := fmt.Sprintf("%s:%d", cfg.RPC.Host, cfg.Metrics.Port)
+	log.Printf("📈 Metrics on %s%s", addr, cfg.Metrics.Path)
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("❌ Metrics server stopped: %v", err)
+		}
+	}()
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	blockchain.mu.RLock()
+	height := blockchain.Blocks[len(blockchain.Blocks)-1].Index
+	pending := blockchain.pool.Len()
+	validators := len(blockchain.Validators)
+	difficulty := blockchain.CurrentDiff
+	supply, _ := new(big.Float).SetInt(blockchain.TotalSupply).Float64()
+	reorgs := len(blockchain.reorgs)
+	blockchain.mu.RUnlock()
+
+	syncing := 0
+	if syncManager.Syncing() {
+		syncing = 1
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics := []struct {
+		name, help string
+		value      interface{}
+	}{
+		{"gydschain_block_height", "Height of the canonical head block", height},
+		{"gydschain_peers", "Connected peers", p2pServer.PeerCount()},
+		{"gydschain_pending_transactions", "Transactions waiting in the pool", pending},
+		{"gydschain_validators", "Registered validators", validators},
+		{"gydschain_difficulty", "Current proof-of-work difficulty", difficulty},
+		{"gydschain_total_supply_wei", "Coins issued so far, in wei", supply},
+		{"gydschain_syncing", "1 while catching up with peers", syncing},
+		{"gydschain_recent_reorgs", "Chain reorganizations kept in history", reorgs},
+	}
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %v\n", m.name, m.help, m.name, m.name, m.value)
+	}
+}
@@ -0,0 +1,937 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// P2P protocol constants
+const (
+	ProtocolVersion    = 1
+	handshakeTimeout   = 10 * time.Second
+	dialTimeout        = 5 * time.Second
+	discoveryInterval  = 30 * time.Second
+	peerSendQueueSize  = 256
+	maxMessageSize     = 16 * 1024 * 1024
+	knownCacheSize     = 4096
+	maxAdvertisedPeers = 32
+	requestTimeout     = 30 * time.Second
+	maxHeadersServed   = 2000
+	maxBodiesServed    = 500
+)
+
+// Message types
+const (
+	MsgStatus     = "status"
+	MsgGetPeers   = "getpeers"
+	MsgPeers      = "peers"
+	MsgTx         = "tx"
+	MsgBlock      = "block"
+	MsgGetHeaders = "getheaders"
+	MsgHeaders    = "headers"
+	MsgGetBodies  = "getbodies"
+	MsgBodies     = "bodies"
+
+	MsgGetTxProof      = "gettxproof"
+	MsgTxProof         = "txproof"
+	MsgGetAccountProof = "getaccountproof"
+	MsgAccountProof    = "accountproof"
+	MsgGetValidatorSet = "getvalidatorset"
+	MsgValidatorSet    = "validatorset"
+)
+
+// Message is a single newline-delimited JSON frame on a peer connection.
+// Requests carry an ID which the matching response echoes.
+type Message struct {
+	Type    string          `json:"type"`
+	ID      uint64          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// GetHeadersRequest asks for canonical headers starting at From
+type GetHeadersRequest struct {
+	From  int64 `json:"from"`
+	Count int   `json:"count"`
+}
+
+// BlockBody holds the transactions of the block with the given hash
+type BlockBody struct {
+	Hash         string        `json:"hash"`
+	Transactions []Transaction `json:"transactions"`
+}
+
+// StatusMessage is exchanged by both sides when a connection opens
+type StatusMessage struct {
+	Version     int       `json:"version"`
+	NodeID      string    `json:"nodeId"`
+	ChainID     int64     `json:"chainId"`
+	NetworkID   int64     `json:"networkId"`
+	GenesisHash string    `json:"genesisHash"`
+	Height      int64     `json:"height"`
+	HeadHash    string    `json:"headHash"`
+	ListenPort  int       `json:"listenPort"`
+	Lite        bool      `json:"lite,omitempty"`        // serves headers only
+	PrunedBelow int64     `json:"prunedBelow,omitempty"` // serves no bodies below this height
+	Client      string    `json:"client,omitempty"`      // node software version
+	Location    *Location `json:"location,omitempty"`    // where the operator says the node runs
+}
+
+// Location is where a node operator says the node runs. Nothing checks it;
+// it is shown to peers and API clients as given.
+type Location struct {
+	Region    string  `json:"region,omitempty"`
+	Country   string  `json:"country,omitempty"` // ISO 3166-1 alpha-2 code
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// maxClientVersionLength bounds the version string a node reports, and
+// maxRegionLength its location's region
+const (
+	maxClientVersionLength = 64
+	maxRegionLength        = 64
+)
+
+// validate reports the first implausible field of a location
+func (l *Location) validate() error {
+	switch {
+	case len(l.Region) > maxRegionLength:
+		return fmt.Errorf("region: must be at most %d characters", maxRegionLength)
+	case l.Country != "" && (len(l.Country) != 2 || strings.Trim(l.Country, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != ""):
+		return fmt.Errorf("country: must be a two-letter uppercase country code, got %q", l.Country)
+	case !(l.Latitude >= -90 && l.Latitude <= 90):
+		return fmt.Errorf("latitude: must be between -90 and 90, got %v", l.Latitude)
+	case !(l.Longitude >= -180 && l.Longitude <= 180):
+		return fmt.Errorf("longitude: must be between -180 and 180, got %v", l.Longitude)
+	}
+	return nil
+}
+
+// validateClientVersion checks a node software version string
+func validateClientVersion(version string) error {
+	if version == "" || len(version) > maxClientVersionLength {
+		return fmt.Errorf("must be 1 to %d characters", maxClientVersionLength)
+	}
+	for _, r := range version {
+		if r <= ' ' || r > '~' {
+			return fmt.Errorf("must be printable ASCII without spaces, got %q", version)
+		}
+	}
+	return nil
+}
+
+// P2PConfig configures the peer-to-peer server
+type P2PConfig struct {
+	ListenAddr     string   // e.g. "0.0.0.0:30303"
+	BootstrapNodes []string // host:port of peers to dial on start
+	MaxPeers       int
+	NodeID         string    // random if empty
+	ClientVersion  string    // reported to peers
+	Location       *Location // reported to peers, nil to keep it private
+}
+
+// PeerInfo describes a connected peer for the API
+type PeerInfo struct {
+	ID       string    `json:"id"`
+	Address  string    `json:"address"`
+	Inbound  bool      `json:"inbound"`
+	Height   int64     `json:"height"`
+	HeadHash string    `json:"headHash"`
+	Client   string    `json:"client,omitempty"`
+	Location *Location `json:"location,omitempty"`
+}
+
+// Peer is a connected, handshaked remote node
+type Peer struct {
+	conn        net.Conn
+	id          string
+	addr        string // address the peer accepts connections on
+	inbound     bool
+	status      StatusMessage
+	send        chan Message
+	knownTxs    *boundedSet
+	knownBlocks *boundedSet
+	closed      chan struct{}
+	closeOnce   sync.Once
+	pending     map[uint64]chan Message
+	nextID      uint64
+	mu          sync.RWMutex
+}
+
+// P2PServer maintains peer connections and gossips blocks and transactions
+type P2PServer struct {
+	config   P2PConfig
+	chain    *Blockchain
+	nodeID   string
+	listener net.Listener
+	peers    map[string]*Peer
+	dialing  map[string]bool
+	known    map[string]bool // dialable addresses learned from peers
+	self     map[string]bool // addresses that turned out to be this node
+	syncer   *SyncManager
+	quit     chan struct{}
+	mu       sync.RWMutex
+}
+
+// NewP2PServer creates a server for chain; call Start to begin listening
+func NewP2PServer(chain *Blockchain, config P2PConfig) *P2PServer {
+	if config.MaxPeers <= 0 {
+		config.MaxPeers = 50
+	}
+	if config.NodeID == "" {
+		id := make([]byte, 16)
+		rand.Read(id)
+		config.NodeID = hex.EncodeToString(id)
+	}
+
+	s := &P2PServer{
+		config:  config,
+		chain:   chain,
+		nodeID:  config.NodeID,
+		peers:   make(map[string]*Peer),
+		dialing: make(map[string]bool),
+		known:   make(map[string]bool),
+		self:    make(map[string]bool),
+		quit:    make(chan struct{}),
+	}
+	for _, addr := range config.BootstrapNodes {
+		s.known[addr] = true
+	}
+	return s
+}
+
+// Start listens for inbound peers, dials bootstrap nodes and subscribes to
+// the chain so new blocks and transactions are gossiped
+func (s *P2PServer) Start() error {
+	listener, err := net.Listen("tcp", s.config.ListenAddr)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	s.chain.AddListener(s)
+
+	go s.acceptLoop()
+	go s.discoveryLoop()
+	return nil
+}
+
+// Stop closes the listener and all peer connections
+func (s *P2PServer) Stop() {
+	close(s.quit)
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.mu.RLock()
+	peers := make([]*Peer, 0, len(s.peers))
+	for _, p := range s.peers {
+		peers = append(peers, p)
+	}
+	s.mu.RUnlock()
+	for _, p := range peers {
+		p.close()
+	}
+}
+
+// ListenAddr returns the address the server is listening on
+func (s *P2PServer) ListenAddr() string {
+	if s.listener == nil {
+		return s.config.ListenAddr
+	}
+	return s.listener.Addr().String()
+}
+
+// NodeID returns this node's P2P identity
+func (s *P2PServer) NodeID() string {
+	return s.nodeID
+}
+
+// PeerCount returns the number of connected peers
+func (s *P2PServer) PeerCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.peers)
+}
+
+// Peers returns information about every connected peer
+func (s *P2PServer) Peers() []PeerInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	infos := make([]PeerInfo, 0, len(s.peers))
+	for _, p := range s.peers {
+		status := p.getStatus()
+		infos = append(infos, PeerInfo{
+			ID:       p.id,
+			Address:  p.addr,
+			Inbound:  p.inbound,
+			Height:   status.Height,
+			HeadHash: status.HeadHash,
+			Client:   status.Client,
+			Location: status.Location,
+		})
+	}
+	return infos
+}
+
+// Connect dials a peer and performs the handshake
+func (s *P2PServer) Connect(addr string) error {
+	s.mu.Lock()
+	if s.self[addr] || s.dialing[addr] {
+		s.mu.Unlock()
+		return nil
+	}
+	for _, p := range s.peers {
+		if p.addr == addr {
+			s.mu.Unlock()
+			return nil
+		}
+	}
+	if len(s.peers) >= s.config.MaxPeers {
+		s.mu.Unlock()
+		return errors.New("too many peers")
+	}
+	s.dialing[addr] = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.dialing, addr)
+		s.mu.Unlock()
+	}()
+
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return err
+	}
+	return s.setupPeer(conn, false, addr)
+}
+
+func (s *P2PServer) acceptLoop() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			select {
+			case <-s.quit:
+				return
+			default:
+			}
+			log.Printf("⚠️  P2P accept failed: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		go func() {
+			if err := s.setupPeer(conn, true, ""); err != nil {
+				log.Printf("⚠️  Rejected inbound peer %s: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// discoveryLoop keeps dialing known addresses and asking peers for more
+func (s *P2PServer) discoveryLoop() {
+	s.dialKnown()
+
+	ticker := time.NewTicker(discoveryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-ticker.C:
+			s.dialKnown()
+			s.broadcast(Message{Type: MsgGetPeers}, nil)
+		}
+	}
+}
+
+func (s *P2PServer) dialKnown() {
+	s.mu.RLock()
+	if len(s.peers) >= s.config.MaxPeers {
+		s.mu.RUnlock()
+		return
+	}
+	addrs := make([]string, 0, len(s.known))
+	for addr := range s.known {
+		addrs = append(addrs, addr)
+	}
+	s.mu.RUnlock()
+
+	for _, addr := range addrs {
+		go func(addr string) {
+			if err := s.Connect(addr); err != nil {
+				log.Printf("⚠️  Failed to connect to peer %s: %v", addr, err)
+			}
+		}(addr)
+	}
+}
+
+func (s *P2PServer) localStatus() StatusMessage {
+	s.chain.mu.RLock()
+	defer s.chain.mu.RUnlock()
+
+	port := 0
+	if s.listener != nil {
+		port = s.listener.Addr().(*net.TCPAddr).Port
+	}
+	head := s.chain.Blocks[len(s.chain.Blocks)-1]
+	return StatusMessage{
+		Version:     ProtocolVersion,
+		NodeID:      s.nodeID,
+		ChainID:     s.chain.Config.ChainID,
+		NetworkID:   s.chain.Config.NetworkID,
+		GenesisHash: s.chain.Blocks[0].Hash,
+		Height:      head.Index,
+		HeadHash:    head.Hash,
+		ListenPort:  port,
+		Lite:        s.chain.Lite,
+		PrunedBelow: s.chain.prunedBelow,
+		Client:      s.config.ClientVersion,
+		Location:    s.config.Location,
+	}
+}
+
+// setupPeer runs the handshake on a new connection and starts its loops
+func (s *P2PServer) setupPeer(conn net.Conn, inbound bool, dialAddr string) error {
+	reader := bufio.NewReaderSize(conn, 64*1024)
+	local := s.localStatus()
+
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := writeMessage(conn, MsgStatus, local); err != nil {
+		conn.Close()
+		return err
+	}
+	msg, err := readMessage(reader)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+
+	if msg.Type != MsgStatus {
+		conn.Close()
+		return errors.New("expected status message")
+	}
+	var remote StatusMessage
+	if err := json.Unmarshal(msg.Payload, &remote); err != nil {
+		conn.Close()
+		return err
+	}
+
+	if remote.NodeID == s.nodeID {
+		conn.Close()
+		if dialAddr != "" {
+			s.mu.Lock()
+			s.self[dialAddr] = true
+			delete(s.known, dialAddr)
+			s.mu.Unlock()
+		}
+		return errors.New("connected to self")
+	}
+	if err := checkStatus(local, remote); err != nil {
+		conn.Close()
+		return err
+	}
+
+	addr := dialAddr
+	if addr == "" {
+		host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+		addr = net.JoinHostPort(host, strconv.Itoa(remote.ListenPort))
+	}
+
+	peer := &Peer{
+		conn:        conn,
+		id:          remote.NodeID,
+		addr:        addr,
+		inbound:     inbound,
+		status:      remote,
+		send:        make(chan Message, peerSendQueueSize),
+		knownTxs:    newBoundedSet(knownCacheSize),
+		knownBlocks: newBoundedSet(knownCacheSize),
+		closed:      make(chan struct{}),
+		pending:     make(map[uint64]chan Message),
+	}
+
+	s.mu.Lock()
+	if _, exists := s.peers[peer.id]; exists {
+		s.mu.Unlock()
+		conn.Close()
+		return errors.New("already connected")
+	}
+	if len(s.peers) >= s.config.MaxPeers {
+		s.mu.Unlock()
+		conn.Close()
+		return errors.New("too many peers")
+	}
+	s.peers[peer.id] = peer
+	if remote.ListenPort > 0 {
+		s.known[addr] = true
+	}
+	s.mu.Unlock()
+
+	log.Printf("🤝 Peer connected: %s (%s, height %d)", shortID(peer.id), peer.addr, remote.Height)
+
+	go s.writeLoop(peer)
+	go s.readLoop(peer, reader)
+
+	peer.queue(Message{Type: MsgGetPeers})
+	if s.syncer != nil && remote.Height > local.Height {
+		s.syncer.Trigger()
+	}
+	return nil
+}
+
+// checkStatus rejects peers on a different chain
+func checkStatus(local, remote StatusMessage) error {
+	if remote.Version != local.Version {
+		return fmt.Errorf("protocol version mismatch: %d != %d", remote.Version, local.Version)
+	}
+	if remote.ChainID != local.ChainID {
+		return fmt.Errorf("chain ID mismatch: %d != %d", remote.ChainID, local.ChainID)
+	}
+	if remote.NetworkID != local.NetworkID {
+		return fmt.Errorf("network ID mismatch: %d != %d", remote.NetworkID, local.NetworkID)
+	}
+	if remote.GenesisHash != local.GenesisHash {
+		return fmt.Errorf("genesis mismatch: %s != %s", remote.GenesisHash, local.GenesisHash)
+	}
+	if remote.Client != "" {
+		if err := validateClientVersion(remote.Client); err != nil {
+			return fmt.Errorf("client version: %v", err)
+		}
+	}
+	if remote.Location != nil {
+		if err := remote.Location.validate(); err != nil {
+			return fmt.Errorf("location %v", err)
+		}
+	}
+	return nil
+}
+
+func (s *P2PServer) removePeer(peer *Peer) {
+	peer.close()
+	s.mu.Lock()
+	if s.peers[peer.id] == peer {
+		delete(s.peers, peer.id)
+		log.Printf("👋 Peer disconnected: %s (%s)", shortID(peer.id), peer.addr)
+	}
+	s.mu.Unlock()
+}
+
+func (s *P2PServer) writeLoop(peer *Peer) {
+	for {
+		select {
+		case <-peer.closed:
+			return
+		case msg := <-peer.send:
+			if err := writeRaw(peer.conn, msg); err != nil {
+				s.removePeer(peer)
+				return
+			}
+		}
+	}
+}
+
+func (s *P2PServer) readLoop(peer *Peer, reader *bufio.Reader) {
+	defer s.removePeer(peer)
+	for {
+		msg, err := readMessage(reader)
+		if err != nil {
+			return
+		}
+		if err := s.handleMessage(peer, msg); err != nil {
+			log.Printf("⚠️  Bad message from peer %s: %v", shortID(peer.id), err)
+			return
+		}
+	}
+}
+
+func (s *P2PServer) handleMessage(peer *Peer, msg Message) error {
+	switch msg.Type {
+	case MsgGetPeers:
+		peer.queue(newMessage(MsgPeers, s.advertisedPeers(peer)))
+
+	case MsgPeers:
+		var addrs []string
+		if err := json.Unmarshal(msg.Payload, &addrs); err != nil {
+			return err
+		}
+		s.learnPeers(addrs)
+
+	case MsgTx:
+		var tx Transaction
+		if err := json.Unmarshal(msg.Payload, &tx); err != nil {
+			return err
+		}
+		peer.knownTxs.Add(tx.Hash)
+		if s.chain.Lite {
+			// Lite nodes relay only their own transactions
+			return nil
+		}
+		// Invalid or duplicate gossip is expected and simply not relayed
+		s.chain.AddTransaction(tx)
+
+	case MsgBlock:
+		var block Block
+		if err := json.Unmarshal(msg.Payload, &block); err != nil {
+			return err
+		}
+		peer.knownBlocks.Add(block.Hash)
+		if s.syncer != nil && block.Index > s.chain.Height()+1 {
+			// We're missing blocks in between, so only the seal can be checked
+			// now; the sync manager fetches them and drops the peer if it
+			// cannot back the block up
+			if err := checkSeal(&block); err != nil {
+				return fmt.Errorf("invalid block #%d: %w", block.Index, err)
+			}
+			peer.updateHead(block.Index, block.Hash)
+			s.syncer.Trigger()
+			return nil
+		}
+		err := s.chain.AddBlock(block)
+		switch {
+		case err == nil || errors.Is(err, ErrKnownBlock):
+			peer.updateHead(block.Index, block.Hash)
+		case errors.Is(err, ErrUnknownParent), errors.Is(err, ErrUnknownValidatorSet):
+			if s.syncer == nil {
+				return nil
+			}
+			// The block is on a branch we haven't seen, or a lite node lacks
+			// the validator set to check it against: sync fetches both
+			if err := checkSeal(&block); err != nil {
+				return fmt.Errorf("invalid block #%d: %w", block.Index, err)
+			}
+			peer.updateHead(block.Index, block.Hash)
+			s.syncer.Trigger()
+		case errors.Is(err, ErrReorgTooDeep):
+			log.Printf("⚠️  Ignoring block #%d from peer %s: %v", block.Index, shortID(peer.id), err)
+		default:
+			// An invalid block disconnects the peer that sent it
+			return fmt.Errorf("invalid block #%d: %w", block.Index, err)
+		}
+
+	case MsgGetHeaders:
+		var req GetHeadersRequest
+		if err := json.Unmarshal(msg.Payload, &req); err != nil {
+			return err
+		}
+		if req.Count > maxHeadersServed {
+			req.Count = maxHeadersServed
+		}
+		reply := newMessage(MsgHeaders, s.chain.CanonicalHeaders(req.From, req.Count))
+		reply.ID = msg.ID
+		peer.queue(reply)
+
+	case MsgGetBodies:
+		var hashes []string
+		if err := json.Unmarshal(msg.Payload, &hashes); err != nil {
+			return err
+		}
+		if len(hashes) > maxBodiesServed {
+			hashes = hashes[:maxBodiesServed]
+		}
+		bodies := make([]BlockBody, 0, len(hashes))
+		for _, hash := range hashes {
+			// Lite nodes have no bodies to serve, pruned ones have lost theirs
+			if block, ok := s.chain.BlockByHash(hash); ok && s.chain.HasBody(block.Index) {
+				bodies = append(bodies, BlockBody{Hash: hash, Transactions: block.Transactions})
+			}
+		}
+		reply := newMessage(MsgBodies, bodies)
+		reply.ID = msg.ID
+		peer.queue(reply)
+
+	case MsgGetTxProof, MsgGetAccountProof:
+		var query string
+		if err := json.Unmarshal(msg.Payload, &query); err != nil {
+			return err
+		}
+		reply := newMessage(MsgTxProof, s.txProof(query))
+		if msg.Type == MsgGetAccountProof {
+			reply = newMessage(MsgAccountProof, s.accountProof(query))
+		}
+		reply.ID = msg.ID
+		peer.queue(reply)
+
+	case MsgGetValidatorSet:
+		var root string
+		if err := json.Unmarshal(msg.Payload, &root); err != nil {
+			return err
+		}
+		reply := newMessage(MsgValidatorSet, s.validatorSet(root))
+		reply.ID = msg.ID
+		peer.queue(reply)
+
+	case MsgHeaders, MsgBodies, MsgTxProof, MsgAccountProof, MsgValidatorSet:
+		// Responses are routed to whoever is waiting on the request
+		peer.deliver(msg)
+
+	default:
+		// Unknown messages are ignored for forward compatibility
+	}
+	return nil
+}
+
+func (s *P2PServer) advertisedPeers(exclude *Peer) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	addrs := make([]string, 0)
+	for _, p := range s.peers {
+		if p == exclude || p.getStatus().ListenPort == 0 {
+			continue
+		}
+		addrs = append(addrs, p.addr)
+		if len(addrs) >= maxAdvertisedPeers {
+			break
+		}
+	}
+	return addrs
+}
+
+func (s *P2PServer) learnPeers(addrs []string) {
+	s.mu.Lock()
+	fresh := make([]string, 0)
+	for _, addr := range addrs {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			continue
+		}
+		if !s.known[addr] && !s.self[addr] && len(s.known) < 4*s.config.MaxPeers {
+			s.known[addr] = true
+			fresh = append(fresh, addr)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, addr := range fresh {
+		go s.Connect(addr)
+	}
+}
+
+// broadcast queues a message for every peer the filter accepts
+func (s *P2PServer) broadcast(msg Message, filter func(*Peer) bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, p := range s.peers {
+		if filter == nil || filter(p) {
+			p.queue(msg)
+		}
+	}
+}
+
+// OnNewBlock gossips a block accepted by the chain to peers that lack it
+func (s *P2PServer) OnNewBlock(block Block) {
+	// Blocks without bodies would be rejected by full peers
+	if s.chain.Lite {
+		return
+	}
+	msg := newMessage(MsgBlock, block)
+	s.broadcast(msg, func(p *Peer) bool {
+		return p.knownBlocks.Add(block.Hash)
+	})
+}
+
+// OnNewTransaction gossips a transaction accepted into the pool to peers that lack it
+func (s *P2PServer) OnNewTransaction(tx Transaction) {
+	msg := newMessage(MsgTx, tx)
+	s.broadcast(msg, func(p *Peer) bool {
+		return p.knownTxs.Add(tx.Hash)
+	})
+}
+
+// OnReorg needs no action: blocks of the new branch are gossiped through OnNewBlock
+func (s *P2PServer) OnReorg(event ReorgEvent) {}
+
+// BestPeer returns the full peer with the highest advertised head. Lite peers
+// cannot serve bodies, nor can peers that pruned those after our head.
+func (s *P2PServer) BestPeer() *Peer {
+	lite := s.chain.Lite
+	height := s.chain.Height()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var best *Peer
+	for _, p := range s.peers {
+		if status := p.getStatus(); status.Lite || !lite && status.PrunedBelow > height+1 {
+			continue
+		}
+		if best == nil || p.getStatus().Height > best.getStatus().Height {
+			best = p
+		}
+	}
+	return best
+}
+
+// Request sends a message to peer and waits for the response with the same ID
+func (p *Peer) Request(msgType string, payload interface{}) (Message, error) {
+	msg := newMessage(msgType, payload)
+	reply := make(chan Message, 1)
+
+	p.mu.Lock()
+	p.nextID++
+	msg.ID = p.nextID
+	p.pending[msg.ID] = reply
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		delete(p.pending, msg.ID)
+		p.mu.Unlock()
+	}()
+
+	p.queue(msg)
+
+	select {
+	case resp := <-reply:
+		return resp, nil
+	case <-p.closed:
+		return Message{}, errors.New("peer disconnected")
+	case <-time.After(requestTimeout):
+		return Message{}, errors.New("request timed out")
+	}
+}
+
+// deliver hands a response to the request waiting on its ID, if any
+func (p *Peer) deliver(msg Message) {
+	p.mu.Lock()
+	reply, ok := p.pending[msg.ID]
+	p.mu.Unlock()
+	if ok {
+		select {
+		case reply <- msg:
+		default:
+		}
+	}
+}
+
+// ID returns the peer's node ID
+func (p *Peer) ID() string {
+	return p.id
+}
+
+// Height returns the peer's last known head height
+func (p *Peer) Height() int64 {
+	return p.getStatus().Height
+}
+
+// HeadHash returns the hash of the peer's last known head
+func (p *Peer) HeadHash() string {
+	return p.getStatus().HeadHash
+}
+
+// queue sends a message without blocking, dropping it if the peer is backed up
+func (p *Peer) queue(msg Message) {
+	select {
+	case p.send <- msg:
+	case <-p.closed:
+	default:
+		log.Printf("⚠️  Send queue full for peer %s, dropping %s", shortID(p.id), msg.Type)
+	}
+}
+
+func (p *Peer) close() {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+		p.conn.Close()
+	})
+}
+
+func (p *Peer) getStatus() StatusMessage {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.status
+}
+
+func (p *Peer) updateHead(height int64, hash string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if height > p.status.Height {
+		p.status.Height = height
+		p.status.HeadHash = hash
+	}
+}
+
+func newMessage(msgType string, payload interface{}) Message {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("⚠️  Failed to encode %s message: %v", msgType, err)
+	}
+	return Message{Type: msgType, Payload: raw}
+}
+
+func writeMessage(conn net.Conn, msgType string, payload interface{}) error {
+	return writeRaw(conn, newMessage(msgType, payload))
+}
+
+func writeRaw(conn net.Conn, msg Message) error {
+	raw, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(append(raw, '\n'))
+	return err
+}
+
+func readMessage(reader *bufio.Reader) (Message, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := reader.ReadLine()
+		if err != nil {
+			return Message{}, err
+		}
+		line = append(line, chunk...)
+		if len(line) > maxMessageSize {
+			return Message{}, errors.New("message too large")
+		}
+		if !isPrefix {
+			break
+		}
+	}
+	var msg Message
+	if err := json.Unmarshal(line, &msg); err != nil {
+		return Message{}, err
+	}
+	return msg, nil
+}
+
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+// boundedSet remembers up to size recent keys
+type boundedSet struct {
+	keys  map[string]bool
+	order []string
+	size  int
+	mu    sync.Mutex
+}
+
+func newBoundedSet(size int) *boundedSet {
+	return &boundedSet{keys: make(map[string]bool), size: size}
+}
+
+// Add inserts key and reports whether it was not already present
+func (b *boundedSet) Add(key string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.keys[key] {
+		return false
+	}
+	if len(b.order) >= b.size {
+		delete(b.keys, b.order[0])
+		b.order = b.order[1:]
+	}
+	b.keys[key] = true
+	b.order = append(b.order, key)
+	return true
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"gydschain/client"
+)
+
+// testServer starts a loopback P2P server for bc, bootstrapping from the
+// given addresses, and stops it when the test ends
+func testServer(t *testing.T, bc *Blockchain, bootstrap ...string) *P2PServer {
+	t.Helper()
+	server := NewP2PServer(bc, P2PConfig{ListenAddr: "127.0.0.1:0", BootstrapNodes: bootstrap})
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Stop)
+	return server
+}
+
+// testSyncedServer is testServer with a sync manager for bc, attached before
+// the server starts as the node does
+func testSyncedServer(t *testing.T, bc *Blockchain, config SyncConfig, bootstrap ...string) (*P2PServer, *SyncManager) {
+	t.Helper()
+	server := NewP2PServer(bc, P2PConfig{ListenAddr: "127.0.0.1:0", BootstrapNodes: bootstrap})
+	syncer := NewSyncManager(bc, server, config)
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Stop)
+	go syncer.Run()
+	return server, syncer
+}
+
+// waitFor polls cond until it holds or a few seconds have passed
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// sealedBlock builds a POW block on parent from the template and finds its nonce
+func sealedBlock(bc *Blockchain, parent Block, template Block) Block {
+	template.Index = parent.Index + 1
+	template.Timestamp = parent.Timestamp + 1
+	template.PreviousHash = parent.Hash
+	template.Difficulty = bc.CurrentDiff
+	template.Type = "POW"
+	block, _ := searchNonce(bc.buildBlock(template), 1, func() bool { return false })
+	return block
+}
+
+func TestInvalidGossipedBlockDisconnectsPeer(t *testing.T) {
+	genesis := testGenesis(t)
+	sender := testServer(t, testChain(t, genesis))
+	chain := testChain(t, genesis)
+	receiver := testServer(t, chain, sender.ListenAddr())
+	waitFor(t, "handshake", func() bool { return sender.PeerCount() == 1 })
+
+	// A well-sealed block paying itself the wrong reward
+	bad := sealedBlock(chain, chain.Blocks[0], Block{
+		Miner:  "0x1111111111111111111111111111111111111111",
+		Reward: "1",
+	})
+	sender.broadcast(newMessage(MsgBlock, bad), nil)
+
+	waitFor(t, "disconnect", func() bool { return receiver.PeerCount() == 0 })
+	if chain.Height() != 0 {
+		t.Fatalf("height = %d, want 0", chain.Height())
+	}
+}
+
+func TestHandshakeRejectsOtherChains(t *testing.T) {
+	genesis := testGenesis(t)
+	server := testServer(t, testChain(t, genesis))
+
+	otherChain := testGenesis(t)
+	otherChain.Config.ChainID++
+	otherGenesis := testGenesis(t)
+	otherGenesis.ExtraData = "another chain"
+	for want, g := range map[string]*Genesis{"chain ID mismatch": otherChain, "genesis mismatch": otherGenesis} {
+		stranger := testServer(t, testChain(t, g))
+		if err := stranger.Connect(server.ListenAddr()); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Connect = %v, want %s", err, want)
+		}
+		if stranger.PeerCount() != 0 {
+			t.Errorf("%s: stranger kept the connection", want)
+		}
+	}
+	if server.PeerCount() != 0 {
+		t.Fatalf("server has %d peers from other chains", server.PeerCount())
+	}
+}
+
+func TestPeersReportVersionAndLocation(t *testing.T) {
+	genesis := testGenesis(t)
+	location := &Location{Region: "eu-west-1", Country: "IE", Latitude: 53.35, Longitude: -6.26}
+	server := NewP2PServer(testChain(t, genesis), P2PConfig{ListenAddr: "127.0.0.1:0", ClientVersion: "2.0.0", Location: location})
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Stop)
+	client := testServer(t, testChain(t, genesis), server.ListenAddr())
+
+	waitFor(t, "connection", func() bool { return client.PeerCount() == 1 })
+	peer := client.Peers()[0]
+	if peer.Client != "2.0.0" || peer.Location == nil || *peer.Location != *location {
+		t.Fatalf("peer reports client %q, location %+v", peer.Client, peer.Location)
+	}
+
+	// A peer making up an implausible location is refused
+	liar := NewP2PServer(testChain(t, genesis), P2PConfig{ListenAddr: "127.0.0.1:0", Location: &Location{Latitude: 200}})
+	if err := liar.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(liar.Stop)
+	if err := client.Connect(liar.ListenAddr()); err == nil || !strings.Contains(err.Error(), "latitude") {
+		t.Fatalf("Connect = %v, want a latitude error", err)
+	}
+}
+
+func TestBlocksAndTransactionsGossip(t *testing.T) {
+	key, sender := testKey(t)
+	genesis := testGenesis(t)
+	genesis.Alloc = map[string]GenesisAccount{sender: {Balance: "10000000000000000000"}}
+	miner, relay, wallet := testChain(t, genesis), testChain(t, genesis), testChain(t, genesis)
+	minerServer := testServer(t, miner)
+	relayServer := testServer(t, relay, minerServer.ListenAddr())
+	walletServer := testServer(t, wallet, relayServer.ListenAddr())
+	waitFor(t, "handshakes", func() bool {
+		return minerServer.PeerCount() > 0 && walletServer.PeerCount() > 0
+	})
+
+	// A transaction sent to one node reaches the miner...
+	tx := signedTx(t, client.NewTransfer(sender, "0x2222222222222222222222222222222222222222", big.NewInt(5), 0), key)
+	if err := wallet.AddTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "transaction gossip", func() bool {
+		lookup, ok := miner.TransactionByHash(tx.Hash)
+		return ok && lookup.Pending
+	})
+
+	// ...and the block that mines it reaches every node
+	mineBlocks(t, miner, "0x1111111111111111111111111111111111111111", 1)
+	head := miner.Blocks[1]
+	if len(head.Transactions) != 1 {
+		t.Fatalf("mined %d transactions, want 1", len(head.Transactions))
+	}
+	for _, chain := range []*Blockchain{relay, wallet} {
+		waitFor(t, "block gossip", func() bool { return chain.HasBlock(head.Hash) && chain.Height() == 1 })
+		if lookup, ok := chain.TransactionByHash(tx.Hash); !ok || lookup.Pending {
+			t.Fatalf("transaction not confirmed on a peer: %+v", lookup)
+		}
+	}
+}
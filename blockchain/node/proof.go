@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+// Errors returned when a proof does not check out
+var (
+	ErrProofHeader  = errors.New("proof is for a different block")
+	ErrInvalidProof = errors.New("invalid proof")
+)
+
+// TxProof shows that a transaction is included in a block. A light client
+// that trusts the header checks it by hashing the transaction up the branch
+// to the header's transactions root, and the receipt up its own branch to
+// the receipts root.
+type TxProof struct {
+	Transaction   Transaction  `json:"transaction"`
+	Index         int          `json:"index"`
+	Header        BlockHeader  `json:"header"`
+	Branch        []MerkleStep `json:"branch"`
+	Receipt       Receipt      `json:"receipt"`
+	ReceiptBranch []MerkleStep `json:"receiptBranch"`
+}
+
+// AccountProof shows the balance and nonce of an address at a block, or that
+// the address has no account there. It carries the account's leaf, or when
+// the account does not exist the leaves either side of where it would be.
+type AccountProof struct {
+	Address  string        `json:"address"`
+	Header   BlockHeader   `json:"header"`
+	Accounts int           `json:"accounts"` // leaves in the state tree
+	Leaves   []AccountLeaf `json:"leaves"`
+}
+
+// AccountLeaf is one account in the state tree with its Merkle branch
+type AccountLeaf struct {
+	Address     string          `json:"address"`
+	Balance     string          `json:"balance"`
+	Nonce       int64           `json:"nonce"`
+	Validator   *ValidatorState `json:"validator,omitempty"`
+	Unbonding   []Unbonding     `json:"unbonding,omitempty"`
+	Pool        *DelegationPool `json:"pool,omitempty"`
+	Delegations []Delegation    `json:"delegations,omitempty"`
+	Index       int             `json:"index"`
+	Branch      []MerkleStep    `json:"branch"`
+}
+
+// TxProof returns the inclusion proof of a transaction mined on the
+// canonical chain
+func (bc *Blockchain) TxProof(hash string) (TxProof, bool) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	receipt, ok := bc.receipts[hash]
+	if !ok {
+		return TxProof{}, false
+	}
+	block := &bc.Blocks[receipt.BlockNumber]
+	leaves := make([][]byte, len(block.Transactions))
+	for i := range block.Transactions {
+		leaves[i] = encodeTransaction(&block.Transactions[i])
+	}
+	receipts := bc.blockReceipts(block)
+	receiptLeaves := make([][]byte, len(receipts))
+	for i := range receipts {
+		receiptLeaves[i] = encodeReceipt(&receipts[i])
+	}
+	return TxProof{
+		Transaction:   block.Transactions[receipt.Index],
+		Index:         receipt.Index,
+		Header:        block.Header(),
+		Branch:        merkleBranch(leaves, receipt.Index),
+		Receipt:       receipt,
+		ReceiptBranch: merkleBranch(receiptLeaves, receipt.Index),
+	}, true
+}
+
+// AccountProof proves an address's account against the state root of the
+// head block
+func (bc *Blockchain) AccountProof(address string) AccountProof {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	address = normalizeAddress(address)
+	addresses := bc.State.sortedAddresses()
+	leaves := make([][]byte, len(addresses))
+	for i, addr := range addresses {
+		leaves[i] = encodeAccount(addr, bc.State.accounts[addr])
+	}
+
+	// The account itself, or its neighbours if it does not exist
+	i := sort.SearchStrings(addresses, address)
+	indexes := []int{i}
+	if i == len(addresses) || addresses[i] != address {
+		indexes = []int{i - 1, i}
+	}
+
+	proof := AccountProof{
+		Address:  address,
+		Header:   bc.Blocks[len(bc.Blocks)-1].Header(),
+		Accounts: len(addresses),
+		Leaves:   []AccountLeaf{},
+	}
+	for _, index := range indexes {
+		if index < 0 || index >= len(addresses) {
+			continue
+		}
+		acct := bc.State.accounts[addresses[index]].copy()
+		proof.Leaves = append(proof.Leaves, AccountLeaf{
+			Address:     addresses[index],
+			Balance:     acct.Balance.String(),
+			Nonce:       acct.Nonce,
+			Validator:   acct.Validator,
+			Unbonding:   acct.Unbonding,
+			Pool:        acct.Pool,
+			Delegations: acct.Delegations,
+			Index:       index,
+			Branch:      merkleBranch(leaves, index),
+		})
+	}
+	return proof
+}
+
+// verifyTxProof checks a transaction proof against a trusted header. The
+// receipt fields its Merkle leaf leaves out are filled in from the header and
+// the transaction.
+func verifyTxProof(proof *TxProof, header *BlockHeader) error {
+	if proof.Header.Hash != header.Hash {
+		return ErrProofHeader
+	}
+	tx := &proof.Transaction
+	if tx.Hash != TransactionHash(tx) {
+		return fmt.Errorf("%w: transaction hash mismatch", ErrInvalidProof)
+	}
+	if root, err := merkleBranchRoot(encodeTransaction(tx), proof.Branch); err != nil || root != header.TxRoot {
+		return fmt.Errorf("%w: transaction is not under the transactions root", ErrInvalidProof)
+	}
+
+	receipt := &proof.Receipt
+	if receipt.TxHash != tx.Hash || receipt.Index != proof.Index {
+		return fmt.Errorf("%w: receipt is for another transaction", ErrInvalidProof)
+	}
+	if root, err := merkleBranchRoot(encodeReceipt(receipt), proof.ReceiptBranch); err != nil || root != header.ReceiptsRoot {
+		return fmt.Errorf("%w: receipt is not under the receipts root", ErrInvalidProof)
+	}
+	receipt.BlockHash = header.Hash
+	receipt.BlockNumber = header.Index
+	receipt.From = tx.From
+	receipt.To = tx.To
+	return nil
+}
+
+// verifyAccountProof checks an account proof against a trusted header and
+// returns the proven account, empty if it does not exist
+func verifyAccountProof(proof *AccountProof, address string, header *BlockHeader) (AccountState, error) {
+	empty := AccountState{Balance: big.NewInt(0)}
+	if proof.Header.Hash != header.Hash {
+		return empty, ErrProofHeader
+	}
+	address = normalizeAddress(address)
+
+	var proven []AccountLeaf
+	for _, leaf := range proof.Leaves {
+		balance, err := parseAmount(leaf.Balance)
+		if err != nil || leaf.Index < 0 || leaf.Index >= proof.Accounts {
+			return empty, ErrInvalidProof
+		}
+		// The branch must have the shape of leaf Index, so that positions
+		// can be trusted when proving absence
+		shape := merkleShape(leaf.Index, proof.Accounts)
+		if len(shape) != len(leaf.Branch) {
+			return empty, ErrInvalidProof
+		}
+		for i, left := range shape {
+			if leaf.Branch[i].Left != left {
+				return empty, ErrInvalidProof
+			}
+		}
+		acct := &AccountState{
+			Balance:     balance,
+			Nonce:       leaf.Nonce,
+			Validator:   leaf.Validator,
+			Unbonding:   leaf.Unbonding,
+			Pool:        leaf.Pool,
+			Delegations: leaf.Delegations,
+		}
+		if !acct.complete() {
+			return empty, ErrInvalidProof
+		}
+		root, err := merkleBranchRoot(encodeAccount(leaf.Address, acct), leaf.Branch)
+		if err != nil || sizedRoot(proof.Accounts, root) != header.StateRoot {
+			return empty, fmt.Errorf("%w: account is not under the state root", ErrInvalidProof)
+		}
+		if leaf.Address == address {
+			return *acct, nil
+		}
+		proven = append(proven, leaf)
+	}
+
+	// Absent: the leaves must be the neighbours on either side of address
+	switch {
+	case proof.Accounts == 0 && len(proven) == 0:
+		return empty, nil
+	case len(proven) == 1 && proven[0].Index == 0 && address < proven[0].Address:
+		return empty, nil
+	case len(proven) == 1 && proven[0].Index == proof.Accounts-1 && address > proven[0].Address:
+		return empty, nil
+	case len(proven) == 2 && proven[1].Index == proven[0].Index+1 &&
+		proven[0].Address < address && address < proven[1].Address:
+		return empty, nil
+	}
+	return empty, fmt.Errorf("%w: account neither proven present nor absent", ErrInvalidProof)
+}
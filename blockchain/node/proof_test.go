@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"gydschain/client"
+)
+
+// clientCopy converts a node value into its client mirror through JSON
+func clientCopy(t *testing.T, from, to interface{}) {
+	t.Helper()
+	raw, _ := json.Marshal(from)
+	if err := json.Unmarshal(raw, to); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTxProofsVerifyAgainstHeader(t *testing.T) {
+	bc, _ := minedTransfers(t, 3)
+	block := bc.Blocks[1]
+	var header client.BlockHeader
+	clientCopy(t, block.Header(), &header)
+
+	for i, tx := range block.Transactions {
+		nodeProof, ok := bc.TxProof(tx.Hash)
+		if !ok || nodeProof.Index != i {
+			t.Fatalf("no proof for transaction %d", i)
+		}
+		var proof client.TxProof
+		clientCopy(t, nodeProof, &proof)
+		if err := client.VerifyTxProof(&proof, &header); err != nil {
+			t.Fatalf("transaction %d: %v", i, err)
+		}
+	}
+
+	// Proofs that do not check out
+	nodeProof, _ := bc.TxProof(block.Transactions[1].Hash)
+	otherProof, _ := bc.TxProof(block.Transactions[0].Hash)
+	var genesisHeader client.BlockHeader
+	clientCopy(t, bc.Blocks[0].Header(), &genesisHeader)
+	for name, tamper := range map[string]func(*client.TxProof, *client.BlockHeader){
+		"header": func(p *client.TxProof, h *client.BlockHeader) { *h = genesisHeader },
+		"root":   func(p *client.TxProof, h *client.BlockHeader) { h.TxRoot = genesisHeader.TxRoot },
+		"value":  func(p *client.TxProof, h *client.BlockHeader) { p.Transaction.Value = "6" },
+		"signature": func(p *client.TxProof, h *client.BlockHeader) {
+			p.Transaction.Signature = otherProof.Transaction.Signature
+		},
+		"branch": func(p *client.TxProof, h *client.BlockHeader) { p.Branch[0].Left = !p.Branch[0].Left },
+	} {
+		var proof client.TxProof
+		trusted := header
+		clientCopy(t, nodeProof, &proof)
+		tamper(&proof, &trusted)
+		if err := client.VerifyTxProof(&proof, &trusted); err == nil {
+			t.Errorf("%s tampered: proof accepted", name)
+		}
+	}
+
+	if _, ok := bc.TxProof(bc.Blocks[0].Hash); ok {
+		t.Fatal("proof for a block hash")
+	}
+}
+
+func TestAccountProofs(t *testing.T) {
+	bc, _ := minedTransfers(t, 3)
+	header := bc.Blocks[1].Header()
+	sender := bc.Blocks[1].Transactions[0].From
+
+	proof := bc.AccountProof(sender)
+	acct, err := verifyAccountProof(&proof, sender, &header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acct.Balance.Cmp(bc.State.GetBalance(sender)) != 0 || acct.Nonce != 3 {
+		t.Fatalf("proven account balance %s nonce %d", acct.Balance, acct.Nonce)
+	}
+	tampered := proof
+	tampered.Leaves = append([]AccountLeaf(nil), proof.Leaves...)
+	tampered.Leaves[0].Balance = "1"
+	if _, err := verifyAccountProof(&tampered, sender, &header); !errors.Is(err, ErrInvalidProof) {
+		t.Fatalf("tampered balance: %v, want %v", err, ErrInvalidProof)
+	}
+
+	// Absence is proven by the neighbours either side, or the edge leaf
+	for _, absent := range []string{
+		"0x0000000000000000000000000000000000000000",
+		"0x00000000000000000000000000000000000000ff",
+		"0xffffffffffffffffffffffffffffffffffffffff",
+	} {
+		proof := bc.AccountProof(absent)
+		acct, err := verifyAccountProof(&proof, absent, &header)
+		if err != nil || acct.Balance.Sign() != 0 {
+			t.Fatalf("%s: %v, balance %s", absent, err, acct.Balance)
+		}
+		// Leaving out a neighbour proves nothing
+		if len(proof.Leaves) == 2 {
+			proof.Leaves = proof.Leaves[1:]
+			if _, err := verifyAccountProof(&proof, absent, &header); !errors.Is(err, ErrInvalidProof) {
+				t.Fatalf("%s with one neighbour: %v, want %v", absent, err, ErrInvalidProof)
+			}
+		}
+	}
+
+	// A proof of absence cannot hide an account
+	proof = bc.AccountProof("0x00000000000000000000000000000000000000ff")
+	if _, err := verifyAccountProof(&proof, "0x0000000000000000000000000000000000000002", &header); !errors.Is(err, ErrInvalidProof) {
+		t.Fatalf("absence proof for an existing account: %v, want %v", err, ErrInvalidProof)
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+)
+
+// ErrWrongProposer is returned for a POS block produced by a validator other
+// than the one selected for its slot
+var ErrWrongProposer = errors.New("block produced by the wrong validator")
+
+// ErrBadBlockSignature is returned for a POS block not signed by its validator
+var ErrBadBlockSignature = errors.New("invalid validator signature")
+
+// ErrUnknownValidatorSet is returned for a POS block whose parent commits to
+// a validator set this node does not have
+var ErrUnknownValidatorSet = errors.New("validator set unknown")
+
+// maxFutureBlockTime is how far ahead of the local clock a block's timestamp
+// may be. Timestamps decide the proposer round, so they cannot run ahead.
+const maxFutureBlockTime = 15
+
+// proposerSeed is the randomness a slot's proposer is drawn with: the hash of
+// the parent block, the new height and the round. Every node derives the same
+// seed, and nobody can predict it before the parent block exists.
+func proposerSeed(parent *Block, round int64) *big.Int {
+	data := appendHash(nil, parent.Hash)
+	data = binary.BigEndian.AppendUint64(data, uint64(parent.Index+1))
+	data = binary.BigEndian.AppendUint64(data, uint64(round))
+	sum := sha256.Sum256(data)
+	return new(big.Int).SetBytes(sum[:])
+}
+
+// slotRound returns the proposer round a block with the given timestamp falls
+// in. Each slot timeout that passes after parent without a POS block hands
+// the slot to a newly drawn proposer.
+func slotRound(timestamp int64, parent *Block, slotTimeout int64) int64 {
+	if slotTimeout <= 0 || timestamp <= parent.Timestamp {
+		return 0
+	}
+	return (timestamp - parent.Timestamp) / slotTimeout
+}
+
+// expectedProposer returns the validator entitled to produce the POS block on
+// top of parent in round. Active validators are ordered by address and one is
+// drawn with probability proportional to its effective stake, its own plus
+// the stake delegated to it. It returns "" if no active validator has stake.
+func expectedProposer(parent *Block, validators map[string]Validator, round int64) string {
+	type candidate struct {
+		address string
+		stake   *big.Int
+	}
+	candidates := []candidate{}
+	total := big.NewInt(0)
+	for _, val := range validators {
+		stake, err := parseAmount(val.EffectiveStake)
+		if !val.Active || err != nil || stake.Sign() == 0 {
+			continue
+		}
+		candidates = append(candidates, candidate{val.Address, stake})
+		total.Add(total, stake)
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return normalizeAddress(candidates[i].address) < normalizeAddress(candidates[j].address)
+	})
+
+	target := proposerSeed(parent, round)
+	target.Mod(target, total)
+	for _, c := range candidates {
+		if target.Cmp(c.stake) < 0 {
+			return c.address
+		}
+		target.Sub(target, c.stake)
+	}
+	return candidates[len(candidates)-1].address
+}
+
+// nextProposer returns the validator entitled to a POS block on the head at
+// time now. Callers must hold bc.mu.
+func (bc *Blockchain) nextProposer(now int64) string {
+	head := bc.Blocks[len(bc.Blocks)-1]
+	return expectedProposer(&head, bc.Validators, slotRound(now, &head, bc.Config.SlotTimeout))
+}
+
+// checkProposer rejects a POS block whose validator is not the one selected
+// for its slot and round, or that the validator did not sign. Both are
+// checked against the validator set parent commits to, which lite nodes
+// fetch from peers. Callers must hold bc.mu.
+func (bc *Blockchain) checkProposer(block, parent *Block) error {
+	if block.Type != "POS" {
+		return nil
+	}
+	validators, ok := bc.validatorSet(parent.ValidatorsRoot)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownValidatorSet, shortID(parent.ValidatorsRoot))
+	}
+	round := slotRound(block.Timestamp, parent, bc.Config.SlotTimeout)
+	expected := expectedProposer(parent, validators, round)
+	if !addressesEqual(block.Validator, expected) {
+		return fmt.Errorf("%w: got %s, expected %s", ErrWrongProposer, block.Validator, expected)
+	}
+	return verifyBlockSignature(block, validators[expected].PublicKey)
+}
+
+// proposerSet returns the validator set a header commits to: the active
+// validators with stake, ordered by address and reduced to what proposer
+// selection and block signatures use
+func proposerSet(validators map[string]Validator) []Validator {
+	set := []Validator{}
+	for _, val := range validators {
+		stake, err := parseAmount(val.EffectiveStake)
+		if !val.Active || err != nil || stake.Sign() == 0 {
+			continue
+		}
+		set = append(set, Validator{
+			Address:        normalizeAddress(val.Address),
+			PublicKey:      val.PublicKey,
+			EffectiveStake: stake.String(),
+			Active:         true,
+		})
+	}
+	sort.Slice(set, func(i, j int) bool { return set[i].Address < set[j].Address })
+	return set
+}
+
+func validatorMap(set []Validator) map[string]Validator {
+	validators := make(map[string]Validator, len(set))
+	for _, val := range set {
+		validators[normalizeAddress(val.Address)] = val
+	}
+	return validators
+}
+
+// validatorSet returns the validator set of a header's validators root, if
+// this node has it. Callers must hold bc.mu.
+func (bc *Blockchain) validatorSet(root string) (map[string]Validator, bool) {
+	if !bc.Lite && root == bc.Blocks[len(bc.Blocks)-1].ValidatorsRoot {
+		return bc.Validators, true
+	}
+	set, ok := bc.storedValidatorSet(root)
+	if !ok {
+		return nil, false
+	}
+	return validatorMap(set), true
+}
+
+// storedValidatorSet looks up a recorded validator set by its root. Callers
+// must hold bc.mu.
+func (bc *Blockchain) storedValidatorSet(root string) ([]Validator, bool) {
+	if set, ok := bc.validatorSets[root]; ok {
+		return set, true
+	}
+	if bc.store == nil {
+		return nil, false
+	}
+	var set []Validator
+	if err := getJSON(bc.store, validatorSetKey(root), &set); err != nil {
+		return nil, false
+	}
+	return set, true
+}
+
+// updateValidators sets bc.Validators to the validators after the head. Full
+// nodes read them from the state and record the head's validator set for
+// lite peers; a lite node uses the set the head commits to, if it has
+// fetched it. Callers must hold bc.mu.
+func (bc *Blockchain) updateValidators() {
+	root := bc.Blocks[len(bc.Blocks)-1].ValidatorsRoot
+	if bc.Lite {
+		set, _ := bc.storedValidatorSet(root)
+		bc.Validators = validatorMap(set)
+		return
+	}
+	bc.Validators = bc.State.Validators()
+	if _, ok := bc.storedValidatorSet(root); !ok {
+		bc.validatorSets[root] = proposerSet(bc.Validators)
+	}
+}
+
+// ValidatorSet returns the validator set of a validators root, for serving
+// lite peers
+func (bc *Blockchain) ValidatorSet(root string) ([]Validator, bool) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	validators, ok := bc.validatorSet(root)
+	if !ok {
+		return nil, false
+	}
+	return proposerSet(validators), true
+}
+
+// HasValidatorSet reports whether the validator set of root is at hand
+func (bc *Blockchain) HasValidatorSet(root string) bool {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	_, ok := bc.validatorSet(root)
+	return ok
+}
+
+// AddValidatorSet records a validator set fetched from a peer, which must
+// hash to root
+func (bc *Blockchain) AddValidatorSet(root string, set []Validator) error {
+	set = proposerSet(validatorMap(set))
+	if validatorsRoot(set) != root {
+		return errors.New("validator set does not match its root")
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.validatorSets[root] = set
+	if bc.Lite && root == bc.Blocks[len(bc.Blocks)-1].ValidatorsRoot {
+		bc.Validators = validatorMap(set)
+	}
+	return nil
+}
+
+// signBlock signs a POS block's hash with the validator key
+func signBlock(block *Block, key *ecdsa.PrivateKey) error {
+	signature, err := SignHash(key, block.Hash)
+	if err != nil {
+		return err
+	}
+	block.Signature = signature
+	return nil
+}
+
+// verifyBlockSignature checks that a POS block's hash is signed by the
+// validator's registered public key
+func verifyBlockSignature(block *Block, publicKeyHex string) error {
+	publicKey, err := DecodePublicKey(publicKeyHex)
+	if err != nil {
+		return fmt.Errorf("%w: validator %s has no usable public key", ErrBadBlockSignature, block.Validator)
+	}
+	if err := VerifyHashSignature(publicKey, block.Hash, block.Signature); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadBlockSignature, err)
+	}
+	return nil
+}
+
+// checkValidatorKey checks that publicKeyHex is a valid public key for address
+func checkValidatorKey(address, publicKeyHex string) error {
+	publicKey, err := DecodePublicKey(publicKeyHex)
+	if err != nil {
+		return err
+	}
+	if !addressesEqual(PublicKeyToAddress(publicKey), address) {
+		return errors.New("public key does not match address")
+	}
+	return nil
+}
+
+func addressesEqual(a, b string) bool {
+	return a != "" && normalizeAddress(a) == normalizeAddress(b)
+}
+
+// loadSigningKey loads the key this node signs POS blocks with, creating it
+// on first start, and sets the validator it mints for: address, or the key's
+// own address if empty. Without a key file the node does not mint.
+func loadSigningKey(path, address string) error {
+	if path == "" {
+		log.Printf("⚠️  No security.private_key_file set, POS blocks will not be minted")
+		return nil
+	}
+	key, created, err := LoadKeyFile(path)
+	if err != nil {
+		return err
+	}
+	signingKey = key
+	validatorAddress = address
+	if validatorAddress == "" {
+		validatorAddress = PrivateKeyToAddress(key)
+	}
+	if created {
+		log.Printf("🔑 Generated validator key %s", path)
+	}
+	log.Printf("🔑 Validator %s (public key %s)", validatorAddress, EncodePublicKey(&key.PublicKey))
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestForgedBlockSignatureRejected(t *testing.T) {
+	validatorKey, _ := testKey(t)
+	otherKey, funded := testKey(t)
+	genesis := validatorGenesis(t, validatorKey, funded)
+	source := testChain(t, genesis)
+	mintBlock(t, source, validatorKey)
+	genuine := source.Blocks[1]
+
+	forged := genuine
+	if err := signBlock(&forged, otherKey); err != nil {
+		t.Fatal(err)
+	}
+	unsigned := genuine
+	unsigned.Signature = ""
+
+	full := testChain(t, genesis)
+	lite, err := openBlockchain(NewMemoryStore(), genesis, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, chain := range []*Blockchain{full, lite} {
+		if err := chain.AddBlock(forged); !errors.Is(err, ErrBadBlockSignature) {
+			t.Fatalf("lite=%v: AddBlock = %v, want %v", chain.Lite, err, ErrBadBlockSignature)
+		}
+		if err := chain.AddBlock(unsigned); err == nil {
+			t.Fatalf("lite=%v: unsigned POS block accepted", chain.Lite)
+		}
+	}
+
+	// Also on a side branch whose validator set is at hand
+	mineBlocks(t, full, "0x1111111111111111111111111111111111111111", 1)
+	if err := full.AddBlock(forged); !errors.Is(err, ErrBadBlockSignature) {
+		t.Fatalf("side block AddBlock = %v, want %v", err, ErrBadBlockSignature)
+	}
+
+	if err := full.AddBlock(genuine); err != nil {
+		t.Fatal(err)
+	}
+	if err := lite.AddBlock(genuine); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWrongProposerRejected(t *testing.T) {
+	validatorKey, _ := testKey(t)
+	otherKey, other := testKey(t)
+	genesis := validatorGenesis(t, validatorKey, other)
+	bc := testChain(t, genesis)
+
+	// Only the drawn validator mints, and a block from anyone else is refused
+	blockchain, signingKey, validatorAddress = bc, otherKey, other
+	mintPOSBlock()
+	if bc.Height() != 0 {
+		t.Fatal("a validator outside the set minted a block")
+	}
+	source := testChain(t, genesis)
+	mintBlock(t, source, validatorKey)
+	wrong := source.Blocks[1]
+	wrong.Validator = other
+	wrong.Hash = calculateHash(wrong)
+	if err := signBlock(&wrong, otherKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddBlock(wrong); !errors.Is(err, ErrWrongProposer) {
+		t.Fatalf("AddBlock = %v, want %v", err, ErrWrongProposer)
+	}
+}
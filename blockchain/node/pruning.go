@@ -0,0 +1,116 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"time"
+)
+
+// Prune drops what the node no longer needs of canonical blocks older than
+// the newest KeepBlocks, and below the reorg window: a full node's
+// transactions and receipts, which also leave the transaction and address
+// indexes, or a lite node's validator sets. Headers are kept. It returns the
+// height below which blocks are pruned.
+func (bc *Blockchain) Prune() (int64, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	floor := int64(len(bc.Blocks)) - bc.KeepBlocks
+	if undoFloor := bc.undoFloor(); undoFloor < floor {
+		floor = undoFloor
+	}
+	if bc.KeepBlocks <= 0 || floor <= bc.prunedBelow {
+		return bc.prunedBelow, nil
+	}
+
+	batch := &Batch{}
+	if bc.Lite {
+		bc.pruneValidatorSets(batch, floor)
+	} else {
+		for height := bc.prunedBelow; height < floor; height++ {
+			block := &bc.Blocks[height]
+			for _, tx := range block.Transactions {
+				delete(bc.receipts, tx.Hash)
+			}
+			block.Transactions = []Transaction{}
+			batch.Delete(receiptsKey(block.Hash))
+			if err := putJSON(batch, blockKey(block.Hash), block); err != nil {
+				return bc.prunedBelow, err
+			}
+		}
+		bc.pruneHistory(floor)
+	}
+	bc.prunedBelow = floor
+
+	if bc.store == nil {
+		return floor, nil
+	}
+	if err := putJSON(batch, keyChainMeta, bc.meta()); err != nil {
+		return floor, err
+	}
+	return floor, bc.store.Write(batch)
+}
+
+// pruneHistory drops the address history of blocks below floor. Callers
+// must hold bc.mu.
+func (bc *Blockchain) pruneHistory(floor int64) {
+	for address, refs := range bc.history {
+		kept := sort.Search(len(refs), func(i int) bool { return refs[i].Block >= floor })
+		if kept == len(refs) {
+			delete(bc.history, address)
+		} else if kept > 0 {
+			bc.history[address] = append([]historyRef(nil), refs[kept:]...)
+		}
+	}
+}
+
+// pruneValidatorSets adds to batch the deletion of the stored validator sets
+// that no block from floor's parent up, canonical or not, commits to. Sets
+// fetched but not yet stored may belong to blocks being synced and are left
+// alone. Callers must hold bc.mu.
+func (bc *Blockchain) pruneValidatorSets(batch *Batch, floor int64) {
+	if bc.store == nil {
+		return
+	}
+	needed := map[string]bool{}
+	for _, block := range bc.Blocks[floor-1:] {
+		needed[block.ValidatorsRoot] = true
+	}
+	for _, block := range bc.sideBlocks {
+		needed[block.ValidatorsRoot] = true
+	}
+	bc.store.Iterate(prefixValidators, func(key string, _ []byte) error {
+		if !needed[key[len(prefixValidators):]] {
+			batch.Delete(key)
+		}
+		return nil
+	})
+}
+
+// HasBody reports whether this node still has the transactions of the
+// canonical block at index. Lite nodes have none.
+func (bc *Blockchain) HasBody(index int64) bool {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return !bc.Lite && index >= bc.prunedBelow && index < int64(len(bc.Blocks))
+}
+
+// PrunedBelow returns the height below which blocks have been pruned
+func (bc *Blockchain) PrunedBelow() int64 {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.prunedBelow
+}
+
+// pruneLoop prunes the chain at start and then every interval
+func pruneLoop(interval time.Duration) {
+	for {
+		before := blockchain.PrunedBelow()
+		if floor, err := blockchain.Prune(); err != nil {
+			log.Printf("⚠️  Pruning failed: %v", err)
+		} else if floor > before {
+			log.Printf("✂️  Pruned blocks below #%d", floor)
+		}
+		time.Sleep(interval)
+	}
+}
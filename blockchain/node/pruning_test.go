@@ -0,0 +1,112 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+
+	"gydschain/client"
+)
+
+func TestPruneDropsOldBodies(t *testing.T) {
+	key, sender := testKey(t)
+	genesis := testGenesis(t)
+	genesis.Alloc = map[string]GenesisAccount{sender: {Balance: "10000000000000000000"}}
+	store := NewMemoryStore()
+	chain, err := openBlockchain(store, genesis, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain.CurrentDiff = 0x1000
+	chain.MaxReorgDepth = 2
+	chain.KeepBlocks = 3
+	miner := "0x1111111111111111111111111111111111111111"
+	to := "0x2222222222222222222222222222222222222222"
+
+	old := signedTx(t, client.NewTransfer(sender, to, big.NewInt(5), 0), key)
+	if err := chain.AddTransaction(old); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, chain, miner, 5)
+	recent := signedTx(t, client.NewTransfer(sender, to, big.NewInt(5), 1), key)
+	if err := chain.AddTransaction(recent); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, chain, miner, 2)
+
+	// Height 7 keeps blocks #5 to #7 whole
+	floor, err := chain.Prune()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if floor != 5 {
+		t.Fatalf("Prune = %d, want 5", floor)
+	}
+	if again, err := chain.Prune(); err != nil || again != 5 {
+		t.Fatalf("second Prune = %d, %v, want 5", again, err)
+	}
+
+	for _, bc := range []*Blockchain{chain, reopen(t, store, genesis)} {
+		if bc.PrunedBelow() != 5 || bc.HasBody(4) || !bc.HasBody(5) {
+			t.Fatalf("pruned below %d, body #4 %v, body #5 %v", bc.PrunedBelow(), bc.HasBody(4), bc.HasBody(5))
+		}
+		if block, _ := bc.BlockByHash(bc.Blocks[1].Hash); len(block.Transactions) != 0 {
+			t.Fatalf("pruned block #1 has %d transactions", len(block.Transactions))
+		}
+		if _, ok := bc.Receipt(old.Hash); ok {
+			t.Fatal("receipt of a pruned block is still indexed")
+		}
+		if _, ok := bc.Receipt(recent.Hash); !ok {
+			t.Fatal("receipt of a kept block was pruned")
+		}
+		// The sender's first transfer is gone from its history, the rewards
+		// of pruned blocks from the miner's
+		if entries, _, _ := bc.AddressHistory(sender, "", 10); len(entries) != 1 {
+			t.Fatalf("sender history has %d entries, want 1", len(entries))
+		}
+		if entries, _, _ := bc.AddressHistory(miner, "", 10); len(entries) != 3 {
+			t.Fatalf("miner history has %d entries, want 3", len(entries))
+		}
+		if balance := bc.State.GetBalance(to); balance.Cmp(big.NewInt(10)) != 0 {
+			t.Fatalf("recipient balance = %s, want 10", balance)
+		}
+	}
+}
+
+func TestPruneDropsOldValidatorSets(t *testing.T) {
+	full, _, lite := delegatedChain(t)
+	lite.MaxReorgDepth = 1
+	lite.KeepBlocks = 1
+	before, changed := full.Blocks[1].ValidatorsRoot, full.Blocks[2].ValidatorsRoot
+	for _, root := range []string{before, changed} {
+		set, _ := full.ValidatorSet(root)
+		if err := lite.AddValidatorSet(root, set); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mineBlocks(t, full, "0x1111111111111111111111111111111111111111", 3)
+	for _, block := range full.Blocks[1:] {
+		if err := lite.AddBlock(block); err != nil {
+			t.Fatalf("block #%d: %v", block.Index, err)
+		}
+	}
+
+	if floor, err := lite.Prune(); err != nil || floor != 5 {
+		t.Fatalf("Prune = %d, %v, want 5", floor, err)
+	}
+	if lite.HasValidatorSet(before) {
+		t.Fatal("validator set no kept block commits to was not pruned")
+	}
+	if !lite.HasValidatorSet(changed) {
+		t.Fatal("validator set of the head was pruned")
+	}
+}
+
+// reopen loads the chain saved in store again
+func reopen(t *testing.T, store Store, genesis *Genesis) *Blockchain {
+	t.Helper()
+	bc, err := openBlockchain(store, genesis, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bc
+}
@@ -0,0 +1,59 @@
+package main
+
+// Receipt status values
+const (
+	ReceiptFailed  = 0
+	ReceiptSuccess = 1
+)
+
+// Receipt records the outcome of a mined transaction and where it was included
+type Receipt struct {
+	TxHash            string `json:"transactionHash"`
+	BlockHash         string `json:"blockHash"`
+	BlockNumber       int64  `json:"blockNumber"`
+	Index             int    `json:"transactionIndex"`
+	From              string `json:"from"`
+	To                string `json:"to"`
+	Status            int    `json:"status"`
+	GasUsed           int64  `json:"gasUsed"`
+	CumulativeGasUsed int64  `json:"cumulativeGasUsed"`
+	Fee               string `json:"fee"` // wei paid to the block producer
+}
+
+// blockReceipts returns the receipts of a canonical block's transactions,
+// recorded when the block was applied. Callers must hold bc.mu.
+func (bc *Blockchain) blockReceipts(block *Block) []Receipt {
+	receipts := make([]Receipt, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		receipts[i] = bc.receipts[tx.Hash]
+	}
+	return receipts
+}
+
+// indexBlock adds a canonical block and the receipts of its transactions to
+// the transaction and address indexes. Callers must hold bc.mu.
+func (bc *Blockchain) indexBlock(block *Block, receipts []Receipt) {
+	for _, receipt := range receipts {
+		bc.receipts[receipt.TxHash] = receipt
+	}
+	bc.indexAddresses(block)
+}
+
+// unindexBlock removes a block taken off the head of the canonical chain
+// from the transaction and address indexes. Callers must hold bc.mu.
+func (bc *Blockchain) unindexBlock(block *Block) {
+	for _, tx := range block.Transactions {
+		if receipt, ok := bc.receipts[tx.Hash]; ok && receipt.BlockHash == block.Hash {
+			delete(bc.receipts, tx.Hash)
+		}
+	}
+	bc.unindexAddresses(block)
+}
+
+// Receipt returns the receipt of a transaction mined on the canonical chain
+func (bc *Blockchain) Receipt(hash string) (Receipt, bool) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	receipt, ok := bc.receipts[hash]
+	return receipt, ok
+}
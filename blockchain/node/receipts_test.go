@@ -0,0 +1,56 @@
+package main
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"gydschain/client"
+)
+
+func TestReceiptsComeFromExecution(t *testing.T) {
+	key, sender := testKey(t)
+	genesis := testGenesis(t)
+	genesis.Alloc = map[string]GenesisAccount{sender: {Balance: "10000000000000000000"}}
+	store := NewMemoryStore()
+	chain, err := openBlockchain(store, genesis, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain.CurrentDiff = 0x1000
+	miner := "0x1111111111111111111111111111111111111111"
+
+	tx := signedTx(t, client.NewTransfer(sender, "0x2222222222222222222222222222222222222222", big.NewInt(5), 0), key)
+	if err := chain.AddTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, chain, miner, 1)
+
+	// The receipt's fee is what the miner was paid on top of the reward
+	receipt, ok := chain.Receipt(tx.Hash)
+	if !ok {
+		t.Fatal("no receipt")
+	}
+	earned := new(big.Int).Sub(chain.State.GetBalance(miner), big.NewInt(3e18))
+	if receipt.Status != ReceiptSuccess || receipt.Fee != earned.String() {
+		t.Fatalf("receipt status %d fee %s, miner earned %s in fees", receipt.Status, receipt.Fee, earned)
+	}
+
+	// Receipts are stored with the block
+	reopened, err := openBlockchain(store, genesis, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored, ok := reopened.Receipt(tx.Hash); !ok || stored != receipt {
+		t.Fatalf("reopened receipt = %+v, want %+v", stored, receipt)
+	}
+
+	// A block claiming other receipts than its execution gives is rejected
+	other := testChain(t, genesis)
+	block := reseal(chain.Blocks[1], func(b *Block) {
+		b.ReceiptsRoot = receiptsRoot([]Receipt{{TxHash: tx.Hash, Status: ReceiptSuccess, Fee: "0"}})
+	})
+	if err := other.AddBlock(block); err == nil || !strings.Contains(err.Error(), "receipts root") {
+		t.Fatalf("AddBlock = %v, want a receipts root mismatch", err)
+	}
+}
@@ -0,0 +1,666 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// JSON-RPC 2.0 error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	rpcServerError    = -32000 // valid request the node could not serve, e.g. a rejected transaction
+)
+
+// rpcError is a JSON-RPC error object
+type rpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+func invalidParams(format string, args ...interface{}) *rpcError {
+	return &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf(format, args...)}
+}
+
+// rpcRequest is a single JSON-RPC call
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// rpcResponse carries either a result or an error. Result is always
+// encoded on success, even when it is null.
+type rpcResponse struct {
+	ID     json.RawMessage `json:"id"`
+	Result interface{}     `json:"result"`
+	Error  *rpcError       `json:"error,omitempty"`
+}
+
+func (r rpcResponse) MarshalJSON() ([]byte, error) {
+	id := r.ID
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	if r.Error != nil {
+		return json.Marshal(struct {
+			JSONRPC string          `json:"jsonrpc"`
+			ID      json.RawMessage `json:"id"`
+			Error   *rpcError       `json:"error"`
+		}{"2.0", id, r.Error})
+	}
+	return json.Marshal(struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Result  interface{}     `json:"result"`
+	}{"2.0", id, r.Result})
+}
+
+// rpcMethod serves one JSON-RPC method. Params names the positional
+// parameters so they can also be passed by name in an object.
+type rpcMethod struct {
+	Params  []string
+	Handler func(params rpcParams) (interface{}, error)
+}
+
+// rpcMethods is the registry of supported JSON-RPC methods
+var rpcMethods = map[string]rpcMethod{
+	"eth_chainId":               {nil, rpcChainID},
+	"net_version":               {nil, rpcNetVersion},
+	"net_peerCount":             {nil, rpcPeerCount},
+	"eth_blockNumber":           {nil, rpcBlockNumber},
+	"eth_syncing":               {nil, rpcSyncing},
+	"eth_gasPrice":              {nil, rpcGasPrice},
+	"eth_estimateGas":           {[]string{"transaction", "block"}, rpcEstimateGas},
+	"eth_getBalance":            {[]string{"address", "block"}, rpcGetBalance},
+	"eth_getTransactionCount":   {[]string{"address", "block"}, rpcGetTransactionCount},
+	"eth_getBlockByNumber":      {[]string{"block", "fullTransactions"}, rpcGetBlockByNumber},
+	"eth_getBlockByHash":        {[]string{"hash", "fullTransactions"}, rpcGetBlockByHash},
+	"eth_getTransactionByHash":  {[]string{"hash"}, rpcGetTransactionByHash},
+	"eth_getTransactionReceipt": {[]string{"hash"}, rpcGetTransactionReceipt},
+	"eth_sendRawTransaction":    {[]string{"data"}, rpcSendRawTransaction},
+	"gyds_getTransactionProof":  {[]string{"hash"}, rpcGetTransactionProof},
+}
+
+// rpcMaxBatchSize caps the number of calls in a batch request
+var rpcMaxBatchSize = 100
+
+// rpcBatchConcurrency is how many calls of a batch are served at once
+var rpcBatchConcurrency = 4
+
+func handleRPC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeRPC(w, rpcResponse{Error: &rpcError{Code: rpcParseError, Message: "Parse error"}})
+		return
+	}
+	if resp, reply := serveRPCPayload(body, rpcMethods); reply {
+		writeRPC(w, resp)
+	} else {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// serveRPCPayload serves a single call or a batch of calls with the given
+// methods. reply is false when every call was a notification.
+func serveRPCPayload(body []byte, methods map[string]rpcMethod) (resp interface{}, reply bool) {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil {
+			return rpcResponse{Error: &rpcError{Code: rpcParseError, Message: "Parse error"}}, true
+		}
+		if len(batch) == 0 {
+			return rpcResponse{Error: &rpcError{Code: rpcInvalidRequest, Message: "Invalid Request: empty batch"}}, true
+		}
+		if len(batch) > rpcMaxBatchSize {
+			return rpcResponse{Error: &rpcError{
+				Code:    rpcInvalidRequest,
+				Message: fmt.Sprintf("Invalid Request: batch of %d exceeds limit of %d", len(batch), rpcMaxBatchSize),
+			}}, true
+		}
+		responses := serveBatch(batch, methods)
+		return responses, len(responses) > 0
+	}
+
+	if !json.Valid(body) {
+		return rpcResponse{Error: &rpcError{Code: rpcParseError, Message: "Parse error"}}, true
+	}
+	return serveRawRPC(body, methods)
+}
+
+func writeRPC(w http.ResponseWriter, v interface{}) {
+	json.NewEncoder(w).Encode(v)
+}
+
+// serveBatch serves the calls of a batch concurrently and returns the
+// responses in request order, leaving out notifications
+func serveBatch(batch []json.RawMessage, methods map[string]rpcMethod) []rpcResponse {
+	responses := make([]rpcResponse, len(batch))
+	replies := make([]bool, len(batch))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, rpcBatchConcurrency)
+	for i := range batch {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			responses[i], replies[i] = serveRawRPC(batch[i], methods)
+		}(i)
+	}
+	wg.Wait()
+
+	result := []rpcResponse{}
+	for i, resp := range responses {
+		if replies[i] {
+			result = append(result, resp)
+		}
+	}
+	return result
+}
+
+// serveRawRPC decodes and serves one call. reply is false for notifications.
+func serveRawRPC(raw json.RawMessage, methods map[string]rpcMethod) (resp rpcResponse, reply bool) {
+	var req rpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return rpcResponse{Error: &rpcError{Code: rpcInvalidRequest, Message: "Invalid Request"}}, true
+	}
+	if !validRPCID(req.ID) {
+		return rpcResponse{Error: &rpcError{Code: rpcInvalidRequest, Message: "Invalid Request: id must be a string, number or null"}}, true
+	}
+	resp = serveRPC(req, methods)
+	// A request without an id is a notification and gets no response
+	return resp, req.ID != nil
+}
+
+// validRPCID reports whether id is absent, null, a string or a number
+func validRPCID(id json.RawMessage) bool {
+	if id == nil {
+		return true
+	}
+	switch id[0] {
+	case '"', 'n', '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		return true
+	}
+	return false
+}
+
+// serveRPC dispatches a request to its method in methods
+func serveRPC(req rpcRequest, methods map[string]rpcMethod) rpcResponse {
+	resp := rpcResponse{ID: req.ID}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		resp.Error = &rpcError{Code: rpcInvalidRequest, Message: "Invalid Request"}
+		return resp
+	}
+	method, ok := methods[req.Method]
+	if !ok {
+		resp.Error = &rpcError{Code: rpcMethodNotFound, Message: "Method not found"}
+		return resp
+	}
+
+	params, err := method.decodeParams(req.Params)
+	if err != nil {
+		resp.Error = err
+		return resp
+	}
+
+	result, handlerErr := method.Handler(params)
+	if handlerErr != nil {
+		var rpcErr *rpcError
+		if !errors.As(handlerErr, &rpcErr) {
+			rpcErr = &rpcError{Code: rpcInternalError, Message: handlerErr.Error()}
+		}
+		resp.Error = rpcErr
+		return resp
+	}
+	resp.Result = result
+	return resp
+}
+
+// decodeParams accepts positional (array) or named (object) params and
+// returns them in positional order
+func (m rpcMethod) decodeParams(raw json.RawMessage) (rpcParams, *rpcError) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return nil, nil
+	}
+
+	switch raw[0] {
+	case '[':
+		var params rpcParams
+		if err := json.Unmarshal(raw, &params); err != nil {
+			return nil, invalidParams("invalid params array")
+		}
+		if len(params) > len(m.Params) {
+			return nil, invalidParams("too many arguments, want at most %d", len(m.Params))
+		}
+		return params, nil
+	case '{':
+		var named map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &named); err != nil {
+			return nil, invalidParams("invalid params object")
+		}
+		params := make(rpcParams, len(m.Params))
+		for i, name := range m.Params {
+			if value, ok := named[name]; ok {
+				params[i] = value
+				delete(named, name)
+			}
+		}
+		for name := range named {
+			return nil, invalidParams("unknown parameter %q", name)
+		}
+		return params, nil
+	}
+	return nil, invalidParams("params must be an array or object")
+}
+
+// rpcParams are the positional parameters of a call
+type rpcParams []json.RawMessage
+
+func (p rpcParams) has(i int) bool {
+	return i < len(p) && p[i] != nil && !bytes.Equal(p[i], []byte("null"))
+}
+
+func (p rpcParams) string(i int) (string, error) {
+	if !p.has(i) {
+		return "", invalidParams("missing value for required argument %d", i)
+	}
+	var s string
+	if err := json.Unmarshal(p[i], &s); err != nil {
+		return "", invalidParams("argument %d must be a string", i)
+	}
+	return s, nil
+}
+
+func (p rpcParams) bool(i int) (bool, error) {
+	if !p.has(i) {
+		return false, nil
+	}
+	var b bool
+	if err := json.Unmarshal(p[i], &b); err != nil {
+		return false, invalidParams("argument %d must be a boolean", i)
+	}
+	return b, nil
+}
+
+func (p rpcParams) address(i int) (string, error) {
+	s, err := p.string(i)
+	if err != nil {
+		return "", err
+	}
+	if err := ValidateAddress(s); err != nil {
+		return "", invalidParams("invalid address: %v", err)
+	}
+	return s, nil
+}
+
+func (p rpcParams) hash(i int) (string, error) {
+	s, err := p.string(i)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(strings.ToLower(s), "0x"), nil
+}
+
+// blockNumber resolves a block tag or hex quantity, defaulting to "latest"
+func (p rpcParams) blockNumber(i int) (int64, error) {
+	head := blockchain.Height()
+	if !p.has(i) {
+		return head, nil
+	}
+	tag, err := p.string(i)
+	if err != nil {
+		return 0, err
+	}
+	switch tag {
+	case "latest", "pending", "safe", "finalized":
+		return head, nil
+	case "earliest":
+		return 0, nil
+	}
+	n, err := parseHexQuantity(tag)
+	if err != nil {
+		return 0, invalidParams("invalid block number %q", tag)
+	}
+	return n, nil
+}
+
+// stateAt checks that the requested block is the head, as the node keeps no
+// historical state
+func (p rpcParams) stateAt(i int) error {
+	n, err := p.blockNumber(i)
+	if err != nil {
+		return err
+	}
+	if n != blockchain.Height() {
+		return &rpcError{Code: rpcServerError, Message: "historical state is not available"}
+	}
+	return nil
+}
+
+func parseHexQuantity(s string) (int64, error) {
+	if !strings.HasPrefix(s, "0x") || len(s) < 3 {
+		return 0, errors.New("quantity must be 0x-prefixed hex")
+	}
+	return strconv.ParseInt(s[2:], 16, 64)
+}
+
+// hexUint encodes a quantity as 0x-prefixed hex without leading zeros
+func hexUint(n int64) string {
+	return fmt.Sprintf("0x%x", n)
+}
+
+// hexDecimal encodes a decimal string amount as a hex quantity
+func hexDecimal(s string) string {
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return "0x0"
+	}
+	return "0x" + n.Text(16)
+}
+
+// hexHash prefixes a stored hash with 0x
+func hexHash(hash string) string {
+	return "0x" + strings.TrimPrefix(hash, "0x")
+}
+
+func rpcChainID(params rpcParams) (interface{}, error) {
+	return hexUint(blockchain.Config.ChainID), nil
+}
+
+func rpcNetVersion(params rpcParams) (interface{}, error) {
+	return strconv.FormatInt(blockchain.Config.NetworkID, 10), nil
+}
+
+func rpcPeerCount(params rpcParams) (interface{}, error) {
+	return hexUint(int64(p2pServer.PeerCount())), nil
+}
+
+func rpcBlockNumber(params rpcParams) (interface{}, error) {
+	return hexUint(blockchain.Height()), nil
+}
+
+func rpcSyncing(params rpcParams) (interface{}, error) {
+	progress, syncing := syncManager.Progress()
+	if !syncing {
+		return false, nil
+	}
+	return map[string]string{
+		"startingBlock": hexUint(progress.StartingBlock),
+		"currentBlock":  hexUint(progress.CurrentBlock),
+		"highestBlock":  hexUint(progress.HighestBlock),
+	}, nil
+}
+
+func rpcGasPrice(params rpcParams) (interface{}, error) {
+	return hexUint(MinGasPrice), nil
+}
+
+// rpcEstimateGas returns the gas a transfer needs, checking the sender can
+// afford it when from and value are given
+func rpcEstimateGas(params rpcParams) (interface{}, error) {
+	if !params.has(0) {
+		return nil, invalidParams("missing value for required argument 0")
+	}
+	var call struct {
+		From  string `json:"from"`
+		To    string `json:"to"`
+		Value string `json:"value"`
+	}
+	if err := json.Unmarshal(params[0], &call); err != nil {
+		return nil, invalidParams("argument 0 must be a transaction call object")
+	}
+	if call.To != "" {
+		if err := ValidateAddress(call.To); err != nil {
+			return nil, invalidParams("invalid to address: %v", err)
+		}
+	}
+	if call.From != "" && call.Value != "" {
+		value, ok := new(big.Int).SetString(strings.TrimPrefix(call.Value, "0x"), 16)
+		if !ok {
+			return nil, invalidParams("invalid value %q", call.Value)
+		}
+		fee, _ := CalculateTransactionFee(MinGasLimit, strconv.Itoa(MinGasPrice))
+		acct, err := lookupAccount(call.From)
+		if err != nil {
+			return nil, &rpcError{Code: rpcServerError, Message: err.Error()}
+		}
+		if acct.Balance.Cmp(value.Add(value, fee)) < 0 {
+			return nil, &rpcError{Code: rpcServerError, Message: ErrInsufficientFunds.Error()}
+		}
+	}
+	return hexUint(MinGasLimit), nil
+}
+
+func rpcGetBalance(params rpcParams) (interface{}, error) {
+	address, err := params.address(0)
+	if err != nil {
+		return nil, err
+	}
+	if err := params.stateAt(1); err != nil {
+		return nil, err
+	}
+	acct, err := lookupAccount(address)
+	if err != nil {
+		return nil, &rpcError{Code: rpcServerError, Message: err.Error()}
+	}
+	return "0x" + acct.Balance.Text(16), nil
+}
+
+func rpcGetTransactionCount(params rpcParams) (interface{}, error) {
+	address, err := params.address(0)
+	if err != nil {
+		return nil, err
+	}
+	// A lite node has no pool, so its pending nonce is the latest one
+	if tag, _ := params.string(1); tag == "pending" && !blockchain.Lite {
+		return hexUint(blockchain.PendingNonce(address)), nil
+	}
+	if err := params.stateAt(1); err != nil {
+		return nil, err
+	}
+	acct, err := lookupAccount(address)
+	if err != nil {
+		return nil, &rpcError{Code: rpcServerError, Message: err.Error()}
+	}
+	return hexUint(acct.Nonce), nil
+}
+
+func rpcGetBlockByNumber(params rpcParams) (interface{}, error) {
+	number, err := params.blockNumber(0)
+	if err != nil {
+		return nil, err
+	}
+	full, err := params.bool(1)
+	if err != nil {
+		return nil, err
+	}
+	block, ok := blockchain.BlockByNumber(number)
+	if !ok {
+		return nil, nil
+	}
+	return rpcBlock(block, full), nil
+}
+
+func rpcGetBlockByHash(params rpcParams) (interface{}, error) {
+	hash, err := params.hash(0)
+	if err != nil {
+		return nil, err
+	}
+	full, err := params.bool(1)
+	if err != nil {
+		return nil, err
+	}
+	block, ok := blockchain.BlockByHash(hash)
+	if !ok {
+		return nil, nil
+	}
+	return rpcBlock(block, full), nil
+}
+
+func rpcGetTransactionByHash(params rpcParams) (interface{}, error) {
+	hash, err := params.hash(0)
+	if err != nil {
+		return nil, err
+	}
+	lookup, _, ok, err := lookupTransaction(hash)
+	if err != nil {
+		return nil, &rpcError{Code: rpcServerError, Message: err.Error()}
+	}
+	if !ok {
+		return nil, nil
+	}
+	return rpcTransaction(lookup), nil
+}
+
+func rpcGetTransactionReceipt(params rpcParams) (interface{}, error) {
+	hash, err := params.hash(0)
+	if err != nil {
+		return nil, err
+	}
+	lookup, receipt, ok, err := lookupTransaction(hash)
+	if err != nil {
+		return nil, &rpcError{Code: rpcServerError, Message: err.Error()}
+	}
+	if !ok || receipt == nil {
+		return nil, nil
+	}
+
+	return map[string]interface{}{
+		"transactionHash":   hexHash(receipt.TxHash),
+		"transactionIndex":  hexUint(int64(receipt.Index)),
+		"blockHash":         hexHash(receipt.BlockHash),
+		"blockNumber":       hexUint(receipt.BlockNumber),
+		"from":              receipt.From,
+		"to":                receipt.To,
+		"gasUsed":           hexUint(receipt.GasUsed),
+		"cumulativeGasUsed": hexUint(receipt.CumulativeGasUsed),
+		"effectiveGasPrice": hexDecimal(lookup.Tx.GasPrice),
+		"fee":               hexDecimal(receipt.Fee),
+		"contractAddress":   nil,
+		"logs":              []interface{}{},
+		"status":            hexUint(int64(receipt.Status)),
+		"type":              "0x0",
+	}, nil
+}
+
+func rpcSendRawTransaction(params rpcParams) (interface{}, error) {
+	raw, err := params.string(0)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := DecodeRawTransaction(raw)
+	if err != nil {
+		return nil, invalidParams("invalid raw transaction: %v", err)
+	}
+	if err := blockchain.AddTransaction(*tx); err != nil {
+		return nil, &rpcError{Code: rpcServerError, Message: err.Error()}
+	}
+	return hexHash(tx.Hash), nil
+}
+
+// rpcGetTransactionProof returns the Merkle inclusion proof of a mined
+// transaction in the same form as /proof/tx/{hash}, or null
+func rpcGetTransactionProof(params rpcParams) (interface{}, error) {
+	hash, err := params.hash(0)
+	if err != nil {
+		return nil, err
+	}
+	proof, ok, err := lookupTxProof(hash)
+	if err != nil {
+		return nil, &rpcError{Code: rpcServerError, Message: err.Error()}
+	}
+	if !ok {
+		return nil, nil
+	}
+	return proof, nil
+}
+
+// rpcBlock encodes a block in the Ethereum JSON-RPC block format, plus the
+// GYDSchain type, validator and reward fields
+func rpcBlock(block Block, fullTx bool) map[string]interface{} {
+	txs := make([]interface{}, len(block.Transactions))
+	var gasUsed int64
+	for i, tx := range block.Transactions {
+		gasUsed += tx.Gas
+		if fullTx {
+			txs[i] = rpcTransaction(TxLookup{Tx: tx, BlockHash: block.Hash, BlockNumber: block.Index, Index: i})
+		} else {
+			txs[i] = hexHash(tx.Hash)
+		}
+	}
+
+	return map[string]interface{}{
+		"number":           hexUint(block.Index),
+		"hash":             hexHash(block.Hash),
+		"parentHash":       hexHash(block.PreviousHash),
+		"transactionsRoot": hexHash(block.TxRoot),
+		"receiptsRoot":     hexHash(block.ReceiptsRoot),
+		"stateRoot":        hexHash(block.StateRoot),
+		"validatorsRoot":   hexHash(block.ValidatorsRoot),
+		"nonce":            fmt.Sprintf("0x%016x", uint64(block.Nonce)),
+		"timestamp":        hexUint(block.Timestamp),
+		"difficulty":       hexUint(block.Difficulty),
+		"miner":            blockProducer(&block),
+		"gasLimit":         hexUint(blockchain.Config.BlockGasLimit),
+		"gasUsed":          hexUint(gasUsed),
+		"size":             hexUint(blockSize(&block)),
+		"extraData":        "0x",
+		"uncles":           []interface{}{},
+		"transactions":     txs,
+		"type":             block.Type,
+		"validator":        block.Validator,
+		"signature":        block.Signature,
+		"reward":           hexDecimal(block.Reward),
+	}
+}
+
+// rpcTransaction encodes a transaction in the Ethereum JSON-RPC format
+func rpcTransaction(lookup TxLookup) map[string]interface{} {
+	tx := lookup.Tx
+	result := map[string]interface{}{
+		"hash":             hexHash(tx.Hash),
+		"nonce":            hexUint(tx.Nonce),
+		"from":             tx.From,
+		"to":               tx.To,
+		"value":            hexDecimal(tx.Value),
+		"gas":              hexUint(tx.Gas),
+		"gasPrice":         hexDecimal(tx.GasPrice),
+		"input":            "0x",
+		"chainId":          hexUint(tx.ChainID),
+		"blockHash":        nil,
+		"blockNumber":      nil,
+		"transactionIndex": nil,
+	}
+	if !lookup.Pending {
+		result["blockHash"] = hexHash(lookup.BlockHash)
+		result["blockNumber"] = hexUint(lookup.BlockNumber)
+		result["transactionIndex"] = hexUint(int64(lookup.Index))
+	}
+	return result
+}
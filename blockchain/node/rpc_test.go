@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const rpcTestMiner = "0x1111111111111111111111111111111111111111"
+
+// rpcChain makes a chain with two mined blocks the one RPC calls are served from
+func rpcChain(t *testing.T) *Blockchain {
+	t.Helper()
+	blockchain = testChain(t, testGenesis(t))
+	mineBlocks(t, blockchain, rpcTestMiner, 2)
+	return blockchain
+}
+
+// callRPC serves body and returns the response as decoded JSON, or nil if
+// there is no reply
+func callRPC(t *testing.T, body string) interface{} {
+	t.Helper()
+	resp, reply := serveRPCPayload([]byte(body), rpcMethods)
+	if !reply {
+		return nil
+	}
+	raw, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+// call makes a single call and returns its response object
+func call(t *testing.T, method string, params string) map[string]interface{} {
+	t.Helper()
+	resp, _ := callRPC(t, fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"method":%q,"params":%s}`, method, params)).(map[string]interface{})
+	if resp == nil {
+		t.Fatalf("%s: no response", method)
+	}
+	return resp
+}
+
+// errorCode returns the code of a response's error, or 0 if it succeeded
+func errorCode(resp interface{}) int {
+	rpcErr, ok := resp.(map[string]interface{})["error"].(map[string]interface{})
+	if !ok {
+		return 0
+	}
+	return int(rpcErr["code"].(float64))
+}
+
+func TestRPCDispatch(t *testing.T) {
+	bc := rpcChain(t)
+
+	if got := call(t, "eth_blockNumber", `[]`)["result"]; got != "0x2" {
+		t.Fatalf("eth_blockNumber = %v", got)
+	}
+	if got := call(t, "eth_chainId", `null`)["result"]; got != hexUint(bc.Config.ChainID) {
+		t.Fatalf("eth_chainId = %v", got)
+	}
+	balance := hexDecimal(bc.State.GetBalance(rpcTestMiner).String())
+	if got := call(t, "eth_getBalance", `["`+rpcTestMiner+`", "latest"]`)["result"]; got != balance {
+		t.Fatalf("eth_getBalance = %v, want %s", got, balance)
+	}
+
+	resp := callRPC(t, `{"jsonrpc":"2.0","id":"abc","method":"eth_blockNumber"}`).(map[string]interface{})
+	if resp["id"] != "abc" || resp["jsonrpc"] != "2.0" {
+		t.Fatalf("response %v does not echo the request id", resp)
+	}
+}
+
+func TestRPCErrorCodes(t *testing.T) {
+	rpcChain(t)
+	for _, tc := range []struct {
+		body string
+		code int
+	}{
+		{`{"jsonrpc":"2.0","id":1,"method":"eth_noSuchMethod"}`, rpcMethodNotFound},
+		{`{"jsonrpc":"1.0","id":1,"method":"eth_blockNumber"}`, rpcInvalidRequest},
+		{`{"jsonrpc":"2.0","id":1}`, rpcInvalidRequest},
+		{`{"jsonrpc":"2.0","id":{},"method":"eth_blockNumber"}`, rpcInvalidRequest},
+		{`{"jsonrpc":"2.0","id":1,"method":"eth_getBalance","params":["0xnot-an-address"]}`, rpcInvalidParams},
+		{`{"jsonrpc":"2.0","id":1,"method":"eth_getBalance","params":["` + rpcTestMiner + `","two"]}`, rpcInvalidParams},
+		{`{"jsonrpc":"2.0","id":1,"method":"eth_getBalance","params":"` + rpcTestMiner + `"}`, rpcInvalidParams},
+		{`{"jsonrpc":"2.0","id":1,"method":"eth_getBalance","params":["` + rpcTestMiner + `","0x1"]}`, rpcServerError},
+		{`{"jsonrpc":"2.0","id":1,"method"`, rpcParseError},
+	} {
+		if got := errorCode(callRPC(t, tc.body)); got != tc.code {
+			t.Errorf("%s: error code %d, want %d", tc.body, got, tc.code)
+		}
+	}
+}
+
+func TestRPCBlockTags(t *testing.T) {
+	bc := rpcChain(t)
+	hashAt := func(params string) interface{} {
+		block, _ := call(t, "eth_getBlockByNumber", params)["result"].(map[string]interface{})
+		if block == nil {
+			return nil
+		}
+		return block["hash"]
+	}
+
+	head := hexHash(bc.Blocks[2].Hash)
+	for _, params := range []string{`[]`, `["latest"]`, `["pending"]`, `["safe"]`, `["finalized"]`, `["0x2"]`} {
+		if got := hashAt(params); got != head {
+			t.Errorf("block %s = %v, want the head", params, got)
+		}
+	}
+	if got := hashAt(`["earliest"]`); got != hexHash(bc.Blocks[0].Hash) {
+		t.Errorf("earliest block = %v, want genesis", got)
+	}
+	if got := hashAt(`["0x1"]`); got != hexHash(bc.Blocks[1].Hash) {
+		t.Errorf("block 0x1 = %v", got)
+	}
+	if got := hashAt(`["0x10"]`); got != nil {
+		t.Errorf("block beyond the head = %v, want null", got)
+	}
+	if code := errorCode(call(t, "eth_getBlockByNumber", `["1"]`)); code != rpcInvalidParams {
+		t.Errorf("decimal block number: error code %d", code)
+	}
+}
+
+func TestHexQuantities(t *testing.T) {
+	for n, want := range map[int64]string{0: "0x0", 26: "0x1a", 1 << 40: "0x10000000000"} {
+		if got := hexUint(n); got != want {
+			t.Errorf("hexUint(%d) = %s, want %s", n, got, want)
+		}
+	}
+	for s, want := range map[string]string{"0": "0x0", "1000000000000000000": "0xde0b6b3a7640000", "not a number": "0x0"} {
+		if got := hexDecimal(s); got != want {
+			t.Errorf("hexDecimal(%s) = %s, want %s", s, got, want)
+		}
+	}
+	if n, err := parseHexQuantity("0x1a"); err != nil || n != 26 {
+		t.Errorf("parseHexQuantity(0x1a) = %d, %v", n, err)
+	}
+	for _, s := range []string{"1a", "0x", "0xzz", ""} {
+		if _, err := parseHexQuantity(s); err == nil {
+			t.Errorf("parseHexQuantity(%q) succeeded", s)
+		}
+	}
+}
+
+func TestRPCBatchKeepsRequestOrder(t *testing.T) {
+	rpcChain(t)
+	defer func(n int) { rpcBatchConcurrency = n }(rpcBatchConcurrency)
+	rpcBatchConcurrency = 2
+
+	resp := callRPC(t, `[
+		{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"},
+		{"jsonrpc":"2.0","method":"eth_chainId"},
+		{"jsonrpc":"2.0","id":"two","method":"eth_chainId"},
+		{"jsonrpc":"2.0","id":3,"method":"eth_noSuchMethod"},
+		{"jsonrpc":"2.0","id":4,"method":"eth_getBlockByNumber","params":["earliest"]},
+		{"jsonrpc":"2.0","id":5,"method":"eth_blockNumber"}
+	]`)
+	responses, ok := resp.([]interface{})
+	if !ok || len(responses) != 5 {
+		t.Fatalf("batch response = %v, want 5 responses without the notification", resp)
+	}
+	for i, want := range []interface{}{1.0, "two", 3.0, 4.0, 5.0} {
+		if id := responses[i].(map[string]interface{})["id"]; id != want {
+			t.Fatalf("response %d has id %v, want %v", i, id, want)
+		}
+	}
+	if errorCode(responses[2]) != rpcMethodNotFound || errorCode(responses[0]) != 0 {
+		t.Fatalf("errors not kept with their calls: %v", responses)
+	}
+}
+
+func TestRPCBatchLimits(t *testing.T) {
+	rpcChain(t)
+	defer func(n int) { rpcMaxBatchSize = n }(rpcMaxBatchSize)
+	rpcMaxBatchSize = 2
+
+	if code := errorCode(callRPC(t, `[]`)); code != rpcInvalidRequest {
+		t.Fatalf("empty batch: error code %d", code)
+	}
+	single := `{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"}`
+	if code := errorCode(callRPC(t, "["+strings.Repeat(single+",", 2)+single+"]")); code != rpcInvalidRequest {
+		t.Fatalf("batch over the limit: error code %d", code)
+	}
+	if responses, _ := callRPC(t, "["+single+","+single+"]").([]interface{}); len(responses) != 2 {
+		t.Fatalf("batch at the limit: %d responses", len(responses))
+	}
+	if responses, _ := callRPC(t, `[1]`).([]interface{}); len(responses) != 1 || errorCode(responses[0]) != rpcInvalidRequest {
+		t.Fatalf("batch holding a non-object: %v", responses)
+	}
+	if code := errorCode(callRPC(t, `[{"jsonrpc":"2.0"`)); code != rpcParseError {
+		t.Fatalf("truncated batch: error code %d", code)
+	}
+}
+
+func TestRPCNotificationsGetNoReply(t *testing.T) {
+	rpcChain(t)
+	notification := `{"jsonrpc":"2.0","method":"eth_blockNumber"}`
+	if _, reply := serveRPCPayload([]byte(notification), rpcMethods); reply {
+		t.Fatal("notification answered")
+	}
+	if _, reply := serveRPCPayload([]byte("["+notification+","+notification+"]"), rpcMethods); reply {
+		t.Fatal("batch of notifications answered")
+	}
+	// Even a failing notification stays silent
+	if _, reply := serveRPCPayload([]byte(`{"jsonrpc":"2.0","method":"eth_noSuchMethod"}`), rpcMethods); reply {
+		t.Fatal("notification of an unknown method answered")
+	}
+}
+
+func TestRPCNamedParams(t *testing.T) {
+	bc := rpcChain(t)
+
+	balance := hexDecimal(bc.State.GetBalance(rpcTestMiner).String())
+	if got := call(t, "eth_getBalance", `{"address":"`+rpcTestMiner+`","block":"latest"}`)["result"]; got != balance {
+		t.Fatalf("eth_getBalance by name = %v, want %s", got, balance)
+	}
+	// Named params may be left out like trailing positional ones
+	if got := call(t, "eth_getBalance", `{"address":"`+rpcTestMiner+`"}`)["result"]; got != balance {
+		t.Fatalf("eth_getBalance without block = %v, want %s", got, balance)
+	}
+
+	resp := call(t, "eth_getBalance", `{"address":"`+rpcTestMiner+`","blok":"latest"}`)
+	if errorCode(resp) != rpcInvalidParams || !strings.Contains(fmt.Sprint(resp["error"]), `unknown parameter "blok"`) {
+		t.Fatalf("unknown named param: %v", resp["error"])
+	}
+	if code := errorCode(call(t, "eth_getBalance", `["`+rpcTestMiner+`","latest",true]`)); code != rpcInvalidParams {
+		t.Fatalf("too many positional params: error code %d", code)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+)
+
+// TxEvidence reports a validator fault. It is sent to the offending
+// validator's address with the evidence as its Data.
+const TxEvidence = "evidence"
+
+// Evidence types
+const (
+	EvidenceDoubleSign = "doubleSign" // two signed POS blocks at the same height
+)
+
+// maxEvidenceSize bounds the Data of an evidence transaction
+const maxEvidenceSize = 8192
+
+var (
+	ErrSlashingDisabled = errors.New("slashing is not enabled on this chain")
+	ErrInvalidEvidence  = errors.New("invalid evidence")
+	ErrEvidenceHandled  = errors.New("validator was already punished at or above this height")
+)
+
+// Evidence proves a validator fault. For double signing, First and Second
+// are two different POS headers of the same height signed by the validator.
+// Liveness faults need no evidence: every node sees missed slots on the
+// chain itself.
+type Evidence struct {
+	Type   string      `json:"type"`
+	First  BlockHeader `json:"first"`
+	Second BlockHeader `json:"second"`
+}
+
+// slashingRules are the chain parameters faults are punished with
+type slashingRules struct {
+	Enabled         bool
+	DoubleSignSlash int64 // percent of the stake
+	DowntimeSlash   int64 // percent of the stake
+	JailDuration    int64 // seconds
+	MaxMissedSlots  int   // consecutive missed slots before a downtime fault
+	SlotTimeout     int64 // seconds each proposer round of a slot lasts
+	ToReporter      bool  // pay slashed stake to whoever reported the fault instead of burning it
+}
+
+func (c ChainConfig) slashingRules() slashingRules {
+	return slashingRules{
+		Enabled:         c.Slashing,
+		DoubleSignSlash: c.DoubleSignSlash,
+		DowntimeSlash:   c.DowntimeSlash,
+		JailDuration:    c.JailDuration,
+		MaxMissedSlots:  c.MaxMissedSlots,
+		SlotTimeout:     c.SlotTimeout,
+		ToReporter:      c.SlashPenalty == "reporter",
+	}
+}
+
+// verify checks that evidence proves a double sign by validator, whose
+// blocks are signed with publicKey
+func (e *Evidence) verify(validator, publicKey string) error {
+	if e.Type != EvidenceDoubleSign {
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidEvidence, e.Type)
+	}
+	if e.First.Index != e.Second.Index || e.First.Hash == e.Second.Hash {
+		return fmt.Errorf("%w: blocks must be different blocks of the same height", ErrInvalidEvidence)
+	}
+	for _, header := range []BlockHeader{e.First, e.Second} {
+		block := header.ToBlock(nil)
+		if block.Type != "POS" || !addressesEqual(block.Validator, validator) {
+			return fmt.Errorf("%w: block %s is not a POS block of %s", ErrInvalidEvidence, shortID(block.Hash), validator)
+		}
+		if calculateHash(block) != block.Hash {
+			return fmt.Errorf("%w: block hash mismatch", ErrInvalidEvidence)
+		}
+		if err := verifyBlockSignature(&block, publicKey); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidEvidence, err)
+		}
+	}
+	return nil
+}
+
+// applyEvidence punishes the validator a double-sign evidence transaction
+// names. The fee and nonce are handled by the caller.
+func (s *State) applyEvidence(tx *Transaction, now int64) error {
+	if !s.slashing.Enabled {
+		return ErrSlashingDisabled
+	}
+	var evidence Evidence
+	if err := json.Unmarshal([]byte(tx.Data), &evidence); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidEvidence, err)
+	}
+	val := s.GetAccount(tx.To).Validator
+	if val == nil || val.Status == ValidatorExited {
+		return ErrNotValidator
+	}
+	if err := evidence.verify(tx.To, val.keyAt(evidence.First.Index)); err != nil {
+		return err
+	}
+	if evidence.First.Index <= val.LastDoubleSign {
+		return ErrEvidenceHandled
+	}
+	// A validator that left and staked again answers only for its new term
+	if evidence.First.Timestamp < val.JoinedAt || evidence.Second.Timestamp < val.JoinedAt {
+		return fmt.Errorf("%w: blocks predate the validator joining", ErrInvalidEvidence)
+	}
+
+	s.mutable(tx.To).Validator.LastDoubleSign = evidence.First.Index
+	s.slash(tx.To, s.slashing.DoubleSignSlash, tx.From, now)
+	return nil
+}
+
+// slash takes percent of a validator's own stake and jails it; an exiting
+// validator only loses the stake. The stake is burned, leaving the total
+// supply, or paid to reporter if the chain redistributes penalties. A jailed
+// validator left below the minimum stake leaves the set and the rest of its
+// stake starts unbonding.
+func (s *State) slash(address string, percent int64, reporter string, now int64) {
+	acct := s.mutable(address)
+	penalty := new(big.Int).Mul(acct.Validator.Stake, big.NewInt(percent))
+	penalty.Div(penalty, big.NewInt(100))
+	acct.Validator.Stake.Sub(acct.Validator.Stake, penalty)
+	acct.Validator.MissedSlots = 0
+
+	if s.slashing.ToReporter && reporter != "" {
+		s.AddBalance(reporter, penalty)
+	} else {
+		s.burned.Add(s.burned, penalty)
+	}
+	if acct.Validator.Status == ValidatorExiting {
+		return
+	}
+	acct.Validator.Status = ValidatorJailed
+	acct.Validator.JailedUntil = now + s.slashing.JailDuration
+	if acct.Validator.Stake.Cmp(s.staking.MinStake) < 0 || acct.Validator.Stake.Sign() == 0 {
+		s.releaseStake(address, now, now+s.staking.UnlockDuration)
+	}
+}
+
+// maxMissedRounds bounds the proposer rounds a single block charges misses for
+const maxMissedRounds = 256
+
+// recordSlot charges a missed slot to every proposer drawn for an earlier
+// round of the slot a POS block fills: each let its slot timeout pass without
+// producing the block. Only POS blocks show this; a POW block fills a slot
+// that no proposer was bound to. Too many missed slots in a row is a downtime
+// fault, punished when slashing is enabled. Producing a block clears the
+// count.
+func (s *State) recordSlot(block, parent *Block) {
+	if !s.staking.Enabled || block.Type != "POS" {
+		return
+	}
+	producer := normalizeAddress(block.Validator)
+	if val := s.accounts[producer].Validator; val != nil && val.MissedSlots > 0 {
+		s.mutable(producer).Validator.MissedSlots = 0
+	}
+
+	validators := s.Validators()
+	missed := map[string]bool{}
+	round := slotRound(block.Timestamp, parent, s.slashing.SlotTimeout)
+	for r := int64(0); r < round && r < maxMissedRounds; r++ {
+		if expected := expectedProposer(parent, validators, r); expected != "" && !addressesEqual(expected, producer) {
+			missed[normalizeAddress(expected)] = true
+		}
+	}
+	addresses := make([]string, 0, len(missed))
+	for address := range missed {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	for _, address := range addresses {
+		val := s.mutable(address).Validator
+		val.MissedSlots++
+		val.TotalMissed++
+		if s.slashing.Enabled && val.MissedSlots >= s.slashing.MaxMissedSlots {
+			s.slash(address, s.slashing.DowntimeSlash, producer, block.Timestamp)
+		}
+	}
+}
+
+// checkEquivocation compares a POS block with the other blocks known at its
+// height and keeps evidence of any the same validator signed, ready to be
+// submitted in an evidence transaction. Side-chain blocks are checked against
+// their branch's validator set, so evidence is kept only if it also verifies
+// against the validator's key here. Lite nodes keep none. Callers must hold
+// bc.mu.
+func (bc *Blockchain) checkEquivocation(block *Block) {
+	if block.Type != "POS" || bc.Lite {
+		return
+	}
+	others := []Block{}
+	if block.Index < int64(len(bc.Blocks)) {
+		others = append(others, bc.Blocks[block.Index])
+	}
+	for _, side := range bc.sideBlocks {
+		if side.Index == block.Index {
+			others = append(others, side)
+		}
+	}
+
+	for _, other := range others {
+		if other.Hash == block.Hash || other.Type != "POS" || !addressesEqual(other.Validator, block.Validator) {
+			continue
+		}
+		evidence := Evidence{Type: EvidenceDoubleSign, First: other.Header(), Second: block.Header()}
+		if evidence.First.Hash > evidence.Second.Hash {
+			evidence.First, evidence.Second = evidence.Second, evidence.First
+		}
+		key := evidence.First.Hash + evidence.Second.Hash
+		if _, seen := bc.evidence[key]; seen {
+			continue
+		}
+		val := bc.State.GetAccount(block.Validator).Validator
+		if val == nil || evidence.verify(block.Validator, val.keyAt(block.Index)) != nil {
+			continue
+		}
+		bc.evidence[key] = evidence
+		log.Printf("🚨 Validator %s signed two blocks at #%d (%s, %s)", block.Validator, block.Index, shortID(evidence.First.Hash), shortID(evidence.Second.Hash))
+	}
+}
+
+// DetectedEvidence returns the double signs this node has seen, by height
+func (bc *Blockchain) DetectedEvidence() []Evidence {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	evidence := make([]Evidence, 0, len(bc.evidence))
+	for _, e := range bc.evidence {
+		evidence = append(evidence, e)
+	}
+	sort.Slice(evidence, func(i, j int) bool { return evidence[i].First.Index < evidence[j].First.Index })
+	return evidence
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"gydschain/client"
+)
+
+// testValidators makes each address an active validator with equal stake
+func testValidators(state *State, addresses ...string) {
+	for _, address := range addresses {
+		state.mutable(address).Validator = &ValidatorState{Status: ValidatorActive, Stake: big.NewInt(1e18)}
+	}
+}
+
+// skippingRound returns a round on top of parent in which producer is drawn
+// after skipped was drawn for an earlier round
+func skippingRound(parent *Block, validators map[string]Validator, skipped, producer string) int64 {
+	drawn := false
+	for round := int64(0); ; round++ {
+		proposer := expectedProposer(parent, validators, round)
+		if drawn && addressesEqual(proposer, producer) {
+			return round
+		}
+		drawn = drawn || addressesEqual(proposer, skipped)
+	}
+}
+
+func TestRecordSlotChargesOnlySkippedProposers(t *testing.T) {
+	bc := testChain(t, testGenesis(t))
+	state := bc.State
+	a, b := "0x000000000000000000000000000000000000000a", "0x000000000000000000000000000000000000000b"
+	testValidators(state, a, b)
+	parent := bc.Blocks[0]
+	timeout := state.slashing.SlotTimeout
+
+	// A POW block long after the parent binds no proposer
+	late := Block{Index: 1, PreviousHash: parent.Hash, Timestamp: parent.Timestamp + 10*timeout, Type: "POW"}
+	state.recordSlot(&late, &parent)
+	for _, address := range []string{a, b} {
+		if missed := state.GetAccount(address).Validator.TotalMissed; missed != 0 {
+			t.Fatalf("%s charged %d misses for a POW block", address, missed)
+		}
+	}
+
+	// A POS block from a round a drew after b was drawn and let its round pass
+	round := skippingRound(&parent, state.Validators(), b, a)
+	block := Block{Index: 1, PreviousHash: parent.Hash, Timestamp: parent.Timestamp + round*timeout, Type: "POS", Validator: a}
+	state.recordSlot(&block, &parent)
+
+	if missed := state.GetAccount(a).Validator.TotalMissed; missed != 0 {
+		t.Fatalf("producer charged %d misses", missed)
+	}
+	if missed := state.GetAccount(b).Validator.TotalMissed; missed != 1 {
+		t.Fatalf("skipped proposer charged %d misses, want 1", missed)
+	}
+}
+
+// slashingGenesis is validatorGenesis with slashing enabled
+func slashingGenesis(t *testing.T, validator *ecdsa.PrivateKey, funded string) *Genesis {
+	t.Helper()
+	genesis := validatorGenesis(t, validator, funded)
+	genesis.Config.Consensus.POS.Slashing = true
+	if err := genesis.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return genesis
+}
+
+// doubleSign returns evidence of key signing two POS blocks on parent
+func doubleSign(t *testing.T, parent Block, key *ecdsa.PrivateKey) string {
+	t.Helper()
+	headers := make([]BlockHeader, 2)
+	for i := range headers {
+		block := Block{
+			Index:        parent.Index + 1,
+			Timestamp:    parent.Timestamp + 1 + int64(i),
+			PreviousHash: parent.Hash,
+			Validator:    client.Address(&key.PublicKey),
+			Type:         "POS",
+		}
+		block.Hash = calculateHash(block)
+		if err := signBlock(&block, key); err != nil {
+			t.Fatal(err)
+		}
+		headers[i] = block.Header()
+	}
+	evidence, _ := json.Marshal(Evidence{Type: EvidenceDoubleSign, First: headers[0], Second: headers[1]})
+	return string(evidence)
+}
+
+func TestBurnedStakeLeavesSupply(t *testing.T) {
+	validatorKey, validator := testKey(t)
+	reporterKey, reporter := testKey(t)
+	bc := testChain(t, slashingGenesis(t, validatorKey, reporter))
+
+	tx := signedTx(t, client.NewEvidence(reporter, validator, doubleSign(t, bc.Blocks[0], validatorKey), 0), reporterKey)
+	if err := bc.AddTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	supply := new(big.Int).Set(bc.TotalSupply)
+	mineBlocks(t, bc, "0x1111111111111111111111111111111111111111", 1)
+	if len(bc.Blocks[1].Transactions) != 1 {
+		t.Fatal("evidence was not mined")
+	}
+
+	// 3 GYDS mined, 5% of the 5 GYDS stake burned
+	want, _ := new(big.Int).SetString("2750000000000000000", 10)
+	if got := new(big.Int).Sub(bc.TotalSupply, supply); got.Cmp(want) != 0 {
+		t.Fatalf("supply grew by %s, want %s", got, want)
+	}
+	if stake := bc.Validators[validator].Stake; stake != "4750000000000000000" {
+		t.Fatalf("stake = %s", stake)
+	}
+}
+
+func TestDowntimeJailsValidator(t *testing.T) {
+	bc := testChain(t, testGenesis(t))
+	state := bc.State
+	state.slashing.Enabled = true
+	state.slashing.MaxMissedSlots = 2
+	state.slashing.DowntimeSlash = 10
+	state.slashing.JailDuration = 600
+	a, b := "0x000000000000000000000000000000000000000a", "0x000000000000000000000000000000000000000b"
+	testValidators(state, a, b)
+	state.mutable(b).Validator.Stake = big.NewInt(2e18)
+	parent := bc.Blocks[0]
+	round := skippingRound(&parent, state.Validators(), b, a)
+	block := Block{Index: 1, PreviousHash: parent.Hash, Timestamp: parent.Timestamp + round*state.slashing.SlotTimeout, Type: "POS", Validator: a}
+
+	// One miss is tolerated, and producing a block forgives it
+	state.recordSlot(&block, &parent)
+	produced := Block{Index: 1, PreviousHash: parent.Hash, Timestamp: parent.Timestamp, Type: "POS", Validator: b}
+	state.recordSlot(&produced, &parent)
+	if val := state.GetAccount(b).Validator; val.MissedSlots != 0 || val.TotalMissed != 1 {
+		t.Fatalf("after producing: %d missed in a row, %d in total", val.MissedSlots, val.TotalMissed)
+	}
+
+	// Missing MaxMissedSlots in a row is a downtime fault
+	for i := 0; i < state.slashing.MaxMissedSlots; i++ {
+		state.recordSlot(&block, &parent)
+	}
+	val := state.GetAccount(b).Validator
+	if val.Status != ValidatorJailed || val.JailedUntil != block.Timestamp+600 {
+		t.Fatalf("validator %s until %d, want jailed until %d", val.Status, val.JailedUntil, block.Timestamp+600)
+	}
+	if val.Stake.Cmp(big.NewInt(18e17)) != 0 || state.burned.Cmp(big.NewInt(2e17)) != 0 {
+		t.Fatalf("stake %s, burned %s after a 10%% slash", val.Stake, state.burned)
+	}
+	if _, ok := state.Validators()[b]; !ok {
+		t.Fatal("jailed validator above the minimum stake left the set")
+	}
+	if val, _ := state.Validator(b); val.Active {
+		t.Fatal("jailed validator still active")
+	}
+}
+
+func TestDoubleSignEvidence(t *testing.T) {
+	validatorKey, validator := testKey(t)
+	reporterKey, reporter := testKey(t)
+	otherKey, _ := testKey(t)
+	bc := testChain(t, slashingGenesis(t, validatorKey, reporter))
+	state := bc.State
+	parent := bc.Blocks[0]
+	now := parent.Timestamp + 100
+
+	// Blocks signed by another key prove nothing
+	forged := doubleSign(t, parent, otherKey)
+	forgedTx := signedTx(t, client.NewEvidence(reporter, validator, forged, 0), reporterKey)
+	if _, err := applyAt(state, now, forgedTx); !errors.Is(err, ErrInvalidEvidence) {
+		t.Fatalf("forged evidence = %v, want %v", err, ErrInvalidEvidence)
+	}
+
+	evidence := doubleSign(t, parent, validatorKey)
+	if _, err := applyAt(state, now, signedTx(t, client.NewEvidence(reporter, validator, evidence, 0), reporterKey)); err != nil {
+		t.Fatal(err)
+	}
+	val := state.GetAccount(validator).Validator
+	if val.Status != ValidatorJailed || val.JailedUntil != now+state.slashing.JailDuration || val.LastDoubleSign != 1 {
+		t.Fatalf("validator %s until %d, last double sign #%d", val.Status, val.JailedUntil, val.LastDoubleSign)
+	}
+
+	// The same fault is punished once
+	if _, err := applyAt(state, now, signedTx(t, client.NewEvidence(reporter, validator, evidence, 1), reporterKey)); !errors.Is(err, ErrEvidenceHandled) {
+		t.Fatalf("repeated evidence = %v, want %v", err, ErrEvidenceHandled)
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+// Transaction types. A staking transaction is sent to the sender's own
+// address and its Value is the amount staked or unstaked.
+const (
+	TxTransfer = ""
+	TxStake    = "stake"    // lock Value from the balance as validator stake
+	TxUnstake  = "unstake"  // start unbonding Value of the stake
+	TxWithdraw = "withdraw" // return unbonded stake to the balance
+)
+
+var (
+	ErrStakeTooLow         = errors.New("stake is below the minimum")
+	ErrValidatorSetFull    = errors.New("validator set is full and the stake does not exceed the lowest")
+	ErrNotValidator        = errors.New("address is not a validator")
+	ErrStakeLocked         = errors.New("stake is still locked")
+	ErrInsufficientStake   = errors.New("unstake amount exceeds stake")
+	ErrNothingToWithdraw   = errors.New("no unbonded stake to withdraw")
+	ErrStakingNotSupported = errors.New("staking is not enabled on this chain")
+)
+
+// ValidatorState is the stake an account has locked as a validator. The
+// record is kept once the validator has exited, with no stake.
+type ValidatorState struct {
+	Status       string   `json:"status"`    // one of the Validator* statuses
+	PublicKey    string   `json:"publicKey"` // key POS blocks are signed with
+	Stake        *big.Int `json:"stake"`
+	JoinedAt     int64    `json:"joinedAt"`
+	LockedUntil  int64    `json:"lockedUntil"` // the stake cannot be unstaked before this time
+	BlocksMinted int      `json:"blocksMinted"`
+
+	JailedUntil    int64        `json:"jailedUntil,omitempty"` // a jailed validator cannot unjail before this time
+	ExitAt         int64        `json:"exitAt,omitempty"`      // when an exiting validator leaves, or an exited one left
+	MissedSlots    int          `json:"missedSlots,omitempty"` // in a row
+	TotalMissed    int          `json:"totalMissed,omitempty"`
+	LastDoubleSign int64        `json:"lastDoubleSign,omitempty"` // height of the last double sign punished
+	RetiredKeys    []RetiredKey `json:"retiredKeys,omitempty"`    // keys rotated away from, oldest first
+}
+
+func (v *ValidatorState) copy() *ValidatorState {
+	c := *v
+	c.Stake = new(big.Int).Set(v.Stake)
+	c.RetiredKeys = append([]RetiredKey(nil), v.RetiredKeys...)
+	return &c
+}
+
+// Unbonding is unstaked funds waiting to be released to the balance
+type Unbonding struct {
+	Amount    *big.Int `json:"amount"`
+	ReleaseAt int64    `json:"releaseAt"`
+}
+
+// stakingRules are the chain parameters staking transactions are checked
+// against
+type stakingRules struct {
+	Enabled        bool
+	MinStake       *big.Int
+	Slots          int
+	LockDuration   int64
+	UnlockDuration int64
+	Commission     int64 // percent of the delegators' reward share validators keep
+}
+
+func (c ChainConfig) stakingRules() stakingRules {
+	minStake, err := parseAmount(c.MinStake)
+	if err != nil {
+		minStake = big.NewInt(0)
+	}
+	return stakingRules{
+		Enabled:        c.POSEnabled,
+		MinStake:       minStake,
+		Slots:          c.ValidatorSlots,
+		LockDuration:   c.StakeLock,
+		UnlockDuration: c.StakeUnlock,
+		Commission:     c.Commission,
+	}
+}
+
+// isStaking reports whether a transaction is a staking operation
+func isStaking(tx *Transaction) bool {
+	return tx.Type == TxStake || tx.Type == TxUnstake || tx.Type == TxWithdraw
+}
+
+// applyStaking carries out a staking transaction at block time now. The fee
+// and nonce are handled by the caller.
+func (s *State) applyStaking(tx *Transaction, value *big.Int, now int64) error {
+	if !s.staking.Enabled {
+		return ErrStakingNotSupported
+	}
+	switch tx.Type {
+	case TxStake:
+		return s.stake(tx.From, tx.PublicKey, value, now)
+	case TxUnstake:
+		return s.unstake(tx.From, value, now)
+	case TxWithdraw:
+		return s.withdraw(tx.From, now)
+	}
+	return fmt.Errorf("unknown transaction type %q", tx.Type)
+}
+
+// stake moves amount, already debited from the balance, into the validator
+// stake of address. A new or exited validator needs the minimum stake and a
+// free slot; when every slot is taken it must outbid the lowest effective
+// stake, which is evicted and starts unbonding. It is pending until the next
+// block.
+func (s *State) stake(address, publicKey string, amount *big.Int, now int64) error {
+	val := s.GetAccount(address).Validator
+	if val != nil && val.Status == ValidatorExiting {
+		return ErrValidatorExiting
+	}
+	if val != nil && val.Status != ValidatorExited {
+		acct := s.mutable(address)
+		acct.Validator.Stake.Add(acct.Validator.Stake, amount)
+		acct.Validator.LockedUntil = now + s.staking.LockDuration
+		return nil
+	}
+
+	if amount.Cmp(s.staking.MinStake) < 0 {
+		return fmt.Errorf("%w: %s < %s", ErrStakeTooLow, amount, s.staking.MinStake)
+	}
+	if validators := s.validatorAddresses(); len(validators) >= s.staking.Slots {
+		lowest := s.lowestStake(validators)
+		if amount.Cmp(effectiveStake(s.accounts[lowest])) <= 0 {
+			return ErrValidatorSetFull
+		}
+		s.releaseStake(lowest, now, now+s.staking.UnlockDuration)
+	}
+
+	joined := &ValidatorState{
+		Status:      ValidatorPending,
+		PublicKey:   publicKey,
+		Stake:       new(big.Int).Set(amount),
+		JoinedAt:    now,
+		LockedUntil: now + s.staking.LockDuration,
+	}
+	// A validator rejoining keeps its history
+	if val != nil {
+		joined.BlocksMinted = val.BlocksMinted
+		joined.TotalMissed = val.TotalMissed
+		joined.LastDoubleSign = val.LastDoubleSign
+	}
+	s.mutable(address).Validator = joined
+	return nil
+}
+
+// unstake starts unbonding amount of a validator's stake. What remains must
+// still meet the minimum; unstaking everything leaves the validator set.
+func (s *State) unstake(address string, amount *big.Int, now int64) error {
+	val := s.GetAccount(address).Validator
+	if val == nil || val.Status == ValidatorExited {
+		return ErrNotValidator
+	}
+	if val.Status == ValidatorExiting {
+		return ErrValidatorExiting
+	}
+	if now < val.LockedUntil {
+		return fmt.Errorf("%w until %d", ErrStakeLocked, val.LockedUntil)
+	}
+	remaining := new(big.Int).Sub(val.Stake, amount)
+	if remaining.Sign() < 0 {
+		return ErrInsufficientStake
+	}
+	if remaining.Sign() > 0 && remaining.Cmp(s.staking.MinStake) < 0 {
+		return fmt.Errorf("%w: %s would remain, unstake everything to leave", ErrStakeTooLow, remaining)
+	}
+
+	if remaining.Sign() == 0 {
+		s.releaseStake(address, now, now+s.staking.UnlockDuration)
+		return nil
+	}
+	acct := s.mutable(address)
+	acct.Unbonding = append(acct.Unbonding, Unbonding{Amount: new(big.Int).Set(amount), ReleaseAt: now + s.staking.UnlockDuration})
+	acct.Validator.Stake = remaining
+	return nil
+}
+
+// releaseStake takes a validator out of the set at time now and unbonds all
+// of its stake, to be released at releaseAt
+func (s *State) releaseStake(address string, now, releaseAt int64) {
+	acct := s.mutable(address)
+	acct.Unbonding = append(acct.Unbonding, Unbonding{Amount: acct.Validator.Stake, ReleaseAt: releaseAt})
+	acct.Validator.Stake = big.NewInt(0)
+	acct.Validator.Status = ValidatorExited
+	acct.Validator.ExitAt = now
+	acct.Validator.JailedUntil = 0
+	acct.Validator.MissedSlots = 0
+}
+
+// withdraw returns every unbonding entry released by now to the balance
+func (s *State) withdraw(address string, now int64) error {
+	released := big.NewInt(0)
+	for _, entry := range s.GetAccount(address).Unbonding {
+		if entry.ReleaseAt <= now {
+			released.Add(released, entry.Amount)
+		}
+	}
+	if released.Sign() == 0 {
+		return ErrNothingToWithdraw
+	}
+
+	acct := s.mutable(address)
+	pending := []Unbonding{}
+	for _, entry := range acct.Unbonding {
+		if entry.ReleaseAt > now {
+			pending = append(pending, entry)
+		}
+	}
+	acct.Unbonding = pending
+	if len(acct.Unbonding) == 0 {
+		acct.Unbonding = nil
+	}
+	acct.Balance.Add(acct.Balance, released)
+	return nil
+}
+
+// validatorAddresses returns the addresses holding a validator slot, every
+// validator that has not exited, in ascending order
+func (s *State) validatorAddresses() []string {
+	addresses := []string{}
+	for address, acct := range s.accounts {
+		if acct.Validator != nil && acct.Validator.Status != ValidatorExited {
+			addresses = append(addresses, address)
+		}
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+// lowestStake returns the validator first in line for eviction: the lowest
+// effective stake, and among equal stakes the one that joined last
+func (s *State) lowestStake(validators []string) string {
+	lowest := validators[0]
+	for _, address := range validators[1:] {
+		val, low := s.accounts[address], s.accounts[lowest]
+		cmp := effectiveStake(val).Cmp(effectiveStake(low))
+		if cmp < 0 || (cmp == 0 && val.Validator.JoinedAt > low.Validator.JoinedAt) {
+			lowest = address
+		}
+	}
+	return lowest
+}
+
+// Validators returns the validator set held in the state
+func (s *State) Validators() map[string]Validator {
+	validators := make(map[string]Validator)
+	for _, address := range s.validatorAddresses() {
+		validators[address] = validatorView(address, s.accounts[address])
+	}
+	return validators
+}
+
+// Validator returns the validator record of an address, including one that
+// has exited
+func (s *State) Validator(address string) (Validator, bool) {
+	address = normalizeAddress(address)
+	acct := s.GetAccount(address)
+	if acct.Validator == nil {
+		return Validator{}, false
+	}
+	return validatorView(address, &acct), true
+}
+
+// Validator returns the validator record of an address, including one that
+// has exited
+func (bc *Blockchain) Validator(address string) (Validator, bool) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.State.Validator(address)
+}
+
+func validatorView(address string, acct *AccountState) Validator {
+	val := acct.Validator
+	delegated := big.NewInt(0)
+	if acct.Pool != nil {
+		delegated = acct.Pool.Delegated
+	}
+	return Validator{
+		Address:        address,
+		PublicKey:      val.PublicKey,
+		Stake:          val.Stake.String(),
+		Delegated:      delegated.String(),
+		EffectiveStake: effectiveStake(acct).String(),
+		State:          val.Status,
+		Active:         val.Status == ValidatorActive,
+		JailedUntil:    val.JailedUntil,
+		ExitAt:         val.ExitAt,
+		MissedSlots:    val.MissedSlots,
+		TotalMissed:    val.TotalMissed,
+		Uptime:         uptime(val),
+		JoinedAt:       val.JoinedAt,
+		LockedUntil:    val.LockedUntil,
+		BlocksMinted:   val.BlocksMinted,
+	}
+}
+
+// unbondingTotal sums the unbonding entries of an account
+func unbondingTotal(acct *AccountState) *big.Int {
+	total := big.NewInt(0)
+	for _, entry := range acct.Unbonding {
+		total.Add(total, entry.Amount)
+	}
+	return total
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"gydschain/client"
+)
+
+// gyds is n tenths of a GYDS
+func gyds(n int64) *big.Int {
+	return new(big.Int).Mul(big.NewInt(n), big.NewInt(1e17))
+}
+
+// applyAt applies tx to state in a POW block at time now, after moving the
+// validators along as the block would. It returns the fee paid.
+func applyAt(state *State, now int64, tx Transaction) (*big.Int, error) {
+	state.advanceValidators(now)
+	receipt, err := state.ApplyTransaction(&tx, &Block{Timestamp: now, Type: "POW", Miner: "0x1111111111111111111111111111111111111111"})
+	if err != nil {
+		return nil, err
+	}
+	fee, _ := new(big.Int).SetString(receipt.Fee, 10)
+	return fee, nil
+}
+
+// fundedState is the state of a chain at genesis where each address holds
+// 10 GYDS
+func fundedState(t *testing.T, addresses ...string) *State {
+	t.Helper()
+	genesis := testGenesis(t)
+	genesis.Alloc = map[string]GenesisAccount{}
+	for _, address := range addresses {
+		genesis.Alloc[address] = GenesisAccount{Balance: gyds(100).String()}
+	}
+	return testChain(t, genesis).State
+}
+
+func TestStakeUnbondsBeforeWithdrawal(t *testing.T) {
+	key, address := testKey(t)
+	state := fundedState(t, address)
+	rules := state.staking
+	now := int64(1_700_000_000)
+
+	// Below the minimum nothing is locked
+	if _, err := applyAt(state, now, signedTx(t, client.NewStake(address, gyds(5), 0), key)); !errors.Is(err, ErrStakeTooLow) {
+		t.Fatalf("stake below minimum = %v, want %v", err, ErrStakeTooLow)
+	}
+	if state.GetNonce(address) != 0 || state.GetBalance(address).Cmp(gyds(100)) != 0 {
+		t.Fatal("rejected stake changed the account")
+	}
+
+	balance := gyds(100)
+	fee, err := applyAt(state, now, signedTx(t, client.NewStake(address, gyds(20), 0), key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	balance.Sub(balance, gyds(20)).Sub(balance, fee)
+	if state.GetBalance(address).Cmp(balance) != 0 {
+		t.Fatalf("balance = %s, want %s", state.GetBalance(address), balance)
+	}
+	if val, _ := state.Validator(address); val.State != ValidatorPending {
+		t.Fatalf("new validator is %s, want %s", val.State, ValidatorPending)
+	}
+
+	// The stake is locked, and what stays staked must meet the minimum
+	unlocked := now + rules.LockDuration
+	if _, err := applyAt(state, unlocked-1, signedTx(t, client.NewUnstake(address, gyds(10), 1), key)); !errors.Is(err, ErrStakeLocked) {
+		t.Fatalf("unstake while locked = %v, want %v", err, ErrStakeLocked)
+	}
+	if _, ok := state.Validators()[address]; !ok {
+		t.Fatal("validator not active after the next block")
+	}
+	if _, err := applyAt(state, unlocked, signedTx(t, client.NewUnstake(address, gyds(15), 1), key)); !errors.Is(err, ErrStakeTooLow) {
+		t.Fatalf("unstake leaving too little = %v, want %v", err, ErrStakeTooLow)
+	}
+	fee, err = applyAt(state, unlocked, signedTx(t, client.NewUnstake(address, gyds(10), 1), key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	balance.Sub(balance, fee)
+	if val, _ := state.Validator(address); val.Stake != gyds(10).String() {
+		t.Fatalf("stake = %s after unstaking half", val.Stake)
+	}
+
+	// The unstaked half is released once the unbonding period is over
+	released := unlocked + rules.UnlockDuration
+	if _, err := applyAt(state, released-1, signedTx(t, client.NewWithdraw(address, 2), key)); !errors.Is(err, ErrNothingToWithdraw) {
+		t.Fatalf("withdraw while unbonding = %v, want %v", err, ErrNothingToWithdraw)
+	}
+	fee, err = applyAt(state, released, signedTx(t, client.NewWithdraw(address, 2), key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	balance.Add(balance, gyds(10)).Sub(balance, fee)
+	if state.GetBalance(address).Cmp(balance) != 0 || len(state.GetAccount(address).Unbonding) != 0 {
+		t.Fatalf("balance = %s, want %s", state.GetBalance(address), balance)
+	}
+}
+
+func TestFullValidatorSetEvictsLowestStake(t *testing.T) {
+	lowKey, low := testKey(t)
+	highKey, high := testKey(t)
+	state := fundedState(t, low, high)
+	state.staking.Slots = 1
+	now := int64(1_700_000_000)
+
+	if _, err := applyAt(state, now, signedTx(t, client.NewStake(low, gyds(20), 0), lowKey)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := applyAt(state, now, signedTx(t, client.NewStake(high, gyds(20), 0), highKey)); !errors.Is(err, ErrValidatorSetFull) {
+		t.Fatalf("equal stake into a full set = %v, want %v", err, ErrValidatorSetFull)
+	}
+	if _, err := applyAt(state, now, signedTx(t, client.NewStake(high, gyds(30), 0), highKey)); err != nil {
+		t.Fatal(err)
+	}
+
+	evicted, _ := state.Validator(low)
+	unbonding := state.GetAccount(low).Unbonding
+	if evicted.State != ValidatorExited || len(unbonding) != 1 || unbonding[0].Amount.Cmp(gyds(20)) != 0 {
+		t.Fatalf("evicted validator %s, unbonding %+v", evicted.State, unbonding)
+	}
+	if unbonding[0].ReleaseAt != now+state.staking.UnlockDuration {
+		t.Fatalf("evicted stake released at %d", unbonding[0].ReleaseAt)
+	}
+}
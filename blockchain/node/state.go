@@ -0,0 +1,374 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrInsufficientFunds = errors.New("insufficient funds for value + fee")
+	ErrNonceTooLow       = errors.New("nonce too low")
+	ErrNonceTooHigh      = errors.New("nonce too high")
+)
+
+// AccountState is the on-chain balance and nonce of an address, with the
+// stake it has locked as a validator, the stake delegated to it, its own
+// delegations and any stake still unbonding
+type AccountState struct {
+	Balance     *big.Int        `json:"balance"`
+	Nonce       int64           `json:"nonce"`
+	Validator   *ValidatorState `json:"validator,omitempty"`
+	Unbonding   []Unbonding     `json:"unbonding,omitempty"`
+	Pool        *DelegationPool `json:"pool,omitempty"`
+	Delegations []Delegation    `json:"delegations,omitempty"`
+}
+
+func (a *AccountState) copy() *AccountState {
+	c := &AccountState{
+		Balance: new(big.Int).Set(a.Balance),
+		Nonce:   a.Nonce,
+	}
+	if a.Validator != nil {
+		c.Validator = a.Validator.copy()
+	}
+	for _, entry := range a.Unbonding {
+		c.Unbonding = append(c.Unbonding, Unbonding{Amount: new(big.Int).Set(entry.Amount), ReleaseAt: entry.ReleaseAt})
+	}
+	if a.Pool != nil {
+		c.Pool = a.Pool.copy()
+	}
+	for _, del := range a.Delegations {
+		c.Delegations = append(c.Delegations, del.copy())
+	}
+	return c
+}
+
+// complete reports whether every amount of an account decoded from a peer is
+// present
+func (a *AccountState) complete() bool {
+	if a.Validator != nil && a.Validator.Stake == nil {
+		return false
+	}
+	for _, entry := range a.Unbonding {
+		if entry.Amount == nil {
+			return false
+		}
+	}
+	if a.Pool != nil && (a.Pool.Delegated == nil || a.Pool.RewardPerShare == nil) {
+		return false
+	}
+	for _, del := range a.Delegations {
+		if del.Amount == nil || del.Rewards == nil || del.RewardEntry == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// State is the account ledger. Every change is journaled so a failed
+// transaction or block can be reverted and so the changes made since the
+// last commit can be persisted.
+type State struct {
+	accounts map[string]*AccountState
+	journal  []stateChange
+	staking  stakingRules
+	slashing slashingRules
+	burned   *big.Int // stake slashed and burned by the block last applied
+}
+
+// stateChange records the value an account had before it was modified
+type stateChange struct {
+	address string
+	prev    *AccountState // nil if the account did not exist
+}
+
+// NewState creates an empty account ledger
+func NewState() *State {
+	return &State{accounts: make(map[string]*AccountState), burned: big.NewInt(0)}
+}
+
+func normalizeAddress(address string) string {
+	return strings.ToLower(address)
+}
+
+// GetAccount returns a copy of an account, or an empty account if unknown
+func (s *State) GetAccount(address string) AccountState {
+	if acct, ok := s.accounts[normalizeAddress(address)]; ok {
+		return *acct.copy()
+	}
+	return AccountState{Balance: big.NewInt(0)}
+}
+
+// GetBalance returns the balance of an address
+func (s *State) GetBalance(address string) *big.Int {
+	return s.GetAccount(address).Balance
+}
+
+// GetNonce returns the next expected nonce of an address
+func (s *State) GetNonce(address string) int64 {
+	return s.GetAccount(address).Nonce
+}
+
+// mutable loads an account for modification, journaling its prior value
+func (s *State) mutable(address string) *AccountState {
+	address = normalizeAddress(address)
+	acct, ok := s.accounts[address]
+	if ok {
+		s.journal = append(s.journal, stateChange{address: address, prev: acct.copy()})
+		return acct
+	}
+	s.journal = append(s.journal, stateChange{address: address})
+	acct = &AccountState{Balance: big.NewInt(0)}
+	s.accounts[address] = acct
+	return acct
+}
+
+// AddBalance credits amount to an address
+func (s *State) AddBalance(address string, amount *big.Int) {
+	acct := s.mutable(address)
+	acct.Balance.Add(acct.Balance, amount)
+}
+
+// SubBalance debits amount from an address
+func (s *State) SubBalance(address string, amount *big.Int) error {
+	if s.GetBalance(address).Cmp(amount) < 0 {
+		return ErrInsufficientFunds
+	}
+	acct := s.mutable(address)
+	acct.Balance.Sub(acct.Balance, amount)
+	return nil
+}
+
+// Snapshot returns an identifier that RevertToSnapshot can roll back to
+func (s *State) Snapshot() int {
+	return len(s.journal)
+}
+
+// RevertToSnapshot undoes every change made since the snapshot was taken
+func (s *State) RevertToSnapshot(id int) {
+	for i := len(s.journal) - 1; i >= id; i-- {
+		change := s.journal[i]
+		if change.prev == nil {
+			delete(s.accounts, change.address)
+		} else {
+			s.accounts[change.address] = change.prev
+		}
+	}
+	s.journal = s.journal[:id]
+}
+
+// changesSince returns the journaled prior values of accounts modified since
+// the snapshot, in the order they were modified
+func (s *State) changesSince(id int) []accountUndo {
+	changes := make([]accountUndo, 0, len(s.journal)-id)
+	for _, change := range s.journal[id:] {
+		var prev *AccountState
+		if change.prev != nil {
+			prev = change.prev.copy()
+		}
+		changes = append(changes, accountUndo{Address: change.address, Prev: prev})
+	}
+	return changes
+}
+
+// revert restores accounts to the values recorded by changesSince. The
+// restoration is itself journaled so the next commit persists it.
+func (s *State) revert(changes []accountUndo) {
+	for i := len(changes) - 1; i >= 0; i-- {
+		change := changes[i]
+		if acct, ok := s.accounts[change.Address]; ok {
+			s.journal = append(s.journal, stateChange{address: change.Address, prev: acct.copy()})
+		} else {
+			s.journal = append(s.journal, stateChange{address: change.Address})
+		}
+		if change.Prev == nil {
+			delete(s.accounts, change.Address)
+		} else {
+			s.accounts[change.Address] = change.Prev.copy()
+		}
+	}
+}
+
+// sortedAddresses returns every account address in ascending order
+func (s *State) sortedAddresses() []string {
+	addresses := make([]string, 0, len(s.accounts))
+	for address := range s.accounts {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+// Exists reports whether an address has an account in the ledger
+func (s *State) Exists(address string) bool {
+	_, ok := s.accounts[normalizeAddress(address)]
+	return ok
+}
+
+// Commit clears the journal and returns the addresses modified since the last commit
+func (s *State) Commit() []string {
+	seen := make(map[string]bool)
+	dirty := make([]string, 0)
+	for _, change := range s.journal {
+		if !seen[change.address] {
+			seen[change.address] = true
+			dirty = append(dirty, change.address)
+		}
+	}
+	s.journal = nil
+	return dirty
+}
+
+// CheckTransaction verifies a transaction could currently be applied
+func (s *State) CheckTransaction(tx *Transaction) error {
+	acct := s.GetAccount(tx.From)
+	if tx.Nonce < acct.Nonce {
+		return fmt.Errorf("%w: have %d, want %d", ErrNonceTooLow, tx.Nonce, acct.Nonce)
+	}
+	if tx.Nonce > acct.Nonce {
+		return fmt.Errorf("%w: have %d, want %d", ErrNonceTooHigh, tx.Nonce, acct.Nonce)
+	}
+
+	cost, err := transactionCost(tx)
+	if err != nil {
+		return err
+	}
+	if acct.Balance.Cmp(cost) < 0 {
+		return ErrInsufficientFunds
+	}
+	return nil
+}
+
+// ApplyTransaction executes a transaction in block: a transfer moves Value
+// from sender to recipient, a staking transaction updates the sender's stake
+// and a delegation transaction its delegation to the recipient.
+// The fee goes to the block producer and the sender's nonce is bumped.
+// The receipt records the outcome; where the transaction was included is
+// left for the caller to fill in.
+func (s *State) ApplyTransaction(tx *Transaction, block *Block) (Receipt, error) {
+	if err := s.CheckTransaction(tx); err != nil {
+		return Receipt{}, err
+	}
+
+	value, _ := new(big.Int).SetString(tx.Value, 10)
+	fee, err := CalculateTransactionFee(tx.Gas, tx.GasPrice)
+	if err != nil {
+		return Receipt{}, err
+	}
+
+	snapshot := s.Snapshot()
+	if err := s.SubBalance(tx.From, new(big.Int).Add(transferredValue(tx, value), fee)); err != nil {
+		s.RevertToSnapshot(snapshot)
+		return Receipt{}, err
+	}
+	s.mutable(tx.From).Nonce++
+	switch {
+	case isStaking(tx):
+		err = s.applyStaking(tx, value, block.Timestamp)
+	case isDelegation(tx):
+		err = s.applyDelegation(tx, value, block.Timestamp)
+	case isLifecycle(tx):
+		err = s.applyLifecycle(tx, block)
+	case tx.Type == TxEvidence:
+		err = s.applyEvidence(tx, block.Timestamp)
+	default:
+		s.AddBalance(tx.To, value)
+	}
+	if err != nil {
+		s.RevertToSnapshot(snapshot)
+		return Receipt{}, err
+	}
+	s.AddBalance(blockProducer(block), fee)
+	return Receipt{
+		TxHash:  tx.Hash,
+		From:    tx.From,
+		To:      tx.To,
+		Status:  ReceiptSuccess,
+		GasUsed: tx.Gas,
+		Fee:     fee.String(),
+	}, nil
+}
+
+// ApplyBlock applies a block on top of parent: it records whether the slot's
+// proposer produced it, moves validators along their lifecycle, applies
+// every transaction and credits the block reward to its producer. It returns
+// the receipts of the transactions. On error the state is left unchanged.
+func (s *State) ApplyBlock(block, parent *Block) ([]Receipt, error) {
+	coinbase := blockProducer(block)
+	snapshot := s.Snapshot()
+	s.burned = big.NewInt(0)
+
+	s.recordSlot(block, parent)
+	s.advanceValidators(block.Timestamp)
+
+	receipts := make([]Receipt, len(block.Transactions))
+	var cumulative int64
+	for i := range block.Transactions {
+		receipt, err := s.ApplyTransaction(&block.Transactions[i], block)
+		if err != nil {
+			s.RevertToSnapshot(snapshot)
+			return nil, fmt.Errorf("transaction %d (%s): %w", i, block.Transactions[i].Hash, err)
+		}
+		cumulative += receipt.GasUsed
+		receipt.BlockHash = block.Hash
+		receipt.BlockNumber = block.Index
+		receipt.Index = i
+		receipt.CumulativeGasUsed = cumulative
+		receipts[i] = receipt
+	}
+
+	if coinbase != "" {
+		reward, ok := new(big.Int).SetString(block.Reward, 10)
+		if !ok {
+			s.RevertToSnapshot(snapshot)
+			return nil, errors.New("invalid block reward")
+		}
+		if block.Type == "POS" {
+			s.payStakeReward(coinbase, reward)
+		} else {
+			s.AddBalance(coinbase, reward)
+		}
+	}
+	if block.Type == "POS" && s.GetAccount(coinbase).Validator != nil {
+		s.mutable(coinbase).Validator.BlocksMinted++
+	}
+	return receipts, nil
+}
+
+// blockProducer returns the address credited with a block's reward and fees
+func blockProducer(block *Block) string {
+	switch block.Type {
+	case "POW":
+		return block.Miner
+	case "POS":
+		return block.Validator
+	}
+	return ""
+}
+
+// transactionCost returns what a transaction debits from the sender: the
+// maximum fee plus Value for a transfer, a stake or a delegation. The other
+// staking and delegation operations only cost the fee.
+func transactionCost(tx *Transaction) (*big.Int, error) {
+	value, ok := new(big.Int).SetString(tx.Value, 10)
+	if !ok {
+		return nil, errors.New("invalid amount format")
+	}
+	fee, err := CalculateTransactionFee(tx.Gas, tx.GasPrice)
+	if err != nil {
+		return nil, err
+	}
+	return fee.Add(fee, transferredValue(tx, value)), nil
+}
+
+// transferredValue is the part of Value debited from the sender's balance
+func transferredValue(tx *Transaction, value *big.Int) *big.Int {
+	switch tx.Type {
+	case TxUnstake, TxWithdraw, TxUndelegate, TxClaim, TxEvidence, TxUnjail, TxExit, TxRotateKey:
+		return big.NewInt(0)
+	}
+	return value
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"gydschain/client"
+)
+
+func TestTransactionsCheckedAgainstAccount(t *testing.T) {
+	key, sender := testKey(t)
+	genesis := testGenesis(t)
+	genesis.Alloc = map[string]GenesisAccount{sender: {Balance: "10000000000000000000"}}
+	bc := testChain(t, genesis)
+	recipient := "0x2222222222222222222222222222222222222222"
+
+	if err := bc.AddTransaction(signedTx(t, client.NewTransfer(sender, recipient, big.NewInt(5), 0), key)); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, bc, "0x1111111111111111111111111111111111111111", 1)
+	if nonce := bc.State.GetNonce(sender); nonce != 1 {
+		t.Fatalf("nonce = %d, want 1", nonce)
+	}
+
+	// Blocks on top of #1 are built from an empty one mined elsewhere
+	other := testChain(t, genesis)
+	if err := other.AddBlock(bc.Blocks[1]); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, bc, "0x1111111111111111111111111111111111111111", 1)
+	root := other.State.Root()
+
+	overspend, _ := new(big.Int).SetString("10000000000000000000", 10)
+	for _, c := range []struct {
+		tx   *client.Transaction
+		want error
+	}{
+		{client.NewTransfer(sender, recipient, big.NewInt(5), 0), ErrNonceTooLow},
+		{client.NewTransfer(sender, recipient, big.NewInt(5), 2), ErrNonceTooHigh},
+		{client.NewTransfer(sender, recipient, overspend, 1), ErrInsufficientFunds},
+	} {
+		tx := signedTx(t, c.tx, key)
+		if err := other.State.CheckTransaction(&tx); !errors.Is(err, c.want) {
+			t.Errorf("CheckTransaction = %v, want %v", err, c.want)
+		}
+
+		// A block including it is rejected and leaves the state alone
+		block := reseal(bc.Blocks[2], func(b *Block) {
+			b.Transactions = []Transaction{tx}
+			b.TxRoot = transactionsRoot(b.Transactions)
+		})
+		if err := other.AddBlock(block); !errors.Is(err, c.want) {
+			t.Errorf("AddBlock = %v, want %v", err, c.want)
+		}
+		if other.Height() != 1 || other.State.Root() != root {
+			t.Errorf("rejected block changed the chain")
+		}
+	}
+}
+
+func TestRevertToSnapshot(t *testing.T) {
+	bc := testChain(t, testGenesis(t))
+	state := bc.State
+	sender, recipient := "0x000000000000000000000000000000000000000a", "0x000000000000000000000000000000000000000b"
+	state.AddBalance(sender, big.NewInt(100))
+	root := state.Root()
+
+	snapshot := state.Snapshot()
+	if err := state.SubBalance(sender, big.NewInt(40)); err != nil {
+		t.Fatal(err)
+	}
+	state.AddBalance(recipient, big.NewInt(40))
+	state.mutable(sender).Nonce++
+	if err := state.SubBalance(sender, big.NewInt(61)); err == nil {
+		t.Fatal("balance went negative")
+	}
+
+	state.RevertToSnapshot(snapshot)
+	if balance := state.GetBalance(sender); balance.Cmp(big.NewInt(100)) != 0 || state.GetNonce(sender) != 0 {
+		t.Fatalf("sender = %s nonce %d after revert", balance, state.GetNonce(sender))
+	}
+	if state.Exists(recipient) {
+		t.Fatal("account created after the snapshot survived the revert")
+	}
+	if state.Root() != root {
+		t.Fatal("state root changed by a reverted snapshot")
+	}
+}
@@ -0,0 +1,589 @@
+package main
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrNotFound is returned by a Store when a key does not exist
+var ErrNotFound = errors.New("key not found")
+
+// Store is the key-value interface the node persists chain data through
+type Store interface {
+	Get(key string) ([]byte, error)
+	Has(key string) bool
+	Put(key string, value []byte) error
+	Delete(key string) error
+	Write(batch *Batch) error
+	Iterate(prefix string, fn func(key string, value []byte) error) error
+	Close() error
+}
+
+// Batch collects writes that are applied to a Store atomically
+type Batch struct {
+	ops []batchOp
+}
+
+type batchOp struct {
+	del   bool
+	key   string
+	value []byte
+	pos   int // where value starts in the encoded batch
+}
+
+// Put queues a key/value write
+func (b *Batch) Put(key string, value []byte) {
+	b.ops = append(b.ops, batchOp{key: key, value: value})
+}
+
+// Delete queues a key removal
+func (b *Batch) Delete(key string) {
+	b.ops = append(b.ops, batchOp{del: true, key: key})
+}
+
+// Len returns the number of queued operations
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// MemoryStore is a non-persistent Store, useful for ephemeral nodes
+type MemoryStore struct {
+	data map[string][]byte
+	mu   sync.RWMutex
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: make(map[string][]byte)}
+}
+
+func (s *MemoryStore) Get(key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.data[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), value...), nil
+}
+
+func (s *MemoryStore) Has(key string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.data[key]
+	return ok
+}
+
+func (s *MemoryStore) Put(key string, value []byte) error {
+	batch := &Batch{}
+	batch.Put(key, value)
+	return s.Write(batch)
+}
+
+func (s *MemoryStore) Delete(key string) error {
+	batch := &Batch{}
+	batch.Delete(key)
+	return s.Write(batch)
+}
+
+func (s *MemoryStore) Write(batch *Batch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	applyBatch(s.data, batch)
+	return nil
+}
+
+func (s *MemoryStore) Iterate(prefix string, fn func(key string, value []byte) error) error {
+	s.mu.RLock()
+	keys, values := sortedEntries(s.data, prefix)
+	s.mu.RUnlock()
+
+	for i, key := range keys {
+		if err := fn(key, values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// FileStore is the default Store: an append-only log indexed in memory on
+// open. Each record is [length uint32][crc32 uint32][payload] where the payload
+// holds one or more operations, so a Batch is always written as a single
+// record. Values are read back from the log; the most recently used ones are
+// kept in memory up to the cache size.
+type FileStore struct {
+	path  string
+	file  *os.File
+	index map[string]valueRef
+	cache *valueCache
+	size  int64
+	mu    sync.RWMutex
+}
+
+// valueRef locates a value in the log
+type valueRef struct {
+	offset int64
+	length int
+}
+
+const (
+	storeFileName     = "chaindata.log"
+	storeOpPut        = 1
+	storeOpDelete     = 2
+	compactMinLogSize = 4 * 1024 * 1024 // don't bother compacting small logs
+)
+
+// OpenFileStore opens (or creates) a file-backed store inside dir, keeping up
+// to cacheSize bytes of values in memory
+func OpenFileStore(dir string, cacheSize int64) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	s := &FileStore{
+		path:  filepath.Join(dir, storeFileName),
+		index: make(map[string]valueRef),
+		cache: newValueCache(cacheSize),
+	}
+
+	file, err := os.OpenFile(s.path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	// Replay the log, dropping a torn record left by a crash mid-write
+	valid, err := s.replay(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Truncate(valid); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(valid, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	s.file = file
+	s.size = valid
+
+	if s.size > compactMinLogSize && s.size > 2*s.liveSize() {
+		if err := s.compact(); err != nil {
+			s.file.Close()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+func (s *FileStore) replay(file *os.File) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	reader := bufio.NewReader(file)
+	header := make([]byte, 8)
+	var offset int64
+
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return offset, nil
+		}
+		length := binary.BigEndian.Uint32(header[0:4])
+		checksum := binary.BigEndian.Uint32(header[4:8])
+
+		// A corrupt length must not allocate more than the log holds
+		if int64(length) > info.Size()-offset-int64(len(header)) {
+			return offset, nil
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return offset, nil
+		}
+		if crc32.ChecksumIEEE(payload) != checksum {
+			return offset, nil
+		}
+
+		batch, err := decodeBatch(payload)
+		if err != nil {
+			return offset, nil
+		}
+		s.indexBatch(batch, offset+int64(len(header)))
+		offset += int64(len(header)) + int64(length)
+	}
+}
+
+// indexBatch points the index at the values of a batch whose encoding starts
+// at offset in the log, and drops the cached values it replaces. Callers must
+// hold s.mu.
+func (s *FileStore) indexBatch(batch *Batch, offset int64) {
+	for _, op := range batch.ops {
+		s.cache.remove(op.key)
+		if op.del {
+			delete(s.index, op.key)
+		} else {
+			s.index[op.key] = valueRef{offset: offset + int64(op.pos), length: len(op.value)}
+		}
+	}
+}
+
+// load returns a copy of a value, from the cache or else the log. Callers
+// must hold s.mu.
+func (s *FileStore) load(key string, ref valueRef) ([]byte, error) {
+	if value, ok := s.cache.get(key); ok {
+		return value, nil
+	}
+	if s.file == nil {
+		return nil, errors.New("store is closed")
+	}
+	value := make([]byte, ref.length)
+	if _, err := s.file.ReadAt(value, ref.offset); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (s *FileStore) Get(key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ref, ok := s.index[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	value, err := s.load(key, ref)
+	if err != nil {
+		return nil, err
+	}
+	s.cache.add(key, value)
+	return value, nil
+}
+
+func (s *FileStore) Has(key string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.index[key]
+	return ok
+}
+
+func (s *FileStore) Put(key string, value []byte) error {
+	batch := &Batch{}
+	batch.Put(key, value)
+	return s.Write(batch)
+}
+
+func (s *FileStore) Delete(key string) error {
+	batch := &Batch{}
+	batch.Delete(key)
+	return s.Write(batch)
+}
+
+func (s *FileStore) Write(batch *Batch) error {
+	if batch.Len() == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return errors.New("store is closed")
+	}
+
+	record := encodeRecord(encodeBatch(batch))
+	if _, err := s.file.Write(record); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+
+	s.indexBatch(batch, s.size+8)
+	s.size += int64(len(record))
+	for _, op := range batch.ops {
+		if !op.del {
+			s.cache.add(op.key, op.value)
+		}
+	}
+	return nil
+}
+
+// Iterate reads the values it visits without caching them, so a scan does
+// not push out the values in use
+func (s *FileStore) Iterate(prefix string, fn func(key string, value []byte) error) error {
+	s.mu.RLock()
+	keys := s.sortedKeys(prefix)
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		value, err := s.load(key, s.index[key])
+		if err != nil {
+			s.mu.RUnlock()
+			return err
+		}
+		values[i] = value
+	}
+	s.mu.RUnlock()
+
+	for i, key := range keys {
+		if err := fn(key, values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortedKeys returns the stored keys with prefix in order. Callers must hold
+// s.mu.
+func (s *FileStore) sortedKeys(prefix string) []string {
+	keys := make([]string, 0)
+	for key := range s.index {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Compact rewrites the log so it only contains live keys
+func (s *FileStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.compact()
+}
+
+func (s *FileStore) compact() error {
+	tmpPath := s.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	index := make(map[string]valueRef, len(s.index))
+	writer := bufio.NewWriter(tmp)
+	var size int64
+	for _, key := range s.sortedKeys("") {
+		value, err := s.load(key, s.index[key])
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		batch := &Batch{}
+		batch.Put(key, value)
+		record := encodeRecord(encodeBatch(batch))
+		if _, err := writer.Write(record); err != nil {
+			tmp.Close()
+			return err
+		}
+		index[key] = valueRef{offset: size + 8 + int64(batch.ops[0].pos), length: len(value)}
+		size += int64(len(record))
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		tmp.Close()
+		return err
+	}
+	s.file.Close()
+	s.file = tmp
+	s.index = index
+	s.size = size
+	return nil
+}
+
+func (s *FileStore) liveSize() int64 {
+	var size int64
+	for key, ref := range s.index {
+		size += int64(len(key) + ref.length + 16)
+	}
+	return size
+}
+
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// valueCache holds the most recently used values up to a total size in bytes
+type valueCache struct {
+	limit int64
+	size  int64
+	items map[string]*list.Element
+	order *list.List // most recently used first
+	mu    sync.Mutex
+}
+
+type cachedValue struct {
+	key   string
+	value []byte
+}
+
+func newValueCache(limit int64) *valueCache {
+	return &valueCache{limit: limit, items: make(map[string]*list.Element), order: list.New()}
+}
+
+// get returns a copy of a cached value
+func (c *valueCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	item, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(item)
+	return append([]byte(nil), item.Value.(*cachedValue).value...), true
+}
+
+// add caches a copy of value, evicting the least recently used values to
+// make room. Values larger than the whole cache are not kept.
+func (c *valueCache) add(key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeLocked(key)
+	cost := int64(len(key) + len(value))
+	if cost > c.limit {
+		return
+	}
+	c.items[key] = c.order.PushFront(&cachedValue{key: key, value: append([]byte(nil), value...)})
+	c.size += cost
+	for c.size > c.limit {
+		c.removeLocked(c.order.Back().Value.(*cachedValue).key)
+	}
+}
+
+func (c *valueCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeLocked(key)
+}
+
+func (c *valueCache) removeLocked(key string) {
+	item, ok := c.items[key]
+	if !ok {
+		return
+	}
+	cached := c.order.Remove(item).(*cachedValue)
+	delete(c.items, key)
+	c.size -= int64(len(cached.key) + len(cached.value))
+}
+
+func applyBatch(data map[string][]byte, batch *Batch) {
+	for _, op := range batch.ops {
+		if op.del {
+			delete(data, op.key)
+		} else {
+			data[op.key] = append([]byte(nil), op.value...)
+		}
+	}
+}
+
+func sortedEntries(data map[string][]byte, prefix string) ([]string, [][]byte) {
+	keys := make([]string, 0)
+	for key := range data {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		values[i] = append([]byte(nil), data[key]...)
+	}
+	return keys, values
+}
+
+func encodeRecord(payload []byte) []byte {
+	record := make([]byte, 8+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[8:], payload)
+	return record
+}
+
+// encodeBatch encodes the operations of a batch, noting where each value
+// starts in the encoding
+func encodeBatch(batch *Batch) []byte {
+	var buf []byte
+	for i, op := range batch.ops {
+		if op.del {
+			buf = append(buf, storeOpDelete)
+		} else {
+			buf = append(buf, storeOpPut)
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(op.key)))
+		buf = append(buf, op.key...)
+		if !op.del {
+			buf = binary.AppendUvarint(buf, uint64(len(op.value)))
+			batch.ops[i].pos = len(buf)
+			buf = append(buf, op.value...)
+		}
+	}
+	return buf
+}
+
+func decodeBatch(payload []byte) (*Batch, error) {
+	batch := &Batch{}
+	size := len(payload)
+	for len(payload) > 0 {
+		op := payload[0]
+		payload = payload[1:]
+
+		key, rest, err := readChunk(payload)
+		if err != nil {
+			return nil, err
+		}
+		payload = rest
+
+		switch op {
+		case storeOpPut:
+			value, rest, err := readChunk(payload)
+			if err != nil {
+				return nil, err
+			}
+			payload = rest
+			batch.ops = append(batch.ops, batchOp{key: string(key), value: value, pos: size - len(rest) - len(value)})
+		case storeOpDelete:
+			batch.Delete(string(key))
+		default:
+			return nil, errors.New("unknown store operation")
+		}
+	}
+	return batch, nil
+}
+
+func readChunk(buf []byte) ([]byte, []byte, error) {
+	length, n := binary.Uvarint(buf)
+	if n <= 0 || uint64(len(buf)-n) < length {
+		return nil, nil, errors.New("corrupt store record")
+	}
+	end := n + int(length)
+	return buf[n:end], buf[end:], nil
+}
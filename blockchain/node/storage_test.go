@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileStoreDropsRecordLongerThanLog(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenFileStore(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put("key", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	// A header claiming a ~4GB record must be treated as a torn write
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, 0xfffffff0)
+	f, err := os.OpenFile(filepath.Join(dir, storeFileName), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(append(header, 1, 2, 3))
+	f.Close()
+
+	store, err = OpenFileStore(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if value, err := store.Get("key"); err != nil || string(value) != "value" {
+		t.Fatalf("Get = %q, %v", value, err)
+	}
+	if err := store.Put("other", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileStoreReadsPastCache(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenFileStore(dir, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{}
+	batch := &Batch{}
+	for i := 0; i < 20; i++ {
+		key, value := fmt.Sprintf("key/%02d", i), strings.Repeat(string(rune('a'+i)), 30)
+		batch.Put(key, []byte(value))
+		want[key] = value
+	}
+	batch.Delete("key/03")
+	delete(want, "key/03")
+	if err := store.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put("key/05", []byte("replaced")); err != nil {
+		t.Fatal(err)
+	}
+	want["key/05"] = "replaced"
+
+	// Only a couple of values fit in the cache; the rest come from the log,
+	// before and after compaction and after reopening
+	check := func(stage string) {
+		t.Helper()
+		if store.cache.size > 64 {
+			t.Fatalf("%s: cache holds %d bytes, limit 64", stage, store.cache.size)
+		}
+		for key, value := range want {
+			if got, err := store.Get(key); err != nil || string(got) != value {
+				t.Fatalf("%s: Get(%s) = %q, %v; want %q", stage, key, got, err, value)
+			}
+		}
+		if _, err := store.Get("key/03"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("%s: deleted key: %v", stage, err)
+		}
+		seen := 0
+		store.Iterate("key/", func(key string, value []byte) error {
+			if string(value) != want[key] {
+				t.Fatalf("%s: Iterate %s = %q, want %q", stage, key, value, want[key])
+			}
+			seen++
+			return nil
+		})
+		if seen != len(want) {
+			t.Fatalf("%s: iterated %d keys, want %d", stage, seen, len(want))
+		}
+	}
+	check("written")
+	if err := store.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	check("compacted")
+	store.Close()
+	if store, err = OpenFileStore(dir, 64); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	check("reopened")
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Subscription types accepted by eth_subscribe
+const (
+	subNewHeads      = "newHeads"               // canonical head blocks
+	subPendingTxs    = "newPendingTransactions" // transactions entering the pool
+	subTransfers     = "transfers"              // mined transactions from or to given addresses
+	wsMaxSubsPerConn = 32
+)
+
+// eventBus publishes chain events to WebSocket subscribers
+var eventBus = NewEventBus()
+
+// wsSubscription is one eth_subscribe registration
+type wsSubscription struct {
+	Type      string
+	FullTx    bool            // newPendingTransactions: send transactions instead of hashes
+	Addresses map[string]bool // transfers: lowercased addresses to match
+}
+
+// wsSession serves JSON-RPC over one WebSocket connection. It answers the
+// regular methods plus eth_subscribe/eth_unsubscribe, and forwards matching
+// event bus events as eth_subscription notifications.
+type wsSession struct {
+	conn    *wsConn
+	events  *EventSubscription
+	methods map[string]rpcMethod
+
+	mu   sync.Mutex
+	subs map[string]*wsSubscription
+
+	// Held while a message is answered, so that notifications for a new
+	// subscription never arrive before the response carrying its id
+	delivery sync.Mutex
+}
+
+// handleWebSocket serves JSON-RPC and subscriptions over WebSocket. Browser
+// clients are only accepted from the configured CORS origins.
+func handleWebSocket(origins []string, maxMessage int64) http.HandlerFunc {
+	allowed := make(map[string]bool)
+	for _, origin := range origins {
+		allowed[origin] = true
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" && !allowed["*"] && !allowed[origin] {
+			http.Error(w, "Origin not allowed", http.StatusForbidden)
+			return
+		}
+		conn, err := upgradeWebSocket(w, r, maxMessage)
+		if err != nil {
+			return
+		}
+		newWSSession(conn).serve()
+	}
+}
+
+func newWSSession(conn *wsConn) *wsSession {
+	s := &wsSession{
+		conn:    conn,
+		events:  eventBus.Subscribe(),
+		methods: make(map[string]rpcMethod, len(rpcMethods)+2),
+		subs:    make(map[string]*wsSubscription),
+	}
+	for name, method := range rpcMethods {
+		s.methods[name] = method
+	}
+	s.methods["eth_subscribe"] = rpcMethod{[]string{"type", "options"}, s.subscribe}
+	s.methods["eth_unsubscribe"] = rpcMethod{[]string{"id"}, s.unsubscribe}
+	return s
+}
+
+// serve answers requests until the connection closes
+func (s *wsSession) serve() {
+	go s.forward()
+	defer s.events.Unsubscribe()
+	defer s.conn.Close(wsCloseNormal, "")
+
+	for {
+		message, err := s.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		s.delivery.Lock()
+		resp, reply := serveRPCPayload(message, s.methods)
+		if reply {
+			err = s.conn.WriteJSON(resp)
+		}
+		s.delivery.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// forward turns event bus events into notifications and keeps the
+// connection alive with pings
+func (s *wsSession) forward() {
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case event, ok := <-s.events.C:
+			if !ok {
+				// Unsubscribed on close, or dropped for falling behind
+				s.conn.Close(wsClosePolicy, "subscriber too slow")
+				return
+			}
+			s.delivery.Lock()
+			err := s.notify(event)
+			s.delivery.Unlock()
+			if err != nil {
+				s.conn.Close(wsCloseNormal, "")
+				return
+			}
+		case <-ping.C:
+			if err := s.conn.Ping(); err != nil {
+				s.conn.Close(wsCloseNormal, "")
+				return
+			}
+		}
+	}
+}
+
+// notify sends event to every subscription it matches
+func (s *wsSession) notify(event ChainEvent) error {
+	s.mu.Lock()
+	subs := make(map[string]wsSubscription, len(s.subs))
+	for id, sub := range s.subs {
+		subs[id] = *sub
+	}
+	s.mu.Unlock()
+
+	for id, sub := range subs {
+		for _, result := range sub.results(event) {
+			err := s.conn.WriteJSON(map[string]interface{}{
+				"jsonrpc": "2.0",
+				"method":  "eth_subscription",
+				"params": map[string]interface{}{
+					"subscription": id,
+					"result":       result,
+				},
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// results returns the notification payloads event produces for sub
+func (sub wsSubscription) results(event ChainEvent) []interface{} {
+	switch {
+	case sub.Type == subNewHeads && event.Kind == EventNewBlock:
+		return []interface{}{rpcBlock(event.Block, false)}
+	case sub.Type == subPendingTxs && event.Kind == EventNewTx:
+		if sub.FullTx {
+			return []interface{}{rpcTransaction(TxLookup{Tx: event.Tx, Pending: true})}
+		}
+		return []interface{}{hexHash(event.Tx.Hash)}
+	case sub.Type == subTransfers && event.Kind == EventNewBlock:
+		var results []interface{}
+		for i, tx := range event.Block.Transactions {
+			if sub.Addresses[strings.ToLower(tx.From)] || sub.Addresses[strings.ToLower(tx.To)] {
+				results = append(results, rpcTransaction(TxLookup{
+					Tx:          tx,
+					BlockHash:   event.Block.Hash,
+					BlockNumber: event.Block.Index,
+					Index:       i,
+				}))
+			}
+		}
+		return results
+	}
+	return nil
+}
+
+// subscribe implements eth_subscribe(type, options)
+func (s *wsSession) subscribe(params rpcParams) (interface{}, error) {
+	subType, err := params.string(0)
+	if err != nil {
+		return nil, err
+	}
+	sub := &wsSubscription{Type: subType}
+	switch subType {
+	case subNewHeads:
+	case subPendingTxs:
+		if sub.FullTx, err = params.bool(1); err != nil {
+			return nil, err
+		}
+	case subTransfers:
+		if sub.Addresses, err = transferFilter(params); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, invalidParams("unsupported subscription type %q", subType)
+	}
+
+	id, err := newSubscriptionID()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.subs) >= wsMaxSubsPerConn {
+		return nil, &rpcError{Code: rpcServerError, Message: "too many subscriptions"}
+	}
+	s.subs[id] = sub
+	return id, nil
+}
+
+// transferFilter parses the {"address": "0x.." | ["0x..", ...]} option of a
+// transfers subscription
+func transferFilter(params rpcParams) (map[string]bool, error) {
+	if !params.has(1) {
+		return nil, invalidParams("transfers subscription requires an address filter")
+	}
+	var filter struct {
+		Address json.RawMessage `json:"address"`
+	}
+	if err := json.Unmarshal(params[1], &filter); err != nil || len(filter.Address) == 0 {
+		return nil, invalidParams("transfers filter must be an object with an address")
+	}
+
+	var addresses []string
+	if err := json.Unmarshal(filter.Address, &addresses); err != nil {
+		var single string
+		if err := json.Unmarshal(filter.Address, &single); err != nil {
+			return nil, invalidParams("address must be a string or an array of strings")
+		}
+		addresses = []string{single}
+	}
+	if len(addresses) == 0 {
+		return nil, invalidParams("address filter is empty")
+	}
+
+	set := make(map[string]bool, len(addresses))
+	for _, address := range addresses {
+		if err := ValidateAddress(address); err != nil {
+			return nil, invalidParams("invalid address %s: %v", address, err)
+		}
+		set[strings.ToLower(address)] = true
+	}
+	return set, nil
+}
+
+// unsubscribe implements eth_unsubscribe(id)
+func (s *wsSession) unsubscribe(params rpcParams) (interface{}, error) {
+	id, err := params.string(0)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.subs[id]
+	delete(s.subs, id)
+	return ok, nil
+}
+
+func newSubscriptionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "0x" + hex.EncodeToString(b), nil
+}
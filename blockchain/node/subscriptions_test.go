@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wsClient is the client side of a WebSocket connection, enough to make
+// JSON-RPC calls and read notifications
+type wsClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// dialWS opens a WebSocket connection to server, sending origin if set. It
+// returns the handshake status when the upgrade is refused.
+func dialWS(t *testing.T, server *httptest.Server, origin string) (*wsClient, int) {
+	t.Helper()
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req, _ := http.NewRequest("GET", server.URL+"/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, resp.StatusCode
+	}
+	return &wsClient{conn: conn, reader: reader}, resp.StatusCode
+}
+
+// send writes v as a masked text frame
+func (c *wsClient) send(t *testing.T, v string) {
+	t.Helper()
+	frame := []byte{0x80 | wsText}
+	if n := len(v); n < 126 {
+		frame = append(frame, 0x80|byte(n))
+	} else {
+		frame = append(frame, 0x80|126, byte(n>>8), byte(n))
+	}
+	// An all-zero mask leaves the payload as it is
+	frame = append(frame, 0, 0, 0, 0)
+	if _, err := c.conn.Write(append(frame, v...)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// receive returns the next text message, decoded
+func (c *wsClient) receive(t *testing.T) map[string]interface{} {
+	t.Helper()
+	for {
+		var header [2]byte
+		if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+			t.Fatal(err)
+		}
+		length := int(header[1] & 0x7F)
+		switch length {
+		case 126:
+			var ext [2]byte
+			io.ReadFull(c.reader, ext[:])
+			length = int(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			io.ReadFull(c.reader, ext[:])
+			length = int(binary.BigEndian.Uint64(ext[:]))
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.reader, payload); err != nil {
+			t.Fatal(err)
+		}
+		if header[0]&0x0F != wsText {
+			continue
+		}
+		var message map[string]interface{}
+		if err := json.Unmarshal(payload, &message); err != nil {
+			t.Fatal(err)
+		}
+		return message
+	}
+}
+
+func TestWebSocketOriginCheck(t *testing.T) {
+	blockchain = testChain(t, testGenesis(t))
+	server := httptest.NewServer(handleWebSocket([]string{"https://wallet.example"}, 1<<20))
+	defer server.Close()
+
+	if _, status := dialWS(t, server, "https://evil.example"); status != http.StatusForbidden {
+		t.Fatalf("foreign origin got status %d, want %d", status, http.StatusForbidden)
+	}
+	for _, origin := range []string{"https://wallet.example", ""} {
+		if _, status := dialWS(t, server, origin); status != http.StatusSwitchingProtocols {
+			t.Fatalf("origin %q got status %d", origin, status)
+		}
+	}
+
+	open := httptest.NewServer(handleWebSocket([]string{"*"}, 1<<20))
+	defer open.Close()
+	if _, status := dialWS(t, open, "https://evil.example"); status != http.StatusSwitchingProtocols {
+		t.Fatalf("wildcard origins refused with status %d", status)
+	}
+}
+
+func TestSubscribeNewHeads(t *testing.T) {
+	bc := testChain(t, testGenesis(t))
+	bc.AddListener(eventBus)
+	blockchain = bc
+	server := httptest.NewServer(handleWebSocket(nil, 1<<20))
+	defer server.Close()
+	ws, _ := dialWS(t, server, "")
+
+	// Regular methods are served over the socket too
+	ws.send(t, `{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"}`)
+	if resp := ws.receive(t); resp["result"] != "0x0" {
+		t.Fatalf("eth_blockNumber = %v", resp)
+	}
+	ws.send(t, `{"jsonrpc":"2.0","id":2,"method":"eth_subscribe","params":["newBlocks"]}`)
+	if code := errorCode(ws.receive(t)); code != rpcInvalidParams {
+		t.Fatalf("unknown subscription type: error code %d", code)
+	}
+
+	ws.send(t, `{"jsonrpc":"2.0","id":3,"method":"eth_subscribe","params":["newHeads"]}`)
+	id, _ := ws.receive(t)["result"].(string)
+	if !strings.HasPrefix(id, "0x") {
+		t.Fatalf("subscription id %q", id)
+	}
+
+	mineBlocks(t, bc, rpcTestMiner, 1)
+	notification := ws.receive(t)
+	params, _ := notification["params"].(map[string]interface{})
+	head, _ := params["result"].(map[string]interface{})
+	if notification["method"] != "eth_subscription" || params["subscription"] != id {
+		t.Fatalf("notification %v", notification)
+	}
+	if head["number"] != "0x1" || head["hash"] != hexHash(bc.Blocks[1].Hash) {
+		t.Fatalf("notified head %v, want block #1", head)
+	}
+
+	ws.send(t, `{"jsonrpc":"2.0","id":4,"method":"eth_unsubscribe","params":["`+id+`"]}`)
+	if resp := ws.receive(t); resp["result"] != true {
+		t.Fatalf("eth_unsubscribe = %v", resp)
+	}
+	ws.send(t, `{"jsonrpc":"2.0","id":5,"method":"eth_unsubscribe","params":["`+id+`"]}`)
+	if resp := ws.receive(t); resp["result"] != false {
+		t.Fatalf("second eth_unsubscribe = %v", resp)
+	}
+}
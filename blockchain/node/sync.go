@@ -0,0 +1,359 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const syncCheckInterval = 15 * time.Second
+
+// SyncConfig controls how a node catches up with its peers
+type SyncConfig struct {
+	MaxBlockBatch    int   // headers and bodies requested per round trip
+	MaxHeaderBatch   int   // headers requested per round trip by a lite node
+	ProgressInterval int64 // batches end on multiples of this height, where progress is logged
+	FastSync         bool  // download the next batch while the current one is imported
+}
+
+// SyncProgress mirrors the eth_syncing result
+type SyncProgress struct {
+	StartingBlock int64 `json:"startingBlock"`
+	CurrentBlock  int64 `json:"currentBlock"`
+	HighestBlock  int64 `json:"highestBlock"`
+}
+
+// SyncManager downloads missing blocks from the best peer, headers first
+type SyncManager struct {
+	chain    *Blockchain
+	server   *P2PServer
+	config   SyncConfig
+	syncing  bool
+	progress SyncProgress
+	trigger  chan struct{}
+	mu       sync.RWMutex
+}
+
+// NewSyncManager creates a sync manager and attaches it to server
+func NewSyncManager(chain *Blockchain, server *P2PServer, config SyncConfig) *SyncManager {
+	if config.MaxBlockBatch <= 0 {
+		config.MaxBlockBatch = 500
+	}
+	if config.MaxBlockBatch > maxBodiesServed {
+		config.MaxBlockBatch = maxBodiesServed
+	}
+	if config.MaxHeaderBatch <= 0 || config.MaxHeaderBatch > maxHeadersServed {
+		config.MaxHeaderBatch = maxHeadersServed
+	}
+	m := &SyncManager{
+		chain:   chain,
+		server:  server,
+		config:  config,
+		trigger: make(chan struct{}, 1),
+	}
+	server.syncer = m
+	return m
+}
+
+// Run checks for a better peer whenever triggered and periodically
+func (m *SyncManager) Run() {
+	ticker := time.NewTicker(syncCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.server.quit:
+			return
+		case <-m.trigger:
+		case <-ticker.C:
+		}
+		m.synchronise()
+	}
+}
+
+// Trigger asks the sync manager to check peers now
+func (m *SyncManager) Trigger() {
+	select {
+	case m.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Syncing reports whether the node is catching up with the network
+func (m *SyncManager) Syncing() bool {
+	if m == nil {
+		return false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.syncing
+}
+
+// Progress returns the current sync progress and whether a sync is running
+func (m *SyncManager) Progress() (SyncProgress, bool) {
+	if m == nil {
+		return SyncProgress{}, false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.progress, m.syncing
+}
+
+func (m *SyncManager) setProgress(current, highest int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.progress.CurrentBlock = current
+	if highest > m.progress.HighestBlock {
+		m.progress.HighestBlock = highest
+	}
+}
+
+func (m *SyncManager) setSyncing(syncing bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.syncing = syncing
+}
+
+func (m *SyncManager) synchronise() {
+	peer := m.server.BestPeer()
+	height := m.chain.Height()
+	if peer == nil || peer.Height() < height {
+		return
+	}
+	// A peer at our height is only interesting if it is on another branch
+	if peer.Height() == height && m.chain.HasBlock(peer.HeadHash()) {
+		return
+	}
+
+	m.mu.Lock()
+	m.syncing = true
+	m.progress = SyncProgress{StartingBlock: height, CurrentBlock: height, HighestBlock: peer.Height()}
+	m.mu.Unlock()
+	defer m.setSyncing(false)
+
+	ancestor, err := m.findCommonAncestor(peer)
+	if err != nil {
+		log.Printf("⚠️  Sync with peer %s failed: %v", shortID(peer.ID()), err)
+		return
+	}
+	if ancestor.Index < height {
+		log.Printf("🔀 Peer %s is on another branch, forked at #%d", shortID(peer.ID()), ancestor.Index)
+	}
+
+	log.Printf("🔄 Syncing from peer %s: #%d → #%d", shortID(peer.ID()), ancestor.Index, peer.Height())
+
+	// In fast sync the next batch downloads while the current one is imported
+	prev := ancestor
+	batch, err := m.fetchBatch(peer, prev)
+	for err == nil {
+		last := batch.headers[len(batch.headers)-1]
+		more := last.Index < peer.Height()
+		var next chan fetchedBatch
+		if more && m.config.FastSync {
+			next = make(chan fetchedBatch, 1)
+			go func() {
+				b, err := m.fetchBatch(peer, last)
+				next <- fetchedBatch{b, err}
+			}()
+		}
+
+		if err = m.importBatch(peer, prev, batch); err != nil || !more {
+			break
+		}
+		prev = last
+		if next != nil {
+			fetched := <-next
+			batch, err = fetched.batch, fetched.err
+		} else {
+			batch, err = m.fetchBatch(peer, last)
+		}
+	}
+	if err != nil {
+		log.Printf("⚠️  Sync with peer %s failed: %v", shortID(peer.ID()), err)
+		return
+	}
+
+	log.Printf("✅ Synced to block #%d", m.chain.Height())
+}
+
+// syncBatch is a run of verified headers with the bodies of their blocks
+type syncBatch struct {
+	headers []BlockHeader
+	bodies  map[string][]Transaction
+}
+
+// fetchedBatch is a batch downloaded ahead in fast sync
+type fetchedBatch struct {
+	batch syncBatch
+	err   error
+}
+
+// findCommonAncestor returns the newest block of our canonical chain that the
+// peer also has, searching no deeper than the reorg limit
+func (m *SyncManager) findCommonAncestor(peer *Peer) (BlockHeader, error) {
+	height := m.chain.Height()
+	from := height - m.chain.MaxReorgDepth
+	if from < 0 {
+		from = 0
+	}
+
+	headers, err := m.fetchHeaders(peer, from, int(height-from+1))
+	if err != nil {
+		return BlockHeader{}, err
+	}
+	for i := len(headers) - 1; i >= 0; i-- {
+		if hash, ok := m.chain.CanonicalHash(headers[i].Index); ok && hash == headers[i].Hash {
+			return headers[i], nil
+		}
+	}
+	return BlockHeader{}, ErrReorgTooDeep
+}
+
+// fetchBatch downloads the batch of blocks following prev from peer and
+// checks their headers. It does not depend on the chain having imported prev.
+func (m *SyncManager) fetchBatch(peer *Peer, prev BlockHeader) (syncBatch, error) {
+	from := prev.Index + 1
+	count := m.config.MaxBlockBatch
+	if m.chain.Lite {
+		count = m.config.MaxHeaderBatch
+	}
+	if interval := m.config.ProgressInterval; interval > 0 {
+		boundary := ((from-1)/interval + 1) * interval
+		if remaining := boundary - from + 1; remaining < int64(count) {
+			count = int(remaining)
+		}
+	}
+
+	headers, err := m.fetchHeaders(peer, from, count)
+	if err != nil {
+		return syncBatch{}, err
+	}
+	// A peer that cannot back up the head it announced, or serves invalid
+	// blocks, is disconnected
+	if len(headers) == 0 {
+		m.server.removePeer(peer)
+		return syncBatch{}, errors.New("peer returned no headers")
+	}
+	if err := m.verifyHeaders(prev, headers); err != nil {
+		m.server.removePeer(peer)
+		return syncBatch{}, err
+	}
+
+	// Lite nodes keep headers only
+	bodies := map[string][]Transaction{}
+	if !m.chain.Lite {
+		if bodies, err = m.fetchBodies(peer, headers); err != nil {
+			return syncBatch{}, err
+		}
+	}
+	return syncBatch{headers: headers, bodies: bodies}, nil
+}
+
+// importBatch adds the blocks of a batch fetched after prev to the chain
+func (m *SyncManager) importBatch(peer *Peer, prev BlockHeader, batch syncBatch) error {
+	// Lite nodes, and full nodes taking in a branch that is not yet canonical,
+	// need the validator sets to check POS headers against
+	if head, _ := m.chain.CanonicalHash(m.chain.Height()); m.chain.Lite || prev.Hash != head {
+		if err := m.fetchValidatorSets(prev, batch.headers); err != nil {
+			return err
+		}
+	}
+
+	for _, header := range batch.headers {
+		block := header.ToBlock(batch.bodies[header.Hash])
+		if err := m.chain.AddBlock(block); err != nil && !errors.Is(err, ErrKnownBlock) {
+			m.server.removePeer(peer)
+			return fmt.Errorf("block #%d rejected: %w", header.Index, err)
+		}
+	}
+
+	last := batch.headers[len(batch.headers)-1]
+	m.setProgress(last.Index, peer.Height())
+	if interval := m.config.ProgressInterval; interval > 0 && last.Index%interval == 0 {
+		log.Printf("📍 Synced to block #%d of #%d", last.Index, peer.Height())
+	}
+	return nil
+}
+
+// fetchValidatorSets fetches the validator sets that the POS headers of a
+// batch are checked against, the ones their parents commit to, unless they
+// are already known
+func (m *SyncManager) fetchValidatorSets(prev BlockHeader, headers []BlockHeader) error {
+	parentRoot := prev.ValidatorsRoot
+	for _, header := range headers {
+		if header.Type == "POS" && !m.chain.HasValidatorSet(parentRoot) {
+			if err := m.server.FetchValidatorSet(parentRoot); err != nil {
+				return fmt.Errorf("validator set of block #%d: %w", header.Index-1, err)
+			}
+		}
+		parentRoot = header.ValidatorsRoot
+	}
+	return nil
+}
+
+func (m *SyncManager) fetchHeaders(peer *Peer, from int64, count int) ([]BlockHeader, error) {
+	resp, err := peer.Request(MsgGetHeaders, GetHeadersRequest{From: from, Count: count})
+	if err != nil {
+		return nil, err
+	}
+	var headers []BlockHeader
+	if err := json.Unmarshal(resp.Payload, &headers); err != nil {
+		return nil, err
+	}
+	if len(headers) > count {
+		return nil, errors.New("peer returned too many headers")
+	}
+	return headers, nil
+}
+
+// verifyHeaders checks that headers link onto prev and carry valid hashes
+// and proof of work before any bodies are downloaded
+func (m *SyncManager) verifyHeaders(prev BlockHeader, headers []BlockHeader) error {
+	prevHash := prev.Hash
+	prevIndex := prev.Index
+
+	for _, header := range headers {
+		if header.Index != prevIndex+1 {
+			return fmt.Errorf("header #%d out of sequence", header.Index)
+		}
+		if header.PreviousHash != prevHash {
+			return fmt.Errorf("header #%d does not extend our chain", header.Index)
+		}
+		block := header.ToBlock(nil)
+		if err := checkSeal(&block); err != nil {
+			return fmt.Errorf("header #%d: %w", header.Index, err)
+		}
+		prevHash = header.Hash
+		prevIndex = header.Index
+	}
+	return nil
+}
+
+func (m *SyncManager) fetchBodies(peer *Peer, headers []BlockHeader) (map[string][]Transaction, error) {
+	hashes := make([]string, len(headers))
+	for i, header := range headers {
+		hashes[i] = header.Hash
+	}
+
+	resp, err := peer.Request(MsgGetBodies, hashes)
+	if err != nil {
+		return nil, err
+	}
+	var bodies []BlockBody
+	if err := json.Unmarshal(resp.Payload, &bodies); err != nil {
+		return nil, err
+	}
+
+	result := make(map[string][]Transaction, len(bodies))
+	for _, body := range bodies {
+		result[body.Hash] = body.Transactions
+	}
+	for _, hash := range hashes {
+		if _, ok := result[hash]; !ok {
+			return nil, fmt.Errorf("peer did not return body for %s", hash)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+
+	"gydschain/client"
+)
+
+func TestNodeSyncsFromPeer(t *testing.T) {
+	genesis := testGenesis(t)
+	ahead := testChain(t, genesis)
+	mineBlocks(t, ahead, "0x1111111111111111111111111111111111111111", 8)
+	server := testServer(t, ahead)
+
+	for _, fast := range []bool{false, true} {
+		behind := testChain(t, genesis)
+		_, syncer := testSyncedServer(t, behind, SyncConfig{MaxBlockBatch: 3, ProgressInterval: 4, FastSync: fast}, server.ListenAddr())
+
+		waitFor(t, "sync", func() bool { return behind.Height() == ahead.Height() && !syncer.Syncing() })
+		for i, block := range ahead.Blocks {
+			if behind.Blocks[i].Hash != block.Hash {
+				t.Fatalf("fast sync %v: block #%d = %s, want %s", fast, i, shortID(behind.Blocks[i].Hash), shortID(block.Hash))
+			}
+		}
+		miner := ahead.Blocks[1].Miner
+		if got, want := behind.State.GetBalance(miner), ahead.State.GetBalance(miner); got.Cmp(want) != 0 {
+			t.Fatalf("fast sync %v: miner balance = %s, want %s", fast, got, want)
+		}
+	}
+}
+
+func TestNodeSyncsPOSBranch(t *testing.T) {
+	validatorKey, validator := testKey(t)
+	delegatorKey, delegator := testKey(t)
+	genesis := validatorGenesis(t, validatorKey, delegator)
+	ahead, behind := testChain(t, genesis), testChain(t, genesis)
+
+	// The peer's branch changes the validator set before its POS blocks,
+	// so their proposers are checked against a set fetched from the peer
+	tx := signedTx(t, client.NewDelegate(delegator, validator, big.NewInt(1e18), 0), delegatorKey)
+	if err := ahead.AddTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, ahead, "0x2222222222222222222222222222222222222222", 1)
+	mintBlock(t, ahead, validatorKey)
+	mintBlock(t, ahead, validatorKey)
+	mineBlocks(t, behind, "0x1111111111111111111111111111111111111111", 1)
+
+	// In fast sync the last POS block is downloaded before the branch below
+	// it is imported
+	server := testServer(t, ahead)
+	_, syncer := testSyncedServer(t, behind, SyncConfig{MaxBlockBatch: 2, FastSync: true}, server.ListenAddr())
+
+	head := ahead.Blocks[len(ahead.Blocks)-1].Hash
+	waitFor(t, "sync", func() bool {
+		hash, _ := behind.CanonicalHash(behind.Height())
+		return hash == head && !syncer.Syncing()
+	})
+}
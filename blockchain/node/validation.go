@@ -1,7 +1,9 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"math/big"
 	"regexp"
 	"strings"
@@ -108,6 +110,18 @@ func CalculateTransactionFee(gas int64, gasPriceStr string) (*big.Int, error) {
 	return fee, nil
 }
 
+// ErrWrongChain is returned for a transaction signed for another chain
+var ErrWrongChain = errors.New("transaction is for another chain")
+
+// checkChainID rejects a transaction whose signature is not bound to the
+// chain with ID chainID
+func checkChainID(tx *Transaction, chainID int64) error {
+	if tx.ChainID != chainID {
+		return fmt.Errorf("%w: chain ID %d, want %d", ErrWrongChain, tx.ChainID, chainID)
+	}
+	return nil
+}
+
 // ValidateTransaction performs complete transaction validation
 func ValidateTransaction(tx *Transaction) error {
 	// Validate addresses
@@ -119,14 +133,54 @@ func ValidateTransaction(tx *Transaction) error {
 		return errors.New("invalid to address: " + err.Error())
 	}
 
-	// Cannot send to self
-	if tx.From == tx.To {
-		return errors.New("cannot send to same address")
+	switch tx.Type {
+	case TxTransfer:
+		// Cannot send to self
+		if tx.From == tx.To {
+			return errors.New("cannot send to same address")
+		}
+	case TxStake, TxUnstake, TxWithdraw, TxUnjail, TxExit:
+		// Staking operations name the validator they apply to: the sender
+		if !addressesEqual(tx.From, tx.To) {
+			return errors.New(tx.Type + " transaction must be sent to the sender's own address")
+		}
+	case TxDelegate, TxUndelegate, TxClaim:
+		// Delegations name the validator as recipient; validators stake instead
+		if addressesEqual(tx.From, tx.To) {
+			return errors.New("cannot delegate to own address")
+		}
+	case TxRotateKey:
+		if !addressesEqual(tx.From, tx.To) {
+			return errors.New(tx.Type + " transaction must be sent to the sender's own address")
+		}
+		// The new signing key travels as data
+		if _, err := DecodePublicKey(tx.Data); err != nil {
+			return errors.New("invalid signing key: " + err.Error())
+		}
+	case TxEvidence:
+		// Evidence names the offending validator as recipient
+		if tx.Data == "" || len(tx.Data) > maxEvidenceSize {
+			return fmt.Errorf("evidence must be 1 to %d bytes", maxEvidenceSize)
+		}
+	default:
+		return fmt.Errorf("unknown transaction type %q", tx.Type)
+	}
+
+	if tx.Data != "" && tx.Type != TxEvidence && tx.Type != TxRotateKey {
+		return errors.New("only evidence and key rotation transactions carry data")
 	}
 
-	// Validate amount
-	if err := ValidateAmount(tx.Value); err != nil {
-		return errors.New("invalid amount: " + err.Error())
+	// Validate amount; withdrawals, claims, evidence and the validator
+	// lifecycle operations carry none
+	switch tx.Type {
+	case TxWithdraw, TxClaim, TxEvidence, TxUnjail, TxExit, TxRotateKey:
+		if tx.Value != "0" {
+			return errors.New(tx.Type + " transaction must have value 0")
+		}
+	default:
+		if err := ValidateAmount(tx.Value); err != nil {
+			return errors.New("invalid amount: " + err.Error())
+		}
 	}
 
 	// Validate gas
@@ -156,6 +210,11 @@ func ValidateTransaction(tx *Transaction) error {
 		return errors.New("total transaction cost exceeds maximum supply")
 	}
 
+	// Validate signature
+	if err := VerifyTransaction(tx); err != nil {
+		return errors.New("invalid signature: " + err.Error())
+	}
+
 	return nil
 }
 
@@ -176,8 +235,94 @@ func ValidateMnemonic(mnemonic string) error {
 	return nil
 }
 
-// ValidateBlock performs block validation
-func ValidateBlock(block *Block, previousBlock *Block) error {
+// blockSize is the length of a block's JSON encoding, the form it is stored
+// and sent to peers in
+func blockSize(block *Block) int64 {
+	raw, _ := json.Marshal(block)
+	return int64(len(raw))
+}
+
+// transactionSize is the space a transaction takes in a block's JSON
+// encoding, including the separating comma
+func transactionSize(tx *Transaction) int64 {
+	raw, _ := json.Marshal(tx)
+	return int64(len(raw)) + 1
+}
+
+// ValidateBlock performs block validation against the previous block and the
+// chain's block limits
+func ValidateBlock(block *Block, previousBlock *Block, config *ChainConfig) error {
+	if err := ValidateHeader(block, previousBlock); err != nil {
+		return err
+	}
+
+	// Validate transactions
+	var gasUsed int64
+	for i := range block.Transactions {
+		if err := ValidateTransaction(&block.Transactions[i]); err != nil {
+			return fmt.Errorf("invalid transaction %d: %w", i, err)
+		}
+		if err := checkChainID(&block.Transactions[i], config.ChainID); err != nil {
+			return fmt.Errorf("invalid transaction %d: %w", i, err)
+		}
+		gasUsed += block.Transactions[i].Gas
+	}
+
+	// Validate the transactions root; the receipts and state roots are
+	// checked when the block is applied
+	if block.TxRoot != transactionsRoot(block.Transactions) {
+		return errors.New("transactions root mismatch")
+	}
+
+	// Validate block limits
+	if gasUsed > config.BlockGasLimit {
+		return fmt.Errorf("block gas %d exceeds limit %d", gasUsed, config.BlockGasLimit)
+	}
+	if size := blockSize(block); size > config.MaxBlockSize {
+		return fmt.Errorf("block size %d exceeds limit %d", size, config.MaxBlockSize)
+	}
+
+	return nil
+}
+
+// maxDifficulty is the highest PoW difficulty: every 0x1000 halves the
+// target, which is down to 1 after 256 halvings
+const maxDifficulty = 256 * 0x1000
+
+// ErrInvalidDifficulty is returned for a POW difficulty isValidPOW cannot
+// check
+var ErrInvalidDifficulty = errors.New("difficulty out of range")
+
+// checkDifficulty rejects difficulties outside (0, maxDifficulty]
+func checkDifficulty(difficulty int64) error {
+	if difficulty <= 0 || difficulty > maxDifficulty {
+		return fmt.Errorf("%w: %d", ErrInvalidDifficulty, difficulty)
+	}
+	return nil
+}
+
+// checkSeal checks what a block proves without its parent: its hash and, for
+// POW blocks, the proof of work
+func checkSeal(block *Block) error {
+	if block.Hash != calculateHash(*block) {
+		return errors.New("invalid block hash")
+	}
+	if block.Type == "POW" {
+		if err := checkDifficulty(block.Difficulty); err != nil {
+			return err
+		}
+		if !isValidPOW(block.Hash, block.Difficulty) {
+			return errors.New("insufficient proof of work")
+		}
+	}
+	return nil
+}
+
+// ValidateHeader checks the parts of a block that do not need its
+// transactions: linkage to the previous block, the hash and proof of work.
+// A POS header's signature is verified by checkProposer against the key of
+// the validator drawn for its slot, on lite nodes too.
+func ValidateHeader(block *Block, previousBlock *Block) error {
 	// Validate index
 	if block.Index != previousBlock.Index+1 {
 		return errors.New("invalid block index")
@@ -188,13 +333,19 @@ func ValidateBlock(block *Block, previousBlock *Block) error {
 		return errors.New("invalid previous hash")
 	}
 
+	// Validate block hash
+	if block.Hash != calculateHash(*block) {
+		return errors.New("invalid block hash")
+	}
+
 	// Validate timestamp
 	if block.Timestamp <= previousBlock.Timestamp {
 		return errors.New("block timestamp must be after previous block")
 	}
 
-	// Validate block type
-	if block.Type != "POW" && block.Type != "POS" && block.Type != "GENESIS" {
+	// Validate block type; only the genesis block, built locally from
+	// genesis.json, is of type GENESIS
+	if block.Type != "POW" && block.Type != "POS" {
 		return errors.New("invalid block type")
 	}
 
@@ -206,6 +357,15 @@ func ValidateBlock(block *Block, previousBlock *Block) error {
 		if err := ValidateAddress(block.Miner); err != nil {
 			return errors.New("invalid miner address")
 		}
+		if err := checkDifficulty(block.Difficulty); err != nil {
+			return err
+		}
+		if !isValidPOW(block.Hash, block.Difficulty) {
+			return errors.New("insufficient proof of work")
+		}
+		if block.Signature != "" {
+			return errors.New("POW block must not carry a validator signature")
+		}
 	}
 
 	// Validate POS blocks
@@ -216,6 +376,9 @@ func ValidateBlock(block *Block, previousBlock *Block) error {
 		if err := ValidateAddress(block.Validator); err != nil {
 			return errors.New("invalid validator address")
 		}
+		if block.Signature == "" {
+			return errors.New("POS block must be signed by its validator")
+		}
 	}
 
 	return nil
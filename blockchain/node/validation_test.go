@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	"gydschain/client"
+)
+
+func TestAddBlockRejectsGenesisTypeAboveGenesis(t *testing.T) {
+	bc := testChain(t, testGenesis(t))
+	parent := bc.Blocks[0]
+
+	block := Block{
+		Index:        1,
+		Timestamp:    parent.Timestamp + 1,
+		Transactions: []Transaction{},
+		PreviousHash: parent.Hash,
+		Miner:        "genesis",
+		Type:         "GENESIS",
+		Reward:       "0",
+		StateRoot:    parent.StateRoot,
+	}
+	block.TxRoot = transactionsRoot(block.Transactions)
+	block.ReceiptsRoot = receiptsRoot(nil)
+	block.Hash = calculateHash(block)
+
+	if err := bc.AddBlock(block); err == nil {
+		t.Fatal("GENESIS block at height 1 was accepted")
+	}
+	if bc.Height() != 0 {
+		t.Fatalf("height = %d, want 0", bc.Height())
+	}
+}
+
+func TestDifficultyOutOfRangeRejected(t *testing.T) {
+	bc := testChain(t, testGenesis(t))
+	parent := bc.Blocks[0]
+
+	for _, difficulty := range []int64{0, -1, maxDifficulty + 1, math.MaxInt64, math.MinInt64} {
+		block := Block{
+			Index:        1,
+			Timestamp:    parent.Timestamp + 1,
+			Transactions: []Transaction{},
+			PreviousHash: parent.Hash,
+			Difficulty:   difficulty,
+			Miner:        "0x1111111111111111111111111111111111111111",
+			Type:         "POW",
+			Reward:       "0",
+		}
+		block.Hash = calculateHash(block)
+
+		// Each path a peer's block takes fails before the target is computed
+		if err := checkSeal(&block); !errors.Is(err, ErrInvalidDifficulty) {
+			t.Errorf("difficulty %d: checkSeal = %v, want %v", difficulty, err, ErrInvalidDifficulty)
+		}
+		if err := ValidateHeader(&block, &parent); !errors.Is(err, ErrInvalidDifficulty) {
+			t.Errorf("difficulty %d: ValidateHeader = %v, want %v", difficulty, err, ErrInvalidDifficulty)
+		}
+		var syncer SyncManager
+		if err := syncer.verifyHeaders(parent.Header(), []BlockHeader{block.Header()}); !errors.Is(err, ErrInvalidDifficulty) {
+			t.Errorf("difficulty %d: verifyHeaders = %v, want %v", difficulty, err, ErrInvalidDifficulty)
+		}
+		if err := bc.AddBlock(block); err == nil {
+			t.Errorf("difficulty %d: block accepted", difficulty)
+		}
+	}
+	if isValidPOW(strings.Repeat("0", 64), maxDifficulty+1) {
+		t.Fatal("proof of work valid above the maximum difficulty")
+	}
+}
+
+func TestValidateBlockEnforcesLimits(t *testing.T) {
+	bc, _ := minedTransfers(t, 3)
+	block, parent := bc.Blocks[1], bc.Blocks[0]
+
+	config := bc.Config
+	if err := ValidateBlock(&block, &parent, &config); err != nil {
+		t.Fatal(err)
+	}
+	config.BlockGasLimit = 3*client.TransferGas - 1
+	if err := ValidateBlock(&block, &parent, &config); err == nil || !strings.Contains(err.Error(), "block gas") {
+		t.Fatalf("block over the gas limit: %v", err)
+	}
+	config = bc.Config
+	config.MaxBlockSize = blockSize(&block) - 1
+	if err := ValidateBlock(&block, &parent, &config); err == nil || !strings.Contains(err.Error(), "block size") {
+		t.Fatalf("block over the size limit: %v", err)
+	}
+}
@@ -5,9 +5,16 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gydschain/client"
 
 	"golang.org/x/crypto/pbkdf2"
 )
@@ -84,7 +91,12 @@ func GenerateKeyPairFromSeed(seed []byte) (*ecdsa.PrivateKey, error) {
 
 // PrivateKeyToAddress converts private key to blockchain address
 func PrivateKeyToAddress(privateKey *ecdsa.PrivateKey) string {
-	pubKey := append(privateKey.PublicKey.X.Bytes(), privateKey.PublicKey.Y.Bytes()...)
+	return PublicKeyToAddress(&privateKey.PublicKey)
+}
+
+// PublicKeyToAddress converts public key to blockchain address
+func PublicKeyToAddress(publicKey *ecdsa.PublicKey) string {
+	pubKey := append(publicKey.X.Bytes(), publicKey.Y.Bytes()...)
 	hash := sha256.Sum256(pubKey)
 	return "0x" + hex.EncodeToString(hash[:])[:40]
 }
@@ -104,7 +116,7 @@ func CreateAccount() (*Account, error) {
 
 	address := PrivateKeyToAddress(privateKey)
 	privateKeyHex := hex.EncodeToString(privateKey.D.Bytes())
-	publicKeyHex := hex.EncodeToString(append(privateKey.PublicKey.X.Bytes(), privateKey.PublicKey.Y.Bytes()...))
+	publicKeyHex := EncodePublicKey(&privateKey.PublicKey)
 
 	return &Account{
 		Address:    address,
@@ -124,7 +136,7 @@ func RecoverAccountFromMnemonic(mnemonic string) (*Account, error) {
 
 	address := PrivateKeyToAddress(privateKey)
 	privateKeyHex := hex.EncodeToString(privateKey.D.Bytes())
-	publicKeyHex := hex.EncodeToString(append(privateKey.PublicKey.X.Bytes(), privateKey.PublicKey.Y.Bytes()...))
+	publicKeyHex := EncodePublicKey(&privateKey.PublicKey)
 
 	return &Account{
 		Address:    address,
@@ -134,24 +146,195 @@ func RecoverAccountFromMnemonic(mnemonic string) (*Account, error) {
 	}, nil
 }
 
-// SignTransaction signs a transaction with private key
-func SignTransaction(tx *Transaction, privateKeyHex string) error {
-	privateKeyBytes, err := hex.DecodeString(privateKeyHex)
+// ParsePrivateKey decodes a hex private key
+func ParsePrivateKey(privateKeyHex string) (*ecdsa.PrivateKey, error) {
+	privateKeyBytes, err := hex.DecodeString(strings.TrimPrefix(privateKeyHex, "0x"))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	curve := elliptic.P256()
 	privateKey := new(ecdsa.PrivateKey)
 	privateKey.PublicKey.Curve = curve
 	privateKey.D = new(big.Int).SetBytes(privateKeyBytes)
+	if privateKey.D.Sign() == 0 || privateKey.D.Cmp(curve.Params().N) >= 0 {
+		return nil, errors.New("private key out of range")
+	}
 	privateKey.PublicKey.X, privateKey.PublicKey.Y = curve.ScalarBaseMult(privateKey.D.Bytes())
+	return privateKey, nil
+}
+
+// LoadKeyFile reads a hex private key from path. If the file does not exist a
+// new key is generated and saved there, readable by the owner only.
+func LoadKeyFile(path string) (key *ecdsa.PrivateKey, created bool, err error) {
+	raw, err := os.ReadFile(path)
+	if err == nil {
+		key, err = ParsePrivateKey(strings.TrimSpace(string(raw)))
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: %w", path, err)
+		}
+		return key, false, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, false, err
+	}
+
+	key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, false, err
+	}
+	buf := make([]byte, 32)
+	key.D.FillBytes(buf)
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(buf)+"\n"), 0600); err != nil {
+		return nil, false, err
+	}
+	return key, true, nil
+}
+
+// EncodePublicKey encodes a public key as 64 bytes of hex (X || Y, zero padded)
+func EncodePublicKey(publicKey *ecdsa.PublicKey) string {
+	buf := make([]byte, 64)
+	publicKey.X.FillBytes(buf[:32])
+	publicKey.Y.FillBytes(buf[32:])
+	return hex.EncodeToString(buf)
+}
+
+// DecodePublicKey parses a public key produced by EncodePublicKey
+func DecodePublicKey(publicKeyHex string) (*ecdsa.PublicKey, error) {
+	buf, err := hex.DecodeString(strings.TrimPrefix(publicKeyHex, "0x"))
+	if err != nil {
+		return nil, errors.New("invalid public key encoding")
+	}
+	if len(buf) != 64 {
+		return nil, errors.New("public key must be 64 bytes")
+	}
+
+	curve := elliptic.P256()
+	x := new(big.Int).SetBytes(buf[:32])
+	y := new(big.Int).SetBytes(buf[32:])
+	if !curve.IsOnCurve(x, y) {
+		return nil, errors.New("public key is not on curve")
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+// TransactionHash computes the hash a transaction is identified and signed
+// by: the SHA-256 of its chain ID, type, addresses, amounts, gas, nonce and
+// data in a fixed binary encoding. Integers are 8 bytes big-endian and strings
+// are length-prefixed, so no two transactions share an encoding.
+func TransactionHash(tx *Transaction) string {
+	buf := make([]byte, 0, 256)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(tx.ChainID))
+	buf = appendString(buf, tx.Type)
+	buf = appendString(buf, tx.From)
+	buf = appendString(buf, tx.To)
+	buf = appendString(buf, tx.Value)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(tx.Gas))
+	buf = appendString(buf, tx.GasPrice)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(tx.Nonce))
+	buf = appendString(buf, tx.Data)
+	hash := sha256.Sum256(buf)
+	return hex.EncodeToString(hash[:])
+}
+
+// SignTransaction signs a transaction with private key
+func SignTransaction(tx *Transaction, privateKeyHex string) error {
+	privateKey, err := ParsePrivateKey(privateKeyHex)
+	if err != nil {
+		return err
+	}
+
+	if !strings.EqualFold(PrivateKeyToAddress(privateKey), tx.From) {
+		return errors.New("private key does not match from address")
+	}
 
-	// Create transaction hash
-	txData := fmt.Sprintf("%s%s%s%d%s%d",
-		tx.From, tx.To, tx.Value, tx.Gas, tx.GasPrice, tx.Nonce)
-	hash := sha256.Sum256([]byte(txData))
-	tx.Hash = hex.EncodeToString(hash[:])
+	tx.Hash = TransactionHash(tx)
+	signature, err := SignHash(privateKey, tx.Hash)
+	if err != nil {
+		return err
+	}
+	tx.Signature = signature
+	tx.PublicKey = EncodePublicKey(&privateKey.PublicKey)
 
 	return nil
 }
+
+// SignHash signs a hex SHA-256 hash and returns the 64-byte R || S signature
+// as hex
+func SignHash(privateKey *ecdsa.PrivateKey, hash string) (string, error) {
+	digest, err := hex.DecodeString(hash)
+	if err != nil {
+		return "", errors.New("invalid hash encoding")
+	}
+
+	r, sig, err := ecdsa.Sign(rand.Reader, privateKey, digest)
+	if err != nil {
+		return "", err
+	}
+
+	// Use the low-S form so signatures are not malleable
+	n := privateKey.Curve.Params().N
+	if sig.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+		sig.Sub(n, sig)
+	}
+
+	buf := make([]byte, 64)
+	r.FillBytes(buf[:32])
+	sig.FillBytes(buf[32:])
+	return hex.EncodeToString(buf), nil
+}
+
+// VerifyHashSignature checks a signature produced by SignHash
+func VerifyHashSignature(publicKey *ecdsa.PublicKey, hash, signature string) error {
+	sigBytes, err := hex.DecodeString(signature)
+	if err != nil || len(sigBytes) != 64 {
+		return errors.New("invalid signature encoding")
+	}
+	r := new(big.Int).SetBytes(sigBytes[:32])
+	sig := new(big.Int).SetBytes(sigBytes[32:])
+	if sig.Cmp(new(big.Int).Rsh(publicKey.Curve.Params().N, 1)) > 0 {
+		return errors.New("signature is not in low-S form")
+	}
+
+	digest, _ := hex.DecodeString(hash)
+	if !ecdsa.Verify(publicKey, digest, r, sig) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// VerifyTransaction checks that a transaction is signed by the owner of From
+func VerifyTransaction(tx *Transaction) error {
+	if tx.Signature == "" || tx.PublicKey == "" {
+		return errors.New("transaction is not signed")
+	}
+
+	if tx.Hash != TransactionHash(tx) {
+		return errors.New("transaction hash mismatch")
+	}
+
+	publicKey, err := DecodePublicKey(tx.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	if !strings.EqualFold(PublicKeyToAddress(publicKey), tx.From) {
+		return errors.New("signer does not match from address")
+	}
+
+	return VerifyHashSignature(publicKey, tx.Hash, tx.Signature)
+}
+
+// DecodeRawTransaction parses a transaction serialized with the client package
+func DecodeRawTransaction(raw string) (*Transaction, error) {
+	decoded, err := client.DecodeRaw(raw)
+	if err != nil {
+		return nil, err
+	}
+	// The conversion only compiles while both types share the same wire format
+	tx := Transaction(*decoded)
+	return &tx, nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gydschain/client"
+)
+
+func TestSignedTransactionVerifies(t *testing.T) {
+	key, sender := testKey(t)
+	tx := signedTx(t, client.NewTransfer(sender, "0x2222222222222222222222222222222222222222", big.NewInt(1000), 0), key)
+	if err := ValidateTransaction(&tx); err != nil {
+		t.Fatal(err)
+	}
+
+	other, _ := testKey(t)
+	unsigned := tx
+	unsigned.From = PrivateKeyToAddress(other)
+	if err := SignTransaction(&unsigned, hex.EncodeToString(key.D.Bytes())); err == nil {
+		t.Fatal("signed for an address the key does not own")
+	}
+	unsigned.Signature, unsigned.PublicKey = "", ""
+	if err := VerifyTransaction(&unsigned); err == nil {
+		t.Fatal("unsigned transaction verified")
+	}
+}
+
+func TestTamperedTransactionRejected(t *testing.T) {
+	key, sender := testKey(t)
+	tx := signedTx(t, client.NewTransfer(sender, "0x2222222222222222222222222222222222222222", big.NewInt(1000), 0), key)
+
+	for name, tamper := range map[string]func(*Transaction){
+		"to":       func(tx *Transaction) { tx.To = "0x3333333333333333333333333333333333333333" },
+		"value":    func(tx *Transaction) { tx.Value = "1000000" },
+		"gas":      func(tx *Transaction) { tx.Gas++ },
+		"gasPrice": func(tx *Transaction) { tx.GasPrice = "1" },
+		"nonce":    func(tx *Transaction) { tx.Nonce++ },
+		"chainId":  func(tx *Transaction) { tx.ChainID++ },
+		"type":     func(tx *Transaction) { tx.Type = TxStake },
+		"data":     func(tx *Transaction) { tx.Data = "{}" },
+	} {
+		// With the old hash, and with the hash recomputed over the change
+		changed := tx
+		tamper(&changed)
+		if err := VerifyTransaction(&changed); err == nil {
+			t.Errorf("%s changed under the old hash: verified", name)
+		}
+		changed.Hash = TransactionHash(&changed)
+		if err := VerifyTransaction(&changed); err == nil {
+			t.Errorf("%s changed under a new hash: verified", name)
+		}
+	}
+
+	// Someone else's key cannot sign for the sender
+	other, _ := testKey(t)
+	forged := tx
+	forged.PublicKey = EncodePublicKey(&other.PublicKey)
+	if err := VerifyTransaction(&forged); err == nil {
+		t.Fatal("signature by another key verified")
+	}
+}
+
+func TestTransactionForAnotherChainRejected(t *testing.T) {
+	bc, _ := minedTransfers(t, 1)
+	key, sender := testKey(t)
+	tx := client.NewTransfer(sender, "0x2222222222222222222222222222222222222222", big.NewInt(1000), 0)
+	tx.ChainID = bc.Config.ChainID + 1
+	replayed := signedTx(t, tx, key)
+
+	if err := bc.AddTransaction(replayed); !errors.Is(err, ErrWrongChain) {
+		t.Fatalf("AddTransaction = %v, want %v", err, ErrWrongChain)
+	}
+	block := reseal(bc.Blocks[1], func(b *Block) {
+		b.Transactions = []Transaction{replayed}
+		b.TxRoot = transactionsRoot(b.Transactions)
+	})
+	if err := ValidateBlock(&block, &bc.Blocks[0], &bc.Config); !errors.Is(err, ErrWrongChain) {
+		t.Fatalf("ValidateBlock = %v, want %v", err, ErrWrongChain)
+	}
+}
+
+func TestHighSSignatureRejected(t *testing.T) {
+	key, sender := testKey(t)
+	tx := signedTx(t, client.NewTransfer(sender, "0x2222222222222222222222222222222222222222", big.NewInt(1000), 0), key)
+
+	// (r, n-s) is just as valid a signature, so only the low-S form is accepted
+	digest, _ := hex.DecodeString(tx.Hash)
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := key.Curve.Params().N
+	if s.Cmp(new(big.Int).Rsh(n, 1)) <= 0 {
+		s.Sub(n, s)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	tx.Signature = hex.EncodeToString(sig)
+	if err := VerifyTransaction(&tx); err == nil {
+		t.Fatal("high-S signature verified")
+	}
+}
+
+func TestClientSignedTransactionAccepted(t *testing.T) {
+	key, sender := testKey(t)
+	genesis := testGenesis(t)
+	genesis.Alloc = map[string]GenesisAccount{sender: {Balance: "10000000000000000000"}}
+	blockchain = testChain(t, genesis)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", handleHome)
+	mux.HandleFunc("/transaction/raw", handleSendRawTransaction)
+	node := httptest.NewServer(mux)
+	defer node.Close()
+
+	tx := client.NewTransfer(sender, "0x2222222222222222222222222222222222222222", big.NewInt(1000), 0)
+	hash, err := client.New(node.URL).SendTransaction(tx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending, ok := blockchain.pool.Get(hash); !ok || pending.Value != "1000" {
+		t.Fatalf("transaction %s not pending", hash)
+	}
+
+	// The same signature over a different value is refused
+	tx.Value = "1000000"
+	payload, _ := json.Marshal(tx)
+	_, err = client.New(node.URL).SendRawTransaction("0x" + hex.EncodeToString(payload))
+	if err == nil || !strings.Contains(err.Error(), "rejected") {
+		t.Fatalf("tampered transaction: %v", err)
+	}
+	if blockchain.pool.Len() != 1 {
+		t.Fatalf("pool holds %d transactions, want 1", blockchain.pool.Len())
+	}
+}
+
+func TestLoadKeyFileCreatesThenReuses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "validator.key")
+	key, created, err := LoadKeyFile(path)
+	if err != nil || !created {
+		t.Fatalf("first load: created %v, %v", created, err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("key file: %v, %v", info, err)
+	}
+	again, created, err := LoadKeyFile(path)
+	if err != nil || created {
+		t.Fatalf("second load: created %v, %v", created, err)
+	}
+	if again.D.Cmp(key.D) != 0 {
+		t.Fatal("reloaded a different key")
+	}
+
+	if err := os.WriteFile(path, []byte("not a key\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := LoadKeyFile(path); err == nil {
+		t.Fatal("garbage key file accepted")
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebSocket opcodes (RFC 6455 section 5.2)
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// WebSocket close codes and protocol limits
+const (
+	wsCloseNormal         = 1000
+	wsCloseProtocolError  = 1002
+	wsClosePolicy         = 1008
+	wsCloseMessageTooBig  = 1009
+	wsHandshakeGUID       = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsWriteTimeout        = 10 * time.Second
+	wsPingInterval        = 30 * time.Second
+	wsMaxControlFrameSize = 125
+)
+
+var (
+	errWSClosed          = errors.New("websocket closed")
+	errWSProtocol        = errors.New("websocket protocol error")
+	errWSMessageTooLarge = errors.New("websocket message too large")
+)
+
+// wsConn is the server side of a WebSocket connection. It supports what a
+// JSON-RPC client needs: text messages, fragmentation, ping/pong and close.
+// Reads must come from a single goroutine; writes may be concurrent.
+type wsConn struct {
+	conn       net.Conn
+	reader     *bufio.Reader
+	maxMessage int64
+	writeMu    sync.Mutex
+	closeOnce  sync.Once
+}
+
+// upgradeWebSocket performs the opening handshake and takes over the
+// underlying connection
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request, maxMessage int64) (*wsConn, error) {
+	if r.Method != "GET" ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "WebSocket upgrade required", http.StatusUpgradeRequired)
+		return nil, errors.New("not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "Missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("missing websocket key")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("connection cannot be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + wsHandshakeGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, reader: rw.Reader, maxMessage: maxMessage}, nil
+}
+
+// headerContains reports whether a comma-separated header has token
+func headerContains(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ReadMessage returns the next complete data message, answering pings and
+// close frames along the way
+func (c *wsConn) ReadMessage() ([]byte, error) {
+	var message []byte
+	started := false
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			switch err {
+			case errWSProtocol:
+				c.Close(wsCloseProtocolError, "protocol error")
+			case errWSMessageTooLarge:
+				c.Close(wsCloseMessageTooBig, "message too large")
+			}
+			return nil, err
+		}
+
+		switch opcode {
+		case wsPing:
+			c.writeFrame(wsPong, payload)
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			c.Close(wsCloseNormal, "")
+			return nil, errWSClosed
+		case wsText, wsBinary:
+			if started {
+				c.Close(wsCloseProtocolError, "expected continuation frame")
+				return nil, errWSProtocol
+			}
+			started = true
+		case wsContinuation:
+			if !started {
+				c.Close(wsCloseProtocolError, "unexpected continuation frame")
+				return nil, errWSProtocol
+			}
+		default:
+			c.Close(wsCloseProtocolError, "unknown opcode")
+			return nil, errWSProtocol
+		}
+
+		if int64(len(message)+len(payload)) > c.maxMessage {
+			c.Close(wsCloseMessageTooBig, "message too large")
+			return nil, errWSMessageTooLarge
+		}
+		message = append(message, payload...)
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+// readFrame reads and unmasks a single frame
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(c.reader, header[:]); err != nil {
+		return
+	}
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0F
+	if header[0]&0x70 != 0 || header[1]&0x80 == 0 {
+		// Reserved bits need an extension; client frames must be masked
+		return false, 0, nil, errWSProtocol
+	}
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.reader, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.reader, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if opcode >= wsClose && (!fin || length > wsMaxControlFrameSize) {
+		return false, 0, nil, errWSProtocol
+	}
+	if length > uint64(c.maxMessage) {
+		return false, 0, nil, errWSMessageTooLarge
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(c.reader, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.reader, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// writeFrame sends a single unmasked frame
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n <= 125:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126, byte(n>>8), byte(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// WriteJSON sends v as a text message
+func (c *wsConn) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(wsText, data)
+}
+
+// Ping sends a keepalive ping
+func (c *wsConn) Ping() error {
+	return c.writeFrame(wsPing, nil)
+}
+
+// Close sends a close frame with code and reason and closes the connection
+func (c *wsConn) Close(code uint16, reason string) {
+	c.closeOnce.Do(func() {
+		payload := binary.BigEndian.AppendUint16(nil, code)
+		if len(reason) > wsMaxControlFrameSize-2 {
+			reason = reason[:wsMaxControlFrameSize-2]
+		}
+		c.writeFrame(wsClose, append(payload, reason...))
+		c.conn.Close()
+	})
+}